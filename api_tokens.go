@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Personal access tokens let scripts call the API with
+// "Authorization: Bearer <token>" instead of a session cookie.
+
+const apiTokenPrefix = "oct_"
+
+const apiTokenContextKey contextKey = "api_token"
+
+// Scopes a token may be granted; each is a permission (see rbac.go) and is
+// checked in addition to the owner's role.
+var validScopes = []string{PermServersRead, PermServersWrite, PermPairingWrite}
+
+func apiTokenFromContext(ctx context.Context) *APIToken {
+	t, _ := ctx.Value(apiTokenContextKey).(*APIToken)
+	return t
+}
+
+func newAPIToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = apiTokenPrefix + hex.EncodeToString(b)
+	return token, hashAPIToken(token), nil
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// bearerToken returns the API token from the Authorization header, if any.
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(auth, "Bearer ")
+	if !ok || !strings.HasPrefix(token, apiTokenPrefix) {
+		return "", false
+	}
+	return token, true
+}
+
+// Token handlers (session only: a token cannot mint or revoke tokens)
+
+func (s *Server) handleCreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+
+	var req CreateAPITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "name is required"})
+		return
+	}
+	if len(req.Scopes) == 0 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "at least one scope is required"})
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(validScopes, scope) {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "unknown scope: " + scope})
+			return
+		}
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > 365 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "expires_in_days must be between 0 and 365"})
+		return
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().Add(time.Duration(req.ExpiresInDays) * 24 * time.Hour)
+		expiresAt = &t
+	}
+
+	token, hash, err := newAPIToken()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "internal error"})
+		return
+	}
+
+	info, err := s.store.CreateAPIToken(user.ID, req.Name, hash, token[:len(apiTokenPrefix)+8], req.Scopes, expiresAt)
+	if err != nil {
+		slog.Error("failed to create api token", "user_id", user.ID, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to create token"})
+		return
+	}
+	slog.Info("api token created", "user_id", user.ID, "token_id", info.ID, "scopes", req.Scopes)
+
+	writeJSON(w, http.StatusCreated, CreateAPITokenResponse{APIToken: info, Token: token})
+}
+
+func (s *Server) handleListAPITokens(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+
+	tokens, err := s.store.ListAPITokens(user.ID)
+	if err != nil {
+		slog.Error("failed to list api tokens", "user_id", user.ID, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to list tokens"})
+		return
+	}
+	if tokens == nil {
+		tokens = []*APIToken{}
+	}
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+func (s *Server) handleRevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid token id"})
+		return
+	}
+
+	if err := s.store.DeleteAPIToken(id, user.ID); err != nil {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "token not found"})
+		return
+	}
+	slog.Info("api token revoked", "user_id", user.ID, "token_id", id)
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "revoked"})
+}
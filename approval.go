@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Automatic approval. New wallets are unapproved until an admin approves
+// them, unless they redeem an invite code (see also invites.go) or match an
+// approval rule at sign-in: an allowlisted address or a sufficient
+// ERC-20/ERC-721 balance (read via ETH_RPC_URL).
+
+const (
+	RuleAllowlist = "allowlist"
+	RuleERC20     = "erc20"
+	RuleERC721    = "erc721"
+)
+
+const inviteCodePrefix = "inv_"
+
+func newInviteCode() (code, hash string, err error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	code = inviteCodePrefix + hex.EncodeToString(b)
+	return code, hashAPIToken(code), nil
+}
+
+// evaluateApproval reports whether a new sign-in matches an approval rule,
+// and which one. The allowlist is checked before any RPC calls.
+func (s *Server) evaluateApproval(ctx context.Context, user *User) (string, bool) {
+	if user.Address == "" {
+		return "", false // passkey-only users have nothing to match
+	}
+	rules, err := s.store.ListApprovalRules()
+	if err != nil {
+		slog.Error("failed to load approval rules", "error", err)
+	}
+
+	for _, rule := range rules {
+		if rule.Type == RuleAllowlist && rule.Address == user.Address {
+			return "allowlist", true
+		}
+	}
+
+	if s.eth != nil {
+		owner := common.HexToAddress(user.Address)
+		for _, rule := range rules {
+			if rule.Type != RuleERC20 && rule.Type != RuleERC721 {
+				continue
+			}
+			threshold, ok := new(big.Int).SetString(rule.MinBalance, 10)
+			if !ok {
+				threshold = big.NewInt(1)
+			}
+			ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			balance, err := s.eth.BalanceOf(ctx, common.HexToAddress(rule.Address), owner)
+			cancel()
+			if err != nil {
+				slog.Warn("token balance check failed", "rule_id", rule.ID, "contract", rule.Address, "error", err)
+				continue
+			}
+			if balance.Cmp(threshold) >= 0 {
+				return rule.Type + ":" + rule.Address, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// Approval rule handlers (admin)
+
+func (s *Server) handleListApprovalRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := s.store.ListApprovalRules()
+	if err != nil {
+		slog.Error("failed to list approval rules", "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to list rules"})
+		return
+	}
+	if rules == nil {
+		rules = []*ApprovalRule{}
+	}
+	writeJSON(w, http.StatusOK, rules)
+}
+
+func (s *Server) handleCreateApprovalRule(w http.ResponseWriter, r *http.Request) {
+	var rule ApprovalRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	rule.Address = strings.ToLower(rule.Address)
+	if !addressRegex.MatchString(rule.Address) {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid address format"})
+		return
+	}
+
+	switch rule.Type {
+	case RuleAllowlist:
+		rule.MinBalance = ""
+	case RuleERC20, RuleERC721:
+		if s.eth == nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "token rules require ETH_RPC_URL"})
+			return
+		}
+		if rule.MinBalance == "" {
+			rule.MinBalance = "1"
+		}
+		threshold, ok := new(big.Int).SetString(rule.MinBalance, 10)
+		if !ok || threshold.Sign() <= 0 {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "min_balance must be a positive integer"})
+			return
+		}
+		rule.MinBalance = threshold.String()
+	default:
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "type must be one of allowlist, erc20, erc721"})
+		return
+	}
+
+	if err := s.store.CreateApprovalRule(&rule); err != nil {
+		slog.Error("failed to create approval rule", "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to create rule"})
+		return
+	}
+	slog.Info("approval rule created", "rule_id", rule.ID, "type", rule.Type, "address", rule.Address)
+
+	writeJSON(w, http.StatusCreated, rule)
+}
+
+func (s *Server) handleDeleteApprovalRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid rule id"})
+		return
+	}
+
+	if err := s.store.DeleteApprovalRule(id); err != nil {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "rule not found"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// Invite code handlers (admin)
+
+func (s *Server) handleCreateInviteCode(w http.ResponseWriter, r *http.Request) {
+	admin := userFromContext(r.Context())
+
+	var req struct {
+		ExpiresInDays int `json:"expires_in_days"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ExpiresInDays < 0 {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+			return
+		}
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().Add(time.Duration(req.ExpiresInDays) * 24 * time.Hour)
+		expiresAt = &t
+	}
+
+	code, hash, err := newInviteCode()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "internal error"})
+		return
+	}
+	invite, err := s.store.CreateInviteCode(hash, admin.ID, expiresAt, "", nil, 1)
+	if err != nil {
+		slog.Error("failed to create invite code", "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to create invite code"})
+		return
+	}
+	invite.Code = code
+	slog.Info("invite code created", "invite_id", invite.ID, "by", admin.ID)
+
+	writeJSON(w, http.StatusCreated, invite)
+}
+
+func (s *Server) handleListInviteCodes(w http.ResponseWriter, r *http.Request) {
+	invites, err := s.store.ListInviteCodes()
+	if err != nil {
+		slog.Error("failed to list invite codes", "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to list invite codes"})
+		return
+	}
+	if invites == nil {
+		invites = []*InviteCode{}
+	}
+	writeJSON(w, http.StatusOK, invites)
+}
+
+func (s *Server) handleDeleteInviteCode(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid invite id"})
+		return
+	}
+
+	if err := s.store.DeleteInviteCode(id); err != nil {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "invite code not found"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Approval rule operations
+
+func (s *Store) CreateApprovalRule(rule *ApprovalRule) error {
+	return s.db.QueryRow(`
+		INSERT INTO approval_rules (type, address, min_balance, note)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`, rule.Type, rule.Address, rule.MinBalance, rule.Note).Scan(&rule.ID, &rule.CreatedAt)
+}
+
+func (s *Store) ListApprovalRules() ([]*ApprovalRule, error) {
+	rows, err := s.db.Query(`
+		SELECT id, type, address, min_balance, note, created_at
+		FROM approval_rules ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []*ApprovalRule
+	for rows.Next() {
+		var rule ApprovalRule
+		if err := rows.Scan(&rule.ID, &rule.Type, &rule.Address, &rule.MinBalance, &rule.Note, &rule.CreatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, &rule)
+	}
+	return rules, rows.Err()
+}
+
+func (s *Store) DeleteApprovalRule(id int64) error {
+	result, err := s.db.Exec(`DELETE FROM approval_rules WHERE id=$1`, id)
+	if err != nil {
+		return err
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("rule not found")
+	}
+	return nil
+}
+
+// Invite code operations
+
+const inviteCodeColumns = `id, role, max_servers, max_uses, uses, COALESCE(redeemed_by, 0), redeemed_at, expires_at, created_at`
+
+func scanInviteCode(row interface{ Scan(...any) error }) (*InviteCode, error) {
+	var invite InviteCode
+	var maxServers sql.NullInt64
+	var redeemedAt, expiresAt sql.NullString
+	err := row.Scan(&invite.ID, &invite.Role, &maxServers, &invite.MaxUses, &invite.Uses,
+		&invite.RedeemedBy, &redeemedAt, &expiresAt, &invite.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	invite.MaxServers = nullIntPtr(maxServers)
+	invite.RedeemedAt = redeemedAt.String
+	invite.ExpiresAt = expiresAt.String
+	return &invite, nil
+}
+
+// CreateInviteCode stores a new code by hash. Plain invite codes have no
+// role or quota and a single use.
+func (s *Store) CreateInviteCode(codeHash string, createdBy int64, expiresAt *time.Time, role string, maxServers *int, maxUses int) (*InviteCode, error) {
+	return scanInviteCode(s.db.QueryRow(`
+		INSERT INTO invite_codes (code_hash, created_by, expires_at, role, max_servers, max_uses)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+inviteCodeColumns, codeHash, createdBy, expiresAt, role, maxServers, maxUses))
+}
+
+func (s *Store) ListInviteCodes() ([]*InviteCode, error) {
+	rows, err := s.db.Query(`SELECT ` + inviteCodeColumns + ` FROM invite_codes ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invites []*InviteCode
+	for rows.Next() {
+		invite, err := scanInviteCode(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+func (s *Store) DeleteInviteCode(id int64) error {
+	result, err := s.db.Exec(`DELETE FROM invite_codes WHERE id=$1`, id)
+	if err != nil {
+		return err
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("invite code not found")
+	}
+	return nil
+}
+
+// RedeemInviteCode takes one use of an unexpired code for userID, then
+// approves the user and applies the code's role and server quota. Returns
+// sql.ErrNoRows if the code is unknown, used up or expired.
+func (s *Store) RedeemInviteCode(codeHash string, userID int64) (*InviteCode, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	invite, err := scanInviteCode(tx.QueryRow(`
+		UPDATE invite_codes SET uses=uses+1, redeemed_by=$2, redeemed_at=now()
+		WHERE code_hash=$1 AND (max_uses=0 OR uses < max_uses) AND (expires_at IS NULL OR expires_at > now())
+		RETURNING `+inviteCodeColumns, codeHash, userID))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE users SET approved=true,
+			role=COALESCE(NULLIF($2, ''), role),
+			max_servers=COALESCE($3, max_servers)
+		WHERE id=$1
+	`, userID, invite.Role, invite.MaxServers); err != nil {
+		return nil, err
+	}
+
+	return invite, tx.Commit()
+}
@@ -0,0 +1,34 @@
+package main
+
+// Server action audit log operations
+
+func (s *Store) RecordServerAction(serverID, userID int64, action, target string, status int) error {
+	_, err := s.db.Exec(`
+		INSERT INTO server_actions (server_id, user_id, action, target, status) VALUES ($1, $2, $3, $4, $5)
+	`, serverID, userID, action, target, status)
+	return err
+}
+
+// ListServerActions returns a server's most recent actions, newest first.
+func (s *Store) ListServerActions(serverID int64, limit int) ([]ServerAction, error) {
+	rows, err := s.db.Query(`
+		SELECT a.id, a.server_id, COALESCE(a.user_id, 0), COALESCE(u.address, ''), a.action, a.target, a.status, a.created_at
+		FROM server_actions a LEFT JOIN users u ON u.id = a.user_id
+		WHERE a.server_id=$1
+		ORDER BY a.id DESC LIMIT $2
+	`, serverID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := []ServerAction{}
+	for rows.Next() {
+		var a ServerAction
+		if err := rows.Scan(&a.ID, &a.ServerID, &a.UserID, &a.UserAddress, &a.Action, &a.Target, &a.Status, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		actions = append(actions, a)
+	}
+	return actions, rows.Err()
+}
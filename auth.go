@@ -2,7 +2,7 @@ package main
 
 import (
 	"context"
-	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
@@ -12,7 +12,6 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -28,65 +27,55 @@ func userFromContext(ctx context.Context) *User {
 	return u
 }
 
-// ChallengeStore holds pending challenges in memory with expiration.
+// ChallengeStore holds pending sign-in challenges in PostgreSQL so that any
+// instance can verify a challenge issued by another. Each challenge can be
+// consumed exactly once.
 type ChallengeStore struct {
-	mu         sync.Mutex
-	challenges map[string]challengeEntry
+	store *Store
 }
 
-type challengeEntry struct {
-	address   string
-	expiresAt time.Time
+func NewChallengeStore(store *Store) *ChallengeStore {
+	return &ChallengeStore{store: store}
 }
 
-func NewChallengeStore() *ChallengeStore {
-	return &ChallengeStore{
-		challenges: make(map[string]challengeEntry),
-	}
-}
-
-func (cs *ChallengeStore) Create(address string) string {
-	b := make([]byte, 32)
-	rand.Read(b)
-	nonce := hex.EncodeToString(b)
-	challenge := fmt.Sprintf("Sign in to openclaw creator\n\nNonce: %s", nonce)
-
-	cs.mu.Lock()
-	cs.challenges[challenge] = challengeEntry{
-		address:   address,
-		expiresAt: time.Now().Add(5 * time.Minute),
+// Create registers a sign-in message until its expiration time and returns
+// the text the wallet must sign. A failed write is retried once; if it
+// still fails the challenge is logged and returned anyway, and Consume
+// rejects it like an expired one.
+func (cs *ChallengeStore) Create(msg *SIWEMessage) string {
+	challenge := msg.String()
+	key, address := challengeKey(challenge), strings.ToLower(msg.Address)
+	if err := cs.store.CreateChallenge(key, address, msg.ExpirationTime); err != nil {
+		if err = cs.store.CreateChallenge(key, address, msg.ExpirationTime); err != nil {
+			slog.Error("failed to store challenge", "address", address, "error", err)
+		}
 	}
-	cs.mu.Unlock()
-
 	return challenge
 }
 
 func (cs *ChallengeStore) Consume(challenge, address string) bool {
-	cs.mu.Lock()
-	defer cs.mu.Unlock()
-
-	entry, ok := cs.challenges[challenge]
-	if !ok {
+	entryAddress, expiresAt, err := cs.store.ConsumeChallenge(challengeKey(challenge))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			slog.Error("failed to consume challenge", "address", address, "error", err)
+		}
 		return false
 	}
-	delete(cs.challenges, challenge)
-
-	if time.Now().After(entry.expiresAt) {
+	if time.Now().After(expiresAt) {
 		return false
 	}
-	return entry.address == address
+	return entryAddress == address
 }
 
 func (cs *ChallengeStore) Cleanup() {
-	cs.mu.Lock()
-	defer cs.mu.Unlock()
+	cs.store.CleanExpiredChallenges()
+}
 
-	now := time.Now()
-	for k, v := range cs.challenges {
-		if now.After(v.expiresAt) {
-			delete(cs.challenges, k)
-		}
-	}
+// challengeKey is the primary key for a challenge: its SHA-256, so full
+// messages aren't indexed.
+func challengeKey(challenge string) string {
+	sum := sha256.Sum256([]byte(challenge))
+	return hex.EncodeToString(sum[:])
 }
 
 // verifyEthSignature recovers the Ethereum address from an EIP-191 personal_sign signature.
@@ -104,9 +93,7 @@ func verifyEthSignature(challenge, signatureHex string) (common.Address, error)
 		sigBytes[64] -= 27
 	}
 
-	// EIP-191 prefix
-	msg := fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(challenge), challenge)
-	hash := ethcrypto.Keccak256Hash([]byte(msg))
+	hash := eip191Hash(challenge)
 
 	pubBytes, err := ethcrypto.Ecrecover(hash.Bytes(), sigBytes)
 	if err != nil {
@@ -121,23 +108,89 @@ func verifyEthSignature(challenge, signatureHex string) (common.Address, error)
 	return ethcrypto.PubkeyToAddress(*pubKey), nil
 }
 
+// eip191Hash returns the personal_sign digest of msg.
+func eip191Hash(msg string) common.Hash {
+	prefixed := fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(msg), msg)
+	return ethcrypto.Keccak256Hash([]byte(prefixed))
+}
+
+// verifyWalletSignature checks that signatureHex is a valid signature of
+// challenge by address. EOAs are verified with ecrecover; when an Ethereum RPC
+// endpoint is configured, smart-contract wallets (Safe, ERC-4337 accounts) are
+// verified on-chain via EIP-1271 isValidSignature.
+func (s *Server) verifyWalletSignature(ctx context.Context, challenge, signatureHex, address string) error {
+	wallet := common.HexToAddress(address)
+
+	isContract := false
+	if s.eth != nil {
+		code, err := s.eth.GetCode(ctx, wallet)
+		if err != nil {
+			slog.Warn("eth_getCode failed, falling back to ecrecover", "address", address, "error", err)
+		}
+		isContract = len(code) > 0
+	}
+
+	var ecErr error
+	if !isContract {
+		recovered, err := verifyEthSignature(challenge, signatureHex)
+		if err == nil && recovered == wallet {
+			return nil
+		}
+		if err == nil {
+			ecErr = fmt.Errorf("signature does not match address (recovered %s)", recovered.Hex())
+		} else {
+			ecErr = err
+		}
+		if s.eth == nil {
+			return ecErr
+		}
+	}
+
+	sig, err := hex.DecodeString(strings.TrimPrefix(signatureHex, "0x"))
+	if err != nil {
+		return fmt.Errorf("decode signature: %w", err)
+	}
+	ok, err := s.eth.IsValidSignature(ctx, wallet, eip191Hash(challenge), sig)
+	if err != nil {
+		if ecErr != nil {
+			return fmt.Errorf("%w; EIP-1271: %v", ecErr, err)
+		}
+		return fmt.Errorf("EIP-1271: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("EIP-1271: contract rejected signature")
+	}
+	return nil
+}
+
 var addressRegex = regexp.MustCompile(`^0x[0-9a-f]{40}$`)
 
-// sessionAuth extracts the user from the session cookie and adds it to context.
+// sessionAuth extracts the user from the session cookie (or an API bearer
+// token) and adds it to context.
 // Returns nil user if not authenticated (does NOT write error response).
 func (s *Server) sessionAuth(r *http.Request) (*User, *http.Request) {
-	cookie, err := r.Cookie("session")
-	if err != nil {
-		return nil, r
+	if token, ok := bearerToken(r); ok {
+		apiToken, err := s.store.GetAPITokenByHash(hashAPIToken(token))
+		if err != nil {
+			return nil, r
+		}
+		user, err := s.store.GetUserByID(apiToken.UserID)
+		if err != nil || user.Suspended {
+			return nil, r
+		}
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+		ctx = context.WithValue(ctx, apiTokenContextKey, apiToken)
+		return user, r.WithContext(ctx)
 	}
 
-	session, err := s.store.GetSession(cookie.Value)
-	if err != nil {
+	// trackSessions has resolved (and possibly rotated) the cookie's session
+	session := sessionFromContext(r.Context())
+	if session == nil {
 		return nil, r
 	}
 
 	user, err := s.store.GetUserByID(session.UserID)
-	if err != nil {
+	if err != nil || user.Suspended {
 		return nil, r
 	}
 
@@ -145,7 +198,8 @@ func (s *Server) sessionAuth(r *http.Request) (*User, *http.Request) {
 	return user, r.WithContext(ctx)
 }
 
-// requireApproved wraps a handler to require an authenticated and approved user.
+// requireApproved wraps a handler to require an authenticated and approved user
+// with a browser session. API tokens are rejected; see requirePermission.
 func (s *Server) requireApproved(handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user, r := s.sessionAuth(r)
@@ -153,6 +207,10 @@ func (s *Server) requireApproved(handler http.HandlerFunc) http.HandlerFunc {
 			writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "unauthorized"})
 			return
 		}
+		if apiTokenFromContext(r.Context()) != nil {
+			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "not available to API tokens"})
+			return
+		}
 		if !user.Approved {
 			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "account not approved"})
 			return
@@ -169,7 +227,11 @@ func (s *Server) requireAdmin(handler http.HandlerFunc) http.HandlerFunc {
 			writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "unauthorized"})
 			return
 		}
-		if user.Role != "admin" {
+		if apiTokenFromContext(r.Context()) != nil {
+			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "not available to API tokens"})
+			return
+		}
+		if !user.Can(PermUsersManage) {
 			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "admin required"})
 			return
 		}
@@ -192,7 +254,15 @@ func (s *Server) handleChallenge(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	challenge := s.challenges.Create(req.Address)
+	domain, uri := s.siweOrigin()
+	msg, err := newSIWEMessage(domain, uri, req.Address, s.config.SIWEChainID)
+	if err != nil {
+		slog.Error("failed to create sign-in message", "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "internal error"})
+		return
+	}
+
+	challenge := s.challenges.Create(msg)
 	writeJSON(w, http.StatusOK, ChallengeResponse{Challenge: challenge})
 }
 
@@ -214,22 +284,39 @@ func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Verify challenge is valid and matches address
+	// Parse and validate the EIP-4361 message before touching the signature
+	msg, err := parseSIWEMessage(req.Challenge)
+	if err != nil {
+		slog.Warn("malformed sign-in message", "address", req.Address, "error", err)
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "malformed sign-in message"})
+		return
+	}
+	if strings.ToLower(msg.Address) != req.Address {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "sign-in message address mismatch"})
+		return
+	}
+	domain, uri := s.siweOrigin()
+	if err := msg.Validate(domain, uri, s.config.SIWEChainID, time.Now()); err != nil {
+		slog.Warn("sign-in message rejected", "address", req.Address, "error", err)
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "invalid sign-in message: " + err.Error()})
+		return
+	}
+
+	// Verify challenge was issued by us, is unused, and matches address
 	if !s.challenges.Consume(req.Challenge, req.Address) {
 		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "invalid or expired challenge"})
 		return
 	}
 
-	// Recover address from signature via ecrecover
-	recovered, err := verifyEthSignature(req.Challenge, req.Signature)
-	if err != nil {
+	// Verify signature via ecrecover, or EIP-1271 for contract wallets
+	if err := s.verifyWalletSignature(r.Context(), req.Challenge, req.Signature, req.Address); err != nil {
 		slog.Warn("signature verification failed", "address", req.Address, "error", err)
 		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "invalid signature"})
 		return
 	}
-	if strings.ToLower(recovered.Hex()) != req.Address {
-		slog.Warn("recovered address mismatch", "expected", req.Address, "recovered", recovered.Hex())
-		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "signature does not match address"})
+
+	if req.Link {
+		s.linkWallet(w, r, req.Address)
 		return
 	}
 
@@ -249,41 +336,108 @@ func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.signIn(w, r, user, req.InviteCode)
+}
+
+// signInError is a sign-in refusal, with the status to answer it with.
+type signInError struct {
+	status int
+	msg    string
+}
+
+func (e *signInError) Error() string { return e.msg }
+
+// startSession completes a sign-in (wallet, passkey or SSO): it refuses
+// suspended users, applies automatic approval to unapproved ones, and sets
+// the session cookie. Refusals are returned as *signInError.
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, user *User, inviteCode string) (*User, error) {
+	if user.Suspended {
+		slog.Warn("sign-in by suspended user", "user_id", user.ID)
+		return nil, &signInError{http.StatusForbidden, "account suspended"}
+	}
+
+	// New users may be approved by an invite link or an approval rule
+	if !user.Approved && inviteCode != "" {
+		invited, err := s.redeemInvite(strings.TrimSpace(inviteCode), user)
+		if err != nil {
+			slog.Warn("invite rejected", "user_id", user.ID, "error", err)
+		} else {
+			user = invited
+			slog.Info("user approved by invite", "user_id", user.ID, "address", user.Address, "role", user.Role)
+		}
+	}
+	if !user.Approved {
+		if via, ok := s.evaluateApproval(r.Context(), user); ok {
+			if err := s.store.ApproveUser(user.ID); err != nil {
+				slog.Error("failed to auto-approve user", "user_id", user.ID, "error", err)
+			} else {
+				user.Approved = true
+				slog.Info("user auto-approved", "user_id", user.ID, "address", user.Address, "via", via)
+			}
+		}
+	}
+
 	// Create session
-	session, err := s.store.CreateSession(user.ID)
+	session, err := s.store.CreateSession(user.ID, r.UserAgent(), clientIP(r))
 	if err != nil {
 		slog.Error("create session failed", "error", err)
-		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "internal error"})
+		return nil, &signInError{http.StatusInternalServerError, "internal error"}
+	}
+
+	setSessionCookie(w, r, session.ID)
+	return user, nil
+}
+
+// signIn is startSession for the JSON sign-in endpoints.
+func (s *Server) signIn(w http.ResponseWriter, r *http.Request, user *User, inviteCode string) {
+	user, err := s.startSession(w, r, user, inviteCode)
+	if err != nil {
+		serr := err.(*signInError)
+		writeJSON(w, serr.status, ErrorResponse{Error: serr.msg})
+		return
+	}
+	writeJSON(w, http.StatusOK, AuthResponse{User: user})
+}
+
+// linkWallet attaches a verified wallet address to the signed-in account,
+// for users who registered with a passkey.
+func (s *Server) linkWallet(w http.ResponseWriter, r *http.Request, address string) {
+	user, _ := s.sessionAuth(r)
+	if user == nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "sign in to link a wallet"})
+		return
+	}
+	if user.Address != "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "account already has a wallet"})
+		return
+	}
+	if _, err := s.store.GetUserByAddress(address); err == nil {
+		writeJSON(w, http.StatusConflict, ErrorResponse{Error: "address belongs to another account"})
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session",
-		Value:    session.ID,
-		Path:     "/",
-		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   30 * 24 * 60 * 60,
-	})
+	if err := s.store.SetUserAddress(user.ID, address); err != nil {
+		slog.Error("failed to link wallet", "user_id", user.ID, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to link wallet"})
+		return
+	}
+	user.Address = address
+	slog.Info("wallet linked", "user_id", user.ID, "address", address)
 
 	writeJSON(w, http.StatusOK, AuthResponse{User: user})
 }
 
 func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("session")
+	cookie, err := r.Cookie(sessionCookieName)
 	if err == nil {
 		s.store.DeleteSession(cookie.Value)
 	}
+	if session := sessionFromContext(r.Context()); session != nil && (cookie == nil || session.ID != cookie.Value) {
+		// Logging out right after a rotation: drop the replacement too
+		s.store.DeleteSession(session.ID)
+	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session",
-		Value:    "",
-		Path:     "/",
-		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   -1,
-		Expires:  time.Unix(0, 0),
-	})
+	clearSessionCookie(w, r)
 
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
@@ -294,7 +448,16 @@ func (s *Server) handleMe(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "unauthorized"})
 		return
 	}
-	writeJSON(w, http.StatusOK, AuthResponse{User: user})
+
+	resp := AuthResponse{User: user}
+	if user.Approved {
+		usage, err := s.quotaUsage(user)
+		if err != nil {
+			slog.Error("failed to load quota usage", "user_id", user.ID, "error", err)
+		}
+		resp.Quota = usage
+	}
+	writeJSON(w, http.StatusOK, resp)
 }
 
 func (s *Server) handleSetSSHKey(w http.ResponseWriter, r *http.Request) {
@@ -343,25 +506,3 @@ func (s *Server) handleApproveUser(w http.ResponseWriter, r *http.Request) {
 
 	writeJSON(w, http.StatusOK, map[string]string{"status": "approved"})
 }
-
-func (s *Server) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
-	if err != nil {
-		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid user id"})
-		return
-	}
-
-	// Don't allow deleting yourself
-	user := userFromContext(r.Context())
-	if user != nil && user.ID == id {
-		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "cannot delete yourself"})
-		return
-	}
-
-	if err := s.store.DeleteUser(id); err != nil {
-		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to delete user"})
-		return
-	}
-
-	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
-}
@@ -2,13 +2,36 @@ package main
 
 import (
 	"crypto/rand"
+	"database/sql"
 	"encoding/hex"
+	"fmt"
 	"log/slog"
+	"strings"
 	"time"
 )
 
 // User operations
 
+const userColumns = `id, address, public_key, role, approved, (suspended_at IS NOT NULL), ssh_public_key, created_at,
+	max_servers, max_provisioning, server_types`
+
+func scanUser(row interface{ Scan(...any) error }) (*User, error) {
+	var user User
+	var maxServers, maxProvisioning sql.NullInt64
+	var serverTypes sql.NullString
+	err := row.Scan(&user.ID, &user.Address, &user.PublicKey, &user.Role, &user.Approved, &user.Suspended, &user.SSHPublicKey, &user.CreatedAt,
+		&maxServers, &maxProvisioning, &serverTypes)
+	if err != nil {
+		return nil, err
+	}
+	user.MaxServers = nullIntPtr(maxServers)
+	user.MaxProvisioning = nullIntPtr(maxProvisioning)
+	if serverTypes.Valid {
+		user.ServerTypes = splitList(serverTypes.String)
+	}
+	return &user, nil
+}
+
 func (s *Store) CreateUser(address, publicKey string) (*User, error) {
 	// Check if this is the first user
 	count, err := s.CountUsers()
@@ -16,21 +39,17 @@ func (s *Store) CreateUser(address, publicKey string) (*User, error) {
 		return nil, err
 	}
 
-	role := "user"
+	role := DefaultRole
 	approved := false
 	if count == 0 {
-		role = "admin"
+		role = RoleAdmin
 		approved = true
 	}
 
-	var user User
-	err = s.db.QueryRow(`
+	user, err := scanUser(s.db.QueryRow(`
 		INSERT INTO users (address, public_key, role, approved)
 		VALUES ($1, $2, $3, $4)
-		RETURNING id, address, public_key, role, approved, ssh_public_key, created_at
-	`, address, publicKey, role, approved).Scan(
-		&user.ID, &user.Address, &user.PublicKey, &user.Role, &user.Approved, &user.SSHPublicKey, &user.CreatedAt,
-	)
+		RETURNING `+userColumns, address, publicKey, role, approved))
 	if err != nil {
 		return nil, err
 	}
@@ -40,38 +59,19 @@ func (s *Store) CreateUser(address, publicKey string) (*User, error) {
 		s.BackfillFirstAdmin()
 	}
 
-	return &user, nil
+	return user, nil
 }
 
 func (s *Store) GetUserByAddress(address string) (*User, error) {
-	var user User
-	err := s.db.QueryRow(`
-		SELECT id, address, public_key, role, approved, ssh_public_key, created_at
-		FROM users WHERE address=$1
-	`, address).Scan(&user.ID, &user.Address, &user.PublicKey, &user.Role, &user.Approved, &user.SSHPublicKey, &user.CreatedAt)
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE address=$1`, address))
 }
 
 func (s *Store) GetUserByID(id int64) (*User, error) {
-	var user User
-	err := s.db.QueryRow(`
-		SELECT id, address, public_key, role, approved, ssh_public_key, created_at
-		FROM users WHERE id=$1
-	`, id).Scan(&user.ID, &user.Address, &user.PublicKey, &user.Role, &user.Approved, &user.SSHPublicKey, &user.CreatedAt)
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE id=$1`, id))
 }
 
 func (s *Store) ListUsers() ([]*User, error) {
-	rows, err := s.db.Query(`
-		SELECT id, address, public_key, role, approved, ssh_public_key, created_at
-		FROM users ORDER BY created_at DESC
-	`)
+	rows, err := s.db.Query(`SELECT ` + userColumns + ` FROM users ORDER BY created_at DESC`)
 	if err != nil {
 		return nil, err
 	}
@@ -79,11 +79,11 @@ func (s *Store) ListUsers() ([]*User, error) {
 
 	var users []*User
 	for rows.Next() {
-		var user User
-		if err := rows.Scan(&user.ID, &user.Address, &user.PublicKey, &user.Role, &user.Approved, &user.SSHPublicKey, &user.CreatedAt); err != nil {
+		user, err := scanUser(rows)
+		if err != nil {
 			return nil, err
 		}
-		users = append(users, &user)
+		users = append(users, user)
 	}
 	return users, rows.Err()
 }
@@ -98,9 +98,59 @@ func (s *Store) ApproveUser(id int64) error {
 	return err
 }
 
-func (s *Store) DeleteUser(id int64) error {
-	_, err := s.db.Exec(`DELETE FROM users WHERE id=$1`, id)
-	return err
+func (s *Store) SetUserRole(id int64, role string) error {
+	result, err := s.db.Exec(`UPDATE users SET role=$1 WHERE id=$2`, role, id)
+	if err != nil {
+		return err
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SetUserSuspended suspends or reinstates a user. Returns sql.ErrNoRows if
+// the user doesn't exist.
+func (s *Store) SetUserSuspended(id int64, suspended bool) error {
+	result, err := s.db.Exec(`
+		UPDATE users SET suspended_at=CASE WHEN $2 THEN COALESCE(suspended_at, now()) END WHERE id=$1
+	`, id, suspended)
+	if err != nil {
+		return err
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteUser removes a user. Org servers they created stay in their org but
+// pass to another of its owners, or to heirID if it has none, so none is
+// left without an owner (user_id is SET NULL on delete).
+func (s *Store) DeleteUser(id, heirID int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE servers SET user_id = COALESCE((
+			SELECT m.user_id FROM org_members m
+			WHERE m.org_id = servers.org_id AND m.role = 'owner' AND m.user_id <> $1
+			ORDER BY m.created_at LIMIT 1
+		), $2)
+		WHERE user_id=$1 AND org_id IS NOT NULL
+	`, id, heirID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM users WHERE id=$1`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (s *Store) CountUsers() (int64, error) {
@@ -111,36 +161,139 @@ func (s *Store) CountUsers() (int64, error) {
 
 // Session operations
 
-func (s *Store) CreateSession(userID int64) (*Session, error) {
+const sessionColumns = `id, public_id, user_id, user_agent, ip, created_at, last_seen_at, expires_at, replaced`
+
+func scanSession(row interface{ Scan(...any) error }) (*Session, error) {
+	var session Session
+	err := row.Scan(&session.ID, &session.PublicID, &session.UserID, &session.UserAgent, &session.IP,
+		&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt, &session.Replaced)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func newSessionID() (string, error) {
 	b := make([]byte, 32)
 	if _, err := rand.Read(b); err != nil {
-		return nil, err
+		return "", err
 	}
-	sessionID := hex.EncodeToString(b)
-	expiresAt := time.Now().Add(30 * 24 * time.Hour) // 30 days
+	return hex.EncodeToString(b), nil
+}
 
-	var session Session
-	err := s.db.QueryRow(`
-		INSERT INTO sessions (id, user_id, expires_at)
-		VALUES ($1, $2, $3)
-		RETURNING id, user_id, created_at, expires_at
-	`, sessionID, userID, expiresAt).Scan(&session.ID, &session.UserID, &session.CreatedAt, &session.ExpiresAt)
+func (s *Store) CreateSession(userID int64, userAgent, ip string) (*Session, error) {
+	sessionID, err := newSessionID()
 	if err != nil {
 		return nil, err
 	}
-	return &session, nil
+	expiresAt := time.Now().Add(sessionTTL)
+
+	return scanSession(s.db.QueryRow(`
+		INSERT INTO sessions (id, user_id, user_agent, ip, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+sessionColumns,
+		sessionID, userID, userAgent, ip, expiresAt))
 }
 
 func (s *Store) GetSession(sessionID string) (*Session, error) {
-	var session Session
-	err := s.db.QueryRow(`
-		SELECT id, user_id, created_at, expires_at
+	return scanSession(s.db.QueryRow(`
+		SELECT `+sessionColumns+`
 		FROM sessions WHERE id=$1 AND expires_at > now()
-	`, sessionID).Scan(&session.ID, &session.UserID, &session.CreatedAt, &session.ExpiresAt)
+	`, sessionID))
+}
+
+// RotateSession replaces a session with a fresh id carrying the same metadata.
+// The old id remains valid for grace. Returns nil if the session was already
+// rotated by a concurrent request.
+func (s *Store) RotateSession(sessionID string, grace time.Duration) (*Session, error) {
+	newID, err := newSessionID()
 	if err != nil {
 		return nil, err
 	}
-	return &session, nil
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	var userAgent, ip string
+	err = tx.QueryRow(`
+		UPDATE sessions SET replaced=true, expires_at=LEAST(expires_at, $2)
+		WHERE id=$1 AND NOT replaced
+		RETURNING user_id, user_agent, ip
+	`, sessionID, time.Now().Add(grace)).Scan(&userID, &userAgent, &ip)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := scanSession(tx.QueryRow(`
+		INSERT INTO sessions (id, user_id, user_agent, ip, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+sessionColumns,
+		newID, userID, userAgent, ip, time.Now().Add(sessionTTL)))
+	if err != nil {
+		return nil, err
+	}
+	return session, tx.Commit()
+}
+
+// TouchSession records activity and slides the expiry forward.
+func (s *Store) TouchSession(sessionID, userAgent, ip string, ttl time.Duration) {
+	_, err := s.db.Exec(`
+		UPDATE sessions SET last_seen_at=now(), user_agent=$2, ip=$3, expires_at=$4
+		WHERE id=$1 AND NOT replaced
+	`, sessionID, userAgent, ip, time.Now().Add(ttl))
+	if err != nil {
+		slog.Error("failed to touch session", "error", err)
+	}
+}
+
+func (s *Store) ListSessions(userID int64) ([]*Session, error) {
+	rows, err := s.db.Query(`
+		SELECT `+sessionColumns+`
+		FROM sessions WHERE user_id=$1 AND expires_at > now() AND NOT replaced
+		ORDER BY last_seen_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *Store) DeleteSessionByPublicID(publicID, userID int64) error {
+	result, err := s.db.Exec(`DELETE FROM sessions WHERE public_id=$1 AND user_id=$2`, publicID, userID)
+	if err != nil {
+		return err
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("session not found")
+	}
+	return nil
+}
+
+// DeleteUserSessions revokes every session of a user.
+func (s *Store) DeleteUserSessions(userID int64) (int64, error) {
+	result, err := s.db.Exec(`DELETE FROM sessions WHERE user_id=$1`, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
 func (s *Store) DeleteSession(sessionID string) {
@@ -161,3 +314,130 @@ func (s *Store) CleanExpiredSessions() {
 		slog.Info("cleaned expired sessions", "count", n)
 	}
 }
+
+// Challenge operations
+
+func (s *Store) CreateChallenge(key, address string, expiresAt time.Time) error {
+	_, err := s.db.Exec(`
+		INSERT INTO auth_challenges (key, address, expires_at)
+		VALUES ($1, $2, $3)
+	`, key, address, expiresAt)
+	return err
+}
+
+// ConsumeChallenge atomically deletes and returns a challenge, so it can be
+// used at most once even with concurrent verifies across instances.
+func (s *Store) ConsumeChallenge(key string) (address string, expiresAt time.Time, err error) {
+	err = s.db.QueryRow(`
+		DELETE FROM auth_challenges WHERE key=$1
+		RETURNING address, expires_at
+	`, key).Scan(&address, &expiresAt)
+	return address, expiresAt, err
+}
+
+func (s *Store) CleanExpiredChallenges() {
+	result, err := s.db.Exec(`DELETE FROM auth_challenges WHERE expires_at < now()`)
+	if err != nil {
+		slog.Error("failed to clean expired challenges", "error", err)
+		return
+	}
+	n, _ := result.RowsAffected()
+	for _, table := range []string{"webauthn_ceremonies", "oidc_states"} {
+		if result, err := s.db.Exec(`DELETE FROM ` + table + ` WHERE expires_at < now()`); err == nil {
+			m, _ := result.RowsAffected()
+			n += m
+		}
+	}
+	if n > 0 {
+		slog.Info("cleaned expired challenges", "count", n)
+	}
+}
+
+// API token operations
+
+func (s *Store) CreateAPIToken(userID int64, name, tokenHash, prefix string, scopes []string, expiresAt *time.Time) (*APIToken, error) {
+	var id int64
+	err := s.db.QueryRow(`
+		INSERT INTO api_tokens (user_id, name, token_hash, prefix, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`, userID, name, tokenHash, prefix, strings.Join(scopes, ","), expiresAt).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	return s.getAPIToken(`WHERE id=$1`, id)
+}
+
+func (s *Store) getAPIToken(where string, args ...any) (*APIToken, error) {
+	var t APIToken
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullString
+	err := s.db.QueryRow(`
+		SELECT id, user_id, name, prefix, scopes, expires_at, last_used_at, created_at
+		FROM api_tokens `+where, args...).Scan(&t.ID, &t.UserID, &t.Name, &t.Prefix, &scopes, &expiresAt, &lastUsedAt, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	t.Scopes = splitList(scopes)
+	t.ExpiresAt = expiresAt.String
+	t.LastUsedAt = lastUsedAt.String
+	return &t, nil
+}
+
+// GetAPITokenByHash returns an unexpired token and records its use.
+func (s *Store) GetAPITokenByHash(tokenHash string) (*APIToken, error) {
+	t, err := s.getAPIToken(`WHERE token_hash=$1 AND (expires_at IS NULL OR expires_at > now())`, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.db.Exec(`UPDATE api_tokens SET last_used_at=now() WHERE id=$1`, t.ID); err != nil {
+		slog.Error("failed to update api token last_used_at", "token_id", t.ID, "error", err)
+	}
+	return t, nil
+}
+
+func (s *Store) ListAPITokens(userID int64) ([]*APIToken, error) {
+	rows, err := s.db.Query(`
+		SELECT id, user_id, name, prefix, scopes, expires_at, last_used_at, created_at
+		FROM api_tokens WHERE user_id=$1 ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*APIToken
+	for rows.Next() {
+		var t APIToken
+		var scopes string
+		var expiresAt, lastUsedAt sql.NullString
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.Prefix, &scopes, &expiresAt, &lastUsedAt, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		t.Scopes = splitList(scopes)
+		t.ExpiresAt = expiresAt.String
+		t.LastUsedAt = lastUsedAt.String
+		tokens = append(tokens, &t)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *Store) DeleteAPIToken(id, userID int64) error {
+	result, err := s.db.Exec(`DELETE FROM api_tokens WHERE id=$1 AND user_id=$2`, id, userID)
+	if err != nil {
+		return err
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("token not found")
+	}
+	return nil
+}
+
+// splitList splits a comma-separated column such as token scopes.
+func splitList(list string) []string {
+	if list == "" {
+		return []string{}
+	}
+	return strings.Split(list, ",")
+}
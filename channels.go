@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+)
+
+// Channel management on a running server (see nodeapi/channels.go). Changes
+// the node accepts are mirrored into the server's recorded channel list, so
+// the channel count stays right. Tokens are passed through to the node and
+// never recorded.
+
+var channelPathRegex = regexp.MustCompile(`^[A-Za-z0-9_.][A-Za-z0-9_.-]{0,63}$`)
+
+// channelAccount is the account a channel config applies to; provisioning
+// leaves it empty for the default account.
+func channelAccount(ch ChannelConfig) string {
+	if ch.Account == "" {
+		return "default"
+	}
+	return ch.Account
+}
+
+// upsertChannel replaces the matching channel account in the list, or
+// appends it. An empty name keeps the recorded one.
+func upsertChannel(channels []ChannelConfig, ch ChannelConfig) []ChannelConfig {
+	for i, existing := range channels {
+		if existing.Type == ch.Type && channelAccount(existing) == channelAccount(ch) {
+			if ch.Name == "" {
+				ch.Name = existing.Name
+			}
+			ch.Account = existing.Account
+			channels[i] = ch
+			return channels
+		}
+	}
+	return append(channels, ch)
+}
+
+func removeChannel(channels []ChannelConfig, chType, account string) []ChannelConfig {
+	kept := []ChannelConfig{}
+	for _, ch := range channels {
+		if ch.Type != chType || channelAccount(ch) != account {
+			kept = append(kept, ch)
+		}
+	}
+	return kept
+}
+
+// channelAction is nodeAction for channel changes: on success it applies
+// update to the recorded channel list.
+func (s *Server) channelAction(w http.ResponseWriter, r *http.Request, method, path string, body []byte, action, target string, update func([]ChannelConfig) []ChannelConfig) {
+	s.nodeAction(w, r, method, path, body, action, target, func(id int64) {
+		if err := s.store.UpdateServerChannels(id, update); err != nil {
+			slog.Error("channel changed on node but not recorded", "server_id", id, "action", action, "target", target, "error", err)
+		}
+	})
+}
+
+// channelRequest is the node API's channel body; "channel" is the type.
+type channelRequest struct {
+	Channel string `json:"channel"`
+	Account string `json:"account"`
+	Token   string `json:"token"`
+	Name    string `json:"name"`
+}
+
+func (c channelRequest) config() ChannelConfig {
+	return ChannelConfig{Type: c.Channel, Account: c.Account, Name: c.Name}
+}
+
+// channelPath validates the {channel}/{account} path values, writing the
+// error response if they are unusable.
+func channelPath(w http.ResponseWriter, r *http.Request) (chType, account string, ok bool) {
+	chType, account = r.PathValue("channel"), r.PathValue("account")
+	if !channelPathRegex.MatchString(chType) || !channelPathRegex.MatchString(account) {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid channel or account"})
+		return "", "", false
+	}
+	return chType, account, true
+}
+
+func (s *Server) handleAddChannel(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(io.LimitReader(r.Body, 4096))
+	var req channelRequest
+	if err := json.Unmarshal(body, &req); err != nil || req.Channel == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "channel is required"})
+		return
+	}
+	ch := req.config()
+	s.channelAction(w, r, "POST", "/channels", body, "channel.add", ch.Type+"/"+channelAccount(ch),
+		func(channels []ChannelConfig) []ChannelConfig { return upsertChannel(channels, ch) })
+}
+
+// handleUpdateChannel replaces a channel's token (rotation) or name.
+func (s *Server) handleUpdateChannel(w http.ResponseWriter, r *http.Request) {
+	chType, account, ok := channelPath(w, r)
+	if !ok {
+		return
+	}
+	body, _ := io.ReadAll(io.LimitReader(r.Body, 4096))
+	var req channelRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+		return
+	}
+	req.Channel, req.Account = chType, account
+	ch := req.config()
+	s.channelAction(w, r, "PUT", "/channels/"+chType+"/"+account, body, "channel.update", chType+"/"+account,
+		func(channels []ChannelConfig) []ChannelConfig { return upsertChannel(channels, ch) })
+}
+
+// handleDisableChannel stops a channel but keeps it, and its token, on
+// record so it can be re-enabled by adding it again.
+func (s *Server) handleDisableChannel(w http.ResponseWriter, r *http.Request) {
+	chType, account, ok := channelPath(w, r)
+	if !ok {
+		return
+	}
+	s.nodeAction(w, r, "POST", "/channels/"+chType+"/"+account+"/disable", nil, "channel.disable", chType+"/"+account, nil)
+}
+
+func (s *Server) handleRemoveChannel(w http.ResponseWriter, r *http.Request) {
+	chType, account, ok := channelPath(w, r)
+	if !ok {
+		return
+	}
+	s.channelAction(w, r, "DELETE", "/channels/"+chType+"/"+account, nil, "channel.remove", chType+"/"+account,
+		func(channels []ChannelConfig) []ChannelConfig { return removeChannel(channels, chType, account) })
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -21,6 +22,12 @@ type Config struct {
 	SessionSecret         string
 	DatabaseURL           string
 	WalletConnectProjectID string
+	LogRetentionDays       int // compact logs of finished servers after this many days; 0 disables
+	PublicURL              string // external origin, e.g. https://cryptoclaw.fly.dev; wallet, passkey and SSO sign-in are bound to it
+	SIWEChainID            int64
+	EthRPCURL              string // JSON-RPC endpoint for EIP-1271 signatures and token-gated approval
+	AllowedOrigins         []string // origins allowed to send cookie-authenticated writes and open WebSockets
+	MachineID              string   // this creator machine (FLY_MACHINE_ID); node calls are replayed to the one holding the node's agent tunnel
 }
 
 func LoadConfig() (*Config, error) {
@@ -46,6 +53,38 @@ func LoadConfig() (*Config, error) {
 		return nil, fmt.Errorf("DATABASE_URL is required")
 	}
 
+	logRetentionDays, err := strconv.Atoi(envOrDefault("LOG_RETENTION_DAYS", "30"))
+	if err != nil || logRetentionDays < 0 {
+		return nil, fmt.Errorf("LOG_RETENTION_DAYS must be a non-negative integer")
+	}
+
+	siweChainID, err := strconv.ParseInt(envOrDefault("SIWE_CHAIN_ID", "1"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("SIWE_CHAIN_ID must be an integer: %w", err)
+	}
+
+	// Sign-in messages, passkeys, SSO callbacks and agent handshakes are all
+	// bound to it, and the request's Host can't stand in: it's client-controlled
+	publicURL := strings.TrimRight(os.Getenv("PUBLIC_URL"), "/")
+	if publicURL == "" {
+		return nil, fmt.Errorf("PUBLIC_URL is required")
+	}
+	if originOf(publicURL) != publicURL {
+		return nil, fmt.Errorf("PUBLIC_URL must be an origin such as https://example.com, got %q", publicURL)
+	}
+
+	var allowedOrigins []string
+	for _, o := range strings.Split(os.Getenv("ALLOWED_ORIGINS"), ",") {
+		if o = strings.TrimSpace(o); o == "" {
+			continue
+		}
+		origin := originOf(o)
+		if origin == "" {
+			return nil, fmt.Errorf("ALLOWED_ORIGINS: invalid origin %q", o)
+		}
+		allowedOrigins = append(allowedOrigins, origin)
+	}
+
 	return &Config{
 		HCloudToken:       token,
 		SSHKeyID:          sshKeyID,
@@ -59,6 +98,12 @@ func LoadConfig() (*Config, error) {
 		SessionSecret:          envOrDefault("SESSION_SECRET", "openclaw-default-secret-change-me"),
 		DatabaseURL:            dbURL,
 		WalletConnectProjectID: os.Getenv("WALLETCONNECT_PROJECT_ID"),
+		LogRetentionDays:       logRetentionDays,
+		PublicURL:              publicURL,
+		SIWEChainID:            siweChainID,
+		EthRPCURL:              os.Getenv("ETH_RPC_URL"),
+		AllowedOrigins:         allowedOrigins,
+		MachineID:              os.Getenv("FLY_MACHINE_ID"),
 	}, nil
 }
 
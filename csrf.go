@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// isHTTPS reports whether the client reached us over HTTPS, directly or via
+// Fly's TLS-terminating proxy.
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// originOf normalizes a URL to its lowercase scheme://host origin, or "".
+func originOf(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+// allowedOrigins returns the origins permitted to make cookie-authenticated
+// requests: ALLOWED_ORIGINS, else PUBLIC_URL.
+func (s *Server) allowedOrigins() []string {
+	if len(s.config.AllowedOrigins) > 0 {
+		return s.config.AllowedOrigins
+	}
+	return []string{originOf(s.config.PublicURL)}
+}
+
+func (s *Server) originAllowed(r *http.Request, origin string) bool {
+	o := originOf(origin)
+	return o != "" && slices.Contains(s.allowedOrigins(), o)
+}
+
+// checkWebSocketOrigin is the upgrader's CheckOrigin. Browsers always send
+// Origin on WebSocket handshakes; non-browser clients may omit it.
+func (s *Server) checkWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	return s.originAllowed(r, origin)
+}
+
+// requireSameOrigin rejects state-changing requests whose Origin (or, failing
+// that, Referer) is not an allowed origin. Bearer-token requests carry no
+// ambient credentials and are exempt.
+func (s *Server) requireSameOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, ok := bearerToken(r); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		source := r.Header.Get("Origin")
+		if source == "" {
+			source = r.Header.Get("Referer")
+		}
+		if source == "" {
+			// No browser context at all: only acceptable without a cookie
+			if _, err := r.Cookie(sessionCookieName); err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "missing origin"})
+			return
+		}
+		if !s.originAllowed(r, source) {
+			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "cross-origin request rejected"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// EthRPC is a minimal Ethereum JSON-RPC client covering the read-only calls
+// the creator needs. Any node works, including a local anvil/geth dev node.
+type EthRPC struct {
+	url    string
+	client *http.Client
+	nextID atomic.Int64
+}
+
+func NewEthRPC(url string) *EthRPC {
+	return &EthRPC{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type rpcRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      int64  `json:"id"`
+	Method  string `json:"method"`
+	Params  []any  `json:"params"`
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (c *EthRPC) call(ctx context.Context, method string, params ...any) (json.RawMessage, error) {
+	body, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: c.nextID.Add(1), Method: method, Params: params})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", method, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: http status %d", method, resp.StatusCode)
+	}
+
+	var out rpcResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("%s: decode response: %w", method, err)
+	}
+	if out.Error != nil {
+		return nil, fmt.Errorf("%s: rpc error %d: %s", method, out.Error.Code, out.Error.Message)
+	}
+	return out.Result, nil
+}
+
+func (c *EthRPC) hexResult(ctx context.Context, method string, params ...any) ([]byte, error) {
+	raw, err := c.call(ctx, method, params...)
+	if err != nil {
+		return nil, err
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, fmt.Errorf("%s: unexpected result: %w", method, err)
+	}
+	return hex.DecodeString(strings.TrimPrefix(s, "0x"))
+}
+
+// ChainID returns the chain the node serves (eth_chainId).
+func (c *EthRPC) ChainID(ctx context.Context) (int64, error) {
+	raw, err := c.call(ctx, "eth_chainId")
+	if err != nil {
+		return 0, err
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return 0, fmt.Errorf("eth_chainId: unexpected result: %w", err)
+	}
+	id, ok := new(big.Int).SetString(strings.TrimPrefix(s, "0x"), 16)
+	if !ok || !id.IsInt64() {
+		return 0, fmt.Errorf("eth_chainId: invalid quantity %q", s)
+	}
+	return id.Int64(), nil
+}
+
+// GetCode returns the deployed bytecode at addr (empty for EOAs).
+func (c *EthRPC) GetCode(ctx context.Context, addr common.Address) ([]byte, error) {
+	return c.hexResult(ctx, "eth_getCode", addr.Hex(), "latest")
+}
+
+// Call performs eth_call against contract `to` with ABI-encoded calldata.
+func (c *EthRPC) Call(ctx context.Context, to common.Address, data []byte) ([]byte, error) {
+	return c.hexResult(ctx, "eth_call", map[string]string{
+		"to":   to.Hex(),
+		"data": "0x" + hex.EncodeToString(data),
+	}, "latest")
+}
+
+// EIP-1271: isValidSignature(bytes32,bytes) returns this selector on success.
+var eip1271MagicValue = []byte{0x16, 0x26, 0xba, 0x7e}
+
+// IsValidSignature asks a smart-contract wallet whether sig is a valid
+// signature of hash on its behalf.
+func (c *EthRPC) IsValidSignature(ctx context.Context, wallet common.Address, hash common.Hash, sig []byte) (bool, error) {
+	// selector || hash || offset(0x40) || len(sig) || sig (right-padded to 32 bytes)
+	data := make([]byte, 0, 4+32*3+len(sig)+32)
+	data = append(data, eip1271MagicValue...)
+	data = append(data, hash.Bytes()...)
+	data = append(data, abiUint(0x40)...)
+	data = append(data, abiUint(uint64(len(sig)))...)
+	data = append(data, sig...)
+	if pad := len(sig) % 32; pad != 0 {
+		data = append(data, make([]byte, 32-pad)...)
+	}
+
+	out, err := c.Call(ctx, wallet, data)
+	if err != nil {
+		return false, err
+	}
+	return len(out) >= 4 && bytes.Equal(out[:4], eip1271MagicValue), nil
+}
+
+// balanceOf(address) selector; shared by ERC-20 and ERC-721.
+var balanceOfSelector = []byte{0x70, 0xa0, 0x82, 0x31}
+
+// BalanceOf returns owner's balance of an ERC-20 or ERC-721 token contract
+// (raw units for ERC-20, token count for ERC-721).
+func (c *EthRPC) BalanceOf(ctx context.Context, token, owner common.Address) (*big.Int, error) {
+	data := make([]byte, 0, 4+32)
+	data = append(data, balanceOfSelector...)
+	data = append(data, common.LeftPadBytes(owner.Bytes(), 32)...)
+
+	out, err := c.Call(ctx, token, data)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) < 32 {
+		return nil, fmt.Errorf("balanceOf: short result (%d bytes)", len(out))
+	}
+	return new(big.Int).SetBytes(out[:32]), nil
+}
+
+func abiUint(v uint64) []byte {
+	b := make([]byte, 32)
+	for i := 0; i < 8; i++ {
+		b[31-i] = byte(v >> (8 * i))
+	}
+	return b
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+)
+
+// mockEthNode is a JSON-RPC node serving eth_chainId, eth_getCode and an
+// EIP-1271 wallet at contract that accepts exactly validSig.
+type mockEthNode struct {
+	chainID  string
+	contract common.Address
+	validSig []byte
+	calls    []string
+}
+
+func (m *mockEthNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     int64             `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m.calls = append(m.calls, req.Method)
+
+	var result string
+	switch req.Method {
+	case "eth_chainId":
+		result = m.chainID
+	case "eth_getCode":
+		var addr string
+		json.Unmarshal(req.Params[0], &addr)
+		result = "0x"
+		if common.HexToAddress(addr) == m.contract {
+			result = "0x6080"
+		}
+	case "eth_call":
+		var call struct{ To, Data string }
+		json.Unmarshal(req.Params[0], &call)
+		data, _ := hex.DecodeString(strings.TrimPrefix(call.Data, "0x"))
+		result = "0x"
+		// selector || hash || offset || len || sig
+		if common.HexToAddress(call.To) == m.contract && len(data) >= 4+32*3 &&
+			bytes.HasPrefix(data[4+32*3:], m.validSig) {
+			result = "0x" + hex.EncodeToString(common.RightPadBytes(eip1271MagicValue, 32))
+		}
+	default:
+		json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": req.ID, "error": map[string]any{"code": -32601, "message": "method not found"}})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": req.ID, "result": result})
+}
+
+func TestEthRPCChainID(t *testing.T) {
+	tests := []struct {
+		result  string
+		want    int64
+		wantErr bool
+	}{
+		{result: "0x1", want: 1},
+		{result: "0x2105", want: 8453},
+		{result: "0x", wantErr: true},
+		{result: "banana", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.result, func(t *testing.T) {
+			ts := httptest.NewServer(&mockEthNode{chainID: tt.result})
+			defer ts.Close()
+			got, err := NewEthRPC(ts.URL).ChainID(context.Background())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %d, want error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("got %d, %v; want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyWalletSignature(t *testing.T) {
+	const challenge = "creator.example wants you to sign in with your Ethereum account"
+	sign := func(t *testing.T) (common.Address, string) {
+		t.Helper()
+		key, err := ethcrypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := ethcrypto.Sign(eip191Hash(challenge).Bytes(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig[64] += 27 // as wallets return it
+		return ethcrypto.PubkeyToAddress(key.PublicKey), "0x" + hex.EncodeToString(sig)
+	}
+	eoa, eoaSig := sign(t)
+	_, otherSig := sign(t)
+	contract := common.HexToAddress("0x5afe000000000000000000000000000000000001")
+	contractSig := bytes.Repeat([]byte{0xab}, 80) // e.g. a Safe's concatenated owner signatures
+
+	tests := []struct {
+		name      string
+		noRPC     bool
+		address   common.Address
+		signature string
+		wantErr   bool
+		wantCalls []string
+	}{
+		{name: "EOA", address: eoa, signature: eoaSig, wantCalls: []string{"eth_getCode"}},
+		{name: "EOA without RPC", noRPC: true, address: eoa, signature: eoaSig},
+		{name: "EOA wrong signer falls back to EIP-1271", address: eoa, signature: otherSig, wantErr: true, wantCalls: []string{"eth_getCode", "eth_call"}},
+		{name: "EOA wrong signer without RPC", noRPC: true, address: eoa, signature: otherSig, wantErr: true},
+		{name: "contract wallet", address: contract, signature: "0x" + hex.EncodeToString(contractSig), wantCalls: []string{"eth_getCode", "eth_call"}},
+		{name: "contract wallet rejects", address: contract, signature: otherSig, wantErr: true, wantCalls: []string{"eth_getCode", "eth_call"}},
+		{name: "contract wallet skips ecrecover", address: contract, signature: eoaSig, wantErr: true, wantCalls: []string{"eth_getCode", "eth_call"}},
+		{name: "malformed signature", address: contract, signature: "0xzz", wantErr: true, wantCalls: []string{"eth_getCode"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &mockEthNode{chainID: "0x1", contract: contract, validSig: contractSig}
+			ts := httptest.NewServer(node)
+			defer ts.Close()
+			s := &Server{}
+			if !tt.noRPC {
+				s.eth = NewEthRPC(ts.URL)
+			}
+
+			err := s.verifyWalletSignature(context.Background(), challenge, tt.signature, strings.ToLower(tt.address.Hex()))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if strings.Join(node.calls, ",") != strings.Join(tt.wantCalls, ",") {
+				t.Fatalf("RPC calls %v, want %v", node.calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+import { useState } from 'react'
+import { CHANNEL_TYPES } from './ChannelRow'
+import * as api from '../lib/api'
+
+type Op = 'add' | 'update' | 'disable' | 'remove'
+
+const inputClass =
+  'bg-bg-input border border-border rounded-md text-text font-mono text-[0.75rem] px-2 py-1 focus:outline-none focus:border-accent/50 placeholder:text-text-dim transition-colors'
+
+const buttonClass =
+  'bg-transparent border border-border rounded-md text-text-secondary cursor-pointer font-mono text-[0.7rem] px-2 py-1 hover:text-text hover:border-border-hover transition-colors disabled:opacity-40 disabled:cursor-not-allowed'
+
+// Adds, re-tokens, disables or removes a channel account on a running server.
+export function ChannelManager({ serverId }: { serverId: number }) {
+  const [channel, setChannel] = useState(CHANNEL_TYPES[0])
+  const [account, setAccount] = useState('default')
+  const [token, setToken] = useState('')
+  const [name, setName] = useState('')
+  const [busy, setBusy] = useState(false)
+  const [message, setMessage] = useState<{ ok: boolean; text: string } | null>(null)
+
+  const run = async (op: Op) => {
+    if ((op === 'disable' || op === 'remove') && !confirm(`${op} ${channel}/${account}?`)) return
+    setBusy(true)
+    setMessage(null)
+    try {
+      const acct = account || 'default'
+      switch (op) {
+        case 'add':
+          await api.addChannel(serverId, { channel, account: acct, token: token || undefined, name: name || undefined })
+          break
+        case 'update':
+          await api.updateChannel(serverId, channel, acct, { token: token || undefined, name: name || undefined })
+          break
+        case 'disable':
+          await api.disableChannel(serverId, channel, acct)
+          break
+        case 'remove':
+          await api.removeChannel(serverId, channel, acct)
+          break
+      }
+      setToken('')
+      setMessage({ ok: true, text: `${channel}/${acct}: ${op === 'add' ? 'added' : op + 'd'}` })
+    } catch (err) {
+      setMessage({ ok: false, text: (err as Error).message })
+    } finally {
+      setBusy(false)
+    }
+  }
+
+  return (
+    <div className="tech-panel p-4 mb-4">
+      <div className="flex flex-wrap items-center gap-2">
+        <select value={channel} onChange={(e) => setChannel(e.target.value)} className={inputClass}>
+          {CHANNEL_TYPES.map((t) => (
+            <option key={t} value={t}>{t}</option>
+          ))}
+        </select>
+        <input value={account} onChange={(e) => setAccount(e.target.value)} placeholder="account" className={`${inputClass} w-28`} />
+        <input
+          type="password"
+          value={token}
+          onChange={(e) => setToken(e.target.value)}
+          placeholder="bot token"
+          autoComplete="off"
+          className={`${inputClass} flex-1 min-w-40`}
+        />
+        <input value={name} onChange={(e) => setName(e.target.value)} placeholder="name" className={`${inputClass} w-32`} />
+      </div>
+      <div className="flex items-center gap-2 mt-2">
+        <button className={buttonClass} disabled={busy} onClick={() => run('add')}>add</button>
+        <button className={buttonClass} disabled={busy || (!token && !name)} onClick={() => run('update')}>update</button>
+        <button className={buttonClass} disabled={busy} onClick={() => run('disable')}>disable</button>
+        <button className={buttonClass} disabled={busy} onClick={() => run('remove')}>remove</button>
+        {message && (
+          <span className={`font-mono text-[0.7rem] ${message.ok ? 'text-accent-text' : 'text-red-400'}`}>{message.text}</span>
+        )}
+      </div>
+    </div>
+  )
+}
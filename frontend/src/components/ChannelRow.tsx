@@ -1,7 +1,7 @@
 import { useState } from 'react'
 import type { ChannelConfig } from '../types'
 
-const CHANNEL_TYPES = ['telegram', 'discord', 'slack', 'whatsapp', 'signal', 'googlechat', 'mattermost']
+export const CHANNEL_TYPES = ['telegram', 'discord', 'slack', 'whatsapp', 'signal', 'googlechat', 'mattermost']
 
 interface Props {
   channel: ChannelConfig
@@ -23,7 +23,7 @@ export function Layout({ children }: { children: ReactNode }) {
             <div className="flex items-center gap-2">
               <div className="flex items-center gap-1.5 bg-surface/50 border border-border rounded-md px-2.5 py-1">
                 <span className="w-1.5 h-1.5 rounded-full bg-accent animate-[pulse-dot_2s_ease-in-out_infinite]" />
-                <span className="text-text-secondary font-mono text-[0.75rem]">{user.address.slice(0, 6)}...{user.address.slice(-4)}</span>
+                <span className="text-text-secondary font-mono text-[0.75rem]">{user.address ? `${user.address.slice(0, 6)}...${user.address.slice(-4)}` : `user #${user.id}`}</span>
               </div>
               {user.role === 'admin' && (
                 <a
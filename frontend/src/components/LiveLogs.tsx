@@ -0,0 +1,98 @@
+import { useEffect, useRef, useState } from 'react'
+import { connectLiveLogs } from '../lib/websocket'
+
+const MAX_LINES = 2000
+
+const inputClass =
+  'bg-bg-input border border-border rounded-md text-text font-mono text-[0.75rem] px-2 py-1 focus:outline-none focus:border-accent/50 placeholder:text-text-dim transition-colors'
+
+const buttonClass =
+  'bg-transparent border border-border rounded-md text-text-secondary cursor-pointer font-mono text-[0.75rem] px-2.5 py-1 hover:text-text hover:border-border-hover transition-colors'
+
+export function LiveLogs({ serverId }: { serverId: number }) {
+  const [service, setService] = useState('all')
+  const [since, setSince] = useState('')
+  const [grep, setGrep] = useState('')
+  const [lines, setLines] = useState<string[]>([])
+  const [running, setRunning] = useState(false)
+  const [error, setError] = useState<string | null>(null)
+  const wsRef = useRef<WebSocket | null>(null)
+  const bottomRef = useRef<HTMLDivElement>(null)
+
+  useEffect(() => () => wsRef.current?.close(), [])
+
+  useEffect(() => {
+    bottomRef.current?.scrollIntoView({ block: 'nearest' })
+  }, [lines])
+
+  const start = async () => {
+    wsRef.current?.close()
+    setLines([])
+    setError(null)
+    const params = new URLSearchParams({ service, lines: '200' })
+    if (since) params.set('since', since)
+    if (grep) params.set('grep', grep)
+    try {
+      setRunning(true)
+      wsRef.current = await connectLiveLogs(
+        serverId,
+        params.toString(),
+        (msg) => {
+          if (msg.type === 'log') setLines((prev) => [...prev, msg.line].slice(-MAX_LINES))
+          else if (msg.type === 'error') setError(msg.error)
+        },
+        () => setRunning(false),
+      )
+    } catch (err) {
+      setError((err as Error).message)
+      setRunning(false)
+    }
+  }
+
+  const stop = () => {
+    wsRef.current?.close()
+    wsRef.current = null
+    setRunning(false)
+  }
+
+  return (
+    <div className="tech-panel p-4 mb-4">
+      <div className="flex flex-wrap items-center gap-2 mb-3">
+        <select value={service} onChange={(e) => setService(e.target.value)} className={inputClass} disabled={running}>
+          <option value="all">daemon + gateway</option>
+          <option value="daemon">daemon</option>
+          <option value="gateway">gateway</option>
+        </select>
+        <input
+          value={since}
+          onChange={(e) => setSince(e.target.value)}
+          placeholder="since (e.g. 15m)"
+          className={`${inputClass} w-32`}
+          disabled={running}
+        />
+        <input
+          value={grep}
+          onChange={(e) => setGrep(e.target.value)}
+          placeholder="grep (regexp)"
+          className={`${inputClass} flex-1 min-w-32`}
+          disabled={running}
+        />
+        {running ? (
+          <button onClick={stop} className={buttonClass}>stop</button>
+        ) : (
+          <button onClick={start} className={buttonClass}>tail logs</button>
+        )}
+      </div>
+      {error && <div className="mb-2 font-mono text-[0.7rem] text-red-400">{error}</div>}
+      {(lines.length > 0 || running) && (
+        <div className="bg-[#04040a] rounded-md border border-border/50 font-mono text-[0.75rem] leading-relaxed text-text-secondary max-h-[50vh] overflow-y-auto whitespace-pre-wrap break-all p-3">
+          {lines.map((line, i) => (
+            <div key={i}>{line}</div>
+          ))}
+          {running && lines.length === 0 && <div className="text-text-dim">waiting for output...</div>}
+          <div ref={bottomRef} />
+        </div>
+      )}
+    </div>
+  )
+}
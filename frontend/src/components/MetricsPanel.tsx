@@ -0,0 +1,129 @@
+import { useEffect, useState } from 'react'
+import type { NodeMetrics } from '../types'
+import * as api from '../lib/api'
+
+const REFRESH_MS = 60_000
+
+function usedPercent(total: number, free: number): number {
+  return total > 0 ? ((total - free) / total) * 100 : 0
+}
+
+function formatBytes(n: number): string {
+  const units = ['B', 'KB', 'MB', 'GB', 'TB']
+  let i = 0
+  while (n >= 1024 && i < units.length - 1) {
+    n /= 1024
+    i++
+  }
+  return `${n.toFixed(i === 0 ? 0 : 1)} ${units[i]}`
+}
+
+function formatUptime(seconds: number): string {
+  const d = Math.floor(seconds / 86400)
+  const h = Math.floor((seconds % 86400) / 3600)
+  const m = Math.floor((seconds % 3600) / 60)
+  return d > 0 ? `${d}d ${h}h` : h > 0 ? `${h}h ${m}m` : `${m}m`
+}
+
+function Sparkline({ values }: { values: number[] }) {
+  if (values.length < 2) return <div className="h-6" />
+  const points = values
+    .map((v, i) => `${(i / (values.length - 1)) * 100},${24 - (Math.min(v, 100) / 100) * 24}`)
+    .join(' ')
+  return (
+    <svg viewBox="0 0 100 24" preserveAspectRatio="none" className="w-full h-6">
+      <polyline points={points} fill="none" stroke="currentColor" strokeWidth="1.5" vectorEffect="non-scaling-stroke" />
+    </svg>
+  )
+}
+
+function Gauge({ label, percent, detail, history }: { label: string; percent: number; detail: string; history: number[] }) {
+  const tone = percent >= 90 ? 'text-red-400' : percent >= 75 ? 'text-yellow-400' : 'text-accent-text'
+  return (
+    <div className="border border-border/50 rounded-md px-3 py-2">
+      <div className="flex items-baseline justify-between">
+        <span className="font-mono text-[0.65rem] font-medium text-text-tertiary uppercase tracking-wider">{label}</span>
+        <span className={`font-mono text-[0.8rem] ${tone}`}>{percent.toFixed(0)}%</span>
+      </div>
+      <div className={`mt-1 ${tone}`}>
+        <Sparkline values={history} />
+      </div>
+      <div className="font-mono text-[0.65rem] text-text-dim mt-1">{detail}</div>
+    </div>
+  )
+}
+
+export function MetricsPanel({ serverId }: { serverId: number }) {
+  const [history, setHistory] = useState<NodeMetrics[]>([])
+  const [current, setCurrent] = useState<NodeMetrics | null>(null)
+  const [error, setError] = useState<string | null>(null)
+
+  useEffect(() => {
+    let cancelled = false
+    const load = async () => {
+      // The live reading also adds a sample to the history
+      try {
+        const m = await api.getSystemMetrics(serverId)
+        if (!cancelled) {
+          setCurrent(m)
+          setError(null)
+        }
+      } catch (err) {
+        if (!cancelled) setError((err as Error).message)
+      }
+      try {
+        const h = await api.getMetricsHistory(serverId)
+        if (!cancelled) setHistory(h)
+      } catch {
+        // keep the previous history
+      }
+    }
+    load()
+    const timer = setInterval(load, REFRESH_MS)
+    return () => {
+      cancelled = true
+      clearInterval(timer)
+    }
+  }, [serverId])
+
+  const m = current ?? history[history.length - 1]
+  if (!m) {
+    return error ? <div className="font-mono text-[0.7rem] text-text-dim">metrics unavailable: {error}</div> : null
+  }
+
+  const memUsed = m.mem_total_bytes - m.mem_available_bytes
+  const diskUsed = m.disk_total_bytes - m.disk_free_bytes
+  const swapUsed = m.swap_total_bytes - m.swap_free_bytes
+
+  return (
+    <div className="tech-panel p-4 mb-4">
+      <div className="grid grid-cols-1 sm:grid-cols-3 gap-3">
+        <Gauge
+          label="CPU"
+          percent={m.cpu_percent}
+          detail={`load ${m.load_1.toFixed(2)} ${m.load_5.toFixed(2)} ${m.load_15.toFixed(2)} · ${m.cpu_count} vCPU`}
+          history={history.map((h) => h.cpu_percent)}
+        />
+        <Gauge
+          label="Memory"
+          percent={usedPercent(m.mem_total_bytes, m.mem_available_bytes)}
+          detail={`${formatBytes(memUsed)} / ${formatBytes(m.mem_total_bytes)}${m.swap_total_bytes > 0 ? ` · swap ${formatBytes(swapUsed)}` : ''}`}
+          history={history.map((h) => usedPercent(h.mem_total_bytes, h.mem_available_bytes))}
+        />
+        <Gauge
+          label="Disk"
+          percent={usedPercent(m.disk_total_bytes, m.disk_free_bytes)}
+          detail={`${formatBytes(diskUsed)} / ${formatBytes(m.disk_total_bytes)}`}
+          history={history.map((h) => usedPercent(h.disk_total_bytes, h.disk_free_bytes))}
+        />
+      </div>
+      <div className="flex flex-wrap gap-x-4 gap-y-1 mt-3 font-mono text-[0.65rem] text-text-tertiary">
+        <span>up {formatUptime(m.uptime_seconds)}</span>
+        <span className={m.daemon_processes > 0 ? '' : 'text-red-400'}>daemon {m.daemon_processes > 0 ? 'running' : 'not running'}</span>
+        <span className={m.gateway_processes > 0 ? '' : 'text-red-400'}>gateway {m.gateway_processes > 0 ? 'running' : 'not running'}</span>
+        <span>{m.processes} processes</span>
+        {error && <span className="text-text-dim">live reading failed: {error}</span>}
+      </div>
+    </div>
+  )
+}
@@ -1,8 +1,9 @@
 import { useState } from 'react'
-import type { PairingRequest } from '../types'
+import type { PairingRequest, PairingChannelError } from '../types'
 
 interface Props {
   requests: PairingRequest[]
+  errors?: PairingChannelError[]
   onApprove: (serverId: number, channel: string, code: string) => void
   onDeny: (serverId: number, channel: string, code: string) => void
 }
@@ -11,11 +12,11 @@ function requestKey(r: PairingRequest) {
   return `${r.server_id}-${r.channel}-${r.code}`
 }
 
-export function PairingRequests({ requests, onApprove, onDeny }: Props) {
+export function PairingRequests({ requests, errors = [], onApprove, onDeny }: Props) {
   const pending = requests.filter((r) => !r.status || r.status === 'pending')
   const [busy, setBusy] = useState<Record<string, 'approving' | 'denying'>>({})
 
-  if (pending.length === 0) return null
+  if (pending.length === 0 && errors.length === 0) return null
 
   const handleAction = async (
     r: PairingRequest,
@@ -49,7 +50,7 @@ export function PairingRequests({ requests, onApprove, onDeny }: Props) {
         </span>
       </div>
       <div className="tech-panel p-5">
-        <div className="overflow-x-auto">
+        <div className={pending.length > 0 ? 'overflow-x-auto' : 'hidden'}>
           <table className="w-full border-collapse text-sm">
             <thead>
               <tr>
@@ -112,6 +113,15 @@ export function PairingRequests({ requests, onApprove, onDeny }: Props) {
             </tbody>
           </table>
         </div>
+        {errors.length > 0 && (
+          <div className={`font-mono text-[0.7rem] text-danger ${pending.length > 0 ? 'mt-3 pt-3 border-t border-border/50' : ''}`}>
+            {errors.map((e) => (
+              <div key={`${e.server_id}-${e.channel}`}>
+                {e.server_name} / {e.channel}: could not list requests ({e.error})
+              </div>
+            ))}
+          </div>
+        )}
       </div>
     </section>
   )
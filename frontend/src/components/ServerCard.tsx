@@ -71,6 +71,11 @@ export function ServerCard({ server, onDelete }: Props) {
       <div className="flex items-center justify-between pt-2.5 border-t border-border/50">
         <span className="font-mono text-[0.7rem] text-text-dim">
           {server.status === 'provisioning' ? 'deploying...' : server.status === 'ready' ? 'operational' : 'error state'}
+          {server.status === 'ready' && server.agent_seen_at && (
+            server.agent_online
+              ? <span className="text-accent-text"> · agent online</span>
+              : <span className="text-text-tertiary"> · agent offline since {formatDate(server.agent_seen_at)}</span>
+          )}
         </span>
         <button
           onClick={(e) => { e.stopPropagation(); onDelete(server.id) }}
@@ -0,0 +1,75 @@
+import { useCallback, useEffect, useState } from 'react'
+import type { NodeService } from '../types'
+import * as api from '../lib/api'
+
+type Action = 'start' | 'stop' | 'restart'
+
+const buttonClass =
+  'bg-transparent border border-border rounded-md text-text-secondary cursor-pointer font-mono text-[0.7rem] px-2 py-0.5 hover:text-text hover:border-border-hover transition-colors disabled:opacity-40 disabled:cursor-not-allowed'
+
+export function ServicesPanel({ serverId }: { serverId: number }) {
+  const [services, setServices] = useState<NodeService[]>([])
+  const [busy, setBusy] = useState<string | null>(null)
+  const [error, setError] = useState<string | null>(null)
+
+  const load = useCallback(async () => {
+    try {
+      setServices(await api.listServices(serverId))
+      setError(null)
+    } catch (err) {
+      setError((err as Error).message)
+    }
+  }, [serverId])
+
+  useEffect(() => {
+    load()
+  }, [load])
+
+  const run = async (svc: NodeService, action: Action) => {
+    if (action !== 'start' && !confirm(`${action} the ${svc.name}? The bot will be unavailable while it is down.`)) return
+    setBusy(svc.name)
+    try {
+      const updated = await api.serviceAction(serverId, svc.name, action)
+      setServices((prev) => prev.map((s) => (s.name === updated.name ? updated : s)))
+      setError(null)
+    } catch (err) {
+      setError((err as Error).message)
+    } finally {
+      setBusy(null)
+    }
+  }
+
+  if (services.length === 0 && !error) return null
+
+  return (
+    <div className="tech-panel p-4 mb-4">
+      <div className="flex flex-col gap-2">
+        {services.map((svc) => {
+          const active = svc.active_state === 'active'
+          return (
+            <div key={svc.name} className="flex items-center gap-3 font-mono text-[0.75rem]">
+              <span className={`w-2 h-2 rounded-full ${active ? 'bg-green-500/80' : 'bg-red-500/80'}`} />
+              <span className="text-text w-16">{svc.name}</span>
+              <span className="text-text-tertiary flex-1">
+                {svc.active_state} ({svc.sub_state}){svc.restarts && svc.restarts !== '0' ? ` · ${svc.restarts} restarts` : ''}
+              </span>
+              <button className={buttonClass} disabled={busy !== null} onClick={() => run(svc, 'restart')}>
+                {busy === svc.name ? '...' : 'restart'}
+              </button>
+              {active ? (
+                <button className={buttonClass} disabled={busy !== null} onClick={() => run(svc, 'stop')}>
+                  stop
+                </button>
+              ) : (
+                <button className={buttonClass} disabled={busy !== null} onClick={() => run(svc, 'start')}>
+                  start
+                </button>
+              )}
+            </div>
+          )
+        })}
+      </div>
+      {error && <div className="mt-2 font-mono text-[0.7rem] text-red-400">{error}</div>}
+    </div>
+  )
+}
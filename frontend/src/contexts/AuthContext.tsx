@@ -2,6 +2,7 @@ import { createContext, useContext, useState, useEffect, useCallback, useRef, ty
 import { useAccount, useSignMessage, useDisconnect } from 'wagmi'
 import type { User } from '../types'
 import * as api from '../lib/api'
+import * as passkey from '../lib/passkey'
 
 interface AuthContextValue {
   user: User | null
@@ -9,6 +10,7 @@ interface AuthContextValue {
   error: string | null
   logout: () => Promise<void>
   refresh: () => Promise<void>
+  passkeySignIn: (signUp: boolean) => Promise<void>
 }
 
 const AuthContext = createContext<AuthContextValue | null>(null)
@@ -50,7 +52,9 @@ export function AuthProvider({ children }: { children: ReactNode }) {
         const addr = address.toLowerCase()
         const { challenge } = await api.requestChallenge(addr)
         const signature = await signMessageAsync({ message: challenge })
-        const { user } = await api.verifyChallenge(addr, signature, challenge)
+        // Invite links land on /?invite=<token>
+        const invite = new URLSearchParams(window.location.search).get('invite') ?? undefined
+        const { user } = await api.verifyChallenge(addr, signature, challenge, invite)
         setUser(user)
       } catch (err) {
         setError((err as Error).message)
@@ -65,6 +69,19 @@ export function AuthProvider({ children }: { children: ReactNode }) {
     return () => clearTimeout(timer)
   }, [isConnected, address, user, signMessageAsync, disconnect])
 
+  const passkeySignIn = useCallback(async (signUp: boolean) => {
+    setError(null)
+    try {
+      const invite = new URLSearchParams(window.location.search).get('invite') ?? undefined
+      const { user } = signUp
+        ? await passkey.registerPasskey(undefined, invite)
+        : await passkey.loginWithPasskey(invite)
+      setUser(user ?? null)
+    } catch (err) {
+      setError((err as Error).message)
+    }
+  }, [])
+
   const logout = useCallback(async () => {
     await api.logout()
     setUser(null)
@@ -72,7 +89,7 @@ export function AuthProvider({ children }: { children: ReactNode }) {
   }, [disconnect])
 
   return (
-    <AuthContext.Provider value={{ user, loading, error, logout, refresh }}>
+    <AuthContext.Provider value={{ user, loading, error, logout, refresh, passkeySignIn }}>
       {children}
     </AuthContext.Provider>
   )
@@ -1,10 +1,11 @@
 import { useState, useEffect, useCallback, useRef } from 'react'
-import type { ServerInfo, PairingRequest } from '../types'
+import type { ServerInfo, PairingRequest, PairingChannelError, PairingList } from '../types'
 import { signRequest } from '../lib/crypto'
 import { signedRequest } from '../lib/api'
 
 export function usePairingRequests(servers: ServerInfo[]) {
   const [requests, setRequests] = useState<PairingRequest[]>([])
+  const [errors, setErrors] = useState<PairingChannelError[]>([])
   const intervalRef = useRef<ReturnType<typeof setInterval> | null>(null)
 
   const readyServers = servers.filter((s) => s.status === 'ready' && s.has_node_api)
@@ -12,10 +13,12 @@ export function usePairingRequests(servers: ServerInfo[]) {
   const refresh = useCallback(async () => {
     if (readyServers.length === 0) {
       setRequests([])
+      setErrors([])
       return
     }
 
     const allRequests: PairingRequest[] = []
+    const allErrors: PairingChannelError[] = []
     for (const srv of readyServers) {
       try {
         const headers = await signRequest('GET', '/pairing/requests')
@@ -26,7 +29,13 @@ export function usePairingRequests(servers: ServerInfo[]) {
           headers,
         )
         if (!res.ok) continue
-        const reqs = (await res.json()) as PairingRequest[]
+        const data = (await res.json()) as PairingList | PairingRequest[]
+        const reqs = Array.isArray(data) ? data : data.requests
+        if (!Array.isArray(data)) {
+          for (const e of data.errors) {
+            allErrors.push({ ...e, server_id: srv.id, server_name: srv.name })
+          }
+        }
         for (const req of reqs) {
           req.server_id = srv.id
           req.server_name = srv.name
@@ -37,6 +46,7 @@ export function usePairingRequests(servers: ServerInfo[]) {
       }
     }
     setRequests(allRequests)
+    setErrors(allErrors)
   // eslint-disable-next-line react-hooks/exhaustive-deps
   }, [JSON.stringify(readyServers.map((s) => s.id))])
 
@@ -93,5 +103,5 @@ export function usePairingRequests(servers: ServerInfo[]) {
     [refresh],
   )
 
-  return { requests, approve, deny, refresh }
+  return { requests, errors, approve, deny, refresh }
 }
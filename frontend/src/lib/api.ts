@@ -1,4 +1,5 @@
-import type { ServerInfo, CreateServerRequest, CreateServerResponse, ErrorResponse } from '../types'
+import type { ServerInfo, CreateServerRequest, CreateServerResponse, ErrorResponse, NodeKey, NodeMetrics, NodeService, ServerAction } from '../types'
+import { signRequest } from './crypto'
 
 async function request<T>(path: string, options?: RequestInit): Promise<T> {
   const res = await fetch(path, {
@@ -32,14 +33,94 @@ export async function deleteServer(id: number): Promise<void> {
   await request(`/servers/${id}`, { method: 'DELETE' })
 }
 
-export async function setPublicKey(id: number, publicKeyPEM: string): Promise<void> {
-  await request(`/servers/${id}/public-key`, {
+// Node API keys. Changes must be signed by a key the node already trusts,
+// so rotate by adding the new key first and revoking the old one after.
+export async function listNodeKeys(id: number): Promise<NodeKey[]> {
+  const headers = await signRequest('GET', '/keys')
+  return request(`/servers/${id}/keys`, { headers })
+}
+
+export async function addNodeKey(id: number, label: string, publicKeyPEM: string): Promise<NodeKey> {
+  const body = JSON.stringify({ label, public_key_pem: publicKeyPEM })
+  const headers = await signRequest('POST', '/keys', body)
+  return request(`/servers/${id}/public-key`, {
     method: 'POST',
-    headers: { 'Content-Type': 'application/json' },
-    body: JSON.stringify({ public_key_pem: publicKeyPEM }),
+    headers: { ...headers, 'Content-Type': 'application/json' },
+    body,
   })
 }
 
+export async function revokeNodeKey(id: number, label: string): Promise<void> {
+  const headers = await signRequest('DELETE', `/keys/${label}`)
+  await request(`/servers/${id}/keys/${encodeURIComponent(label)}`, { method: 'DELETE', headers })
+}
+
+// Metrics: the live reading is signed like any node call; the history is
+// what the creator has recorded and needs no signature.
+export async function getSystemMetrics(id: number): Promise<NodeMetrics> {
+  const headers = await signRequest('GET', '/system/metrics')
+  return request(`/servers/${id}/metrics`, { headers })
+}
+
+export async function getMetricsHistory(id: number, hours = 24): Promise<NodeMetrics[]> {
+  return request(`/servers/${id}/metrics/history?hours=${hours}`)
+}
+
+export async function listServices(id: number): Promise<NodeService[]> {
+  const headers = await signRequest('GET', '/services')
+  return request(`/servers/${id}/services`, { headers })
+}
+
+export async function serviceAction(
+  id: number,
+  name: NodeService['name'],
+  action: 'start' | 'stop' | 'restart',
+): Promise<NodeService> {
+  const headers = await signRequest('POST', `/services/${name}/${action}`)
+  return request(`/servers/${id}/services/${name}/${action}`, { method: 'POST', headers })
+}
+
+export async function listServerActions(id: number): Promise<ServerAction[]> {
+  return request(`/servers/${id}/actions`)
+}
+
+// Channel management on a running server. `channel` is the channel type.
+export interface ChannelChange {
+  channel: string
+  account?: string
+  token?: string
+  name?: string
+}
+
+async function signedJSON<T>(creatorPath: string, nodePath: string, method: string, payload?: object): Promise<T> {
+  const body = payload ? JSON.stringify(payload) : undefined
+  const headers = await signRequest(method, nodePath, body)
+  return request(creatorPath, {
+    method,
+    headers: body ? { ...headers, 'Content-Type': 'application/json' } : headers,
+    body,
+  })
+}
+
+export async function addChannel(id: number, change: ChannelChange): Promise<void> {
+  await signedJSON(`/servers/${id}/channels`, '/channels', 'POST', change)
+}
+
+export async function updateChannel(id: number, channel: string, account: string, update: { token?: string; name?: string }): Promise<void> {
+  const path = `/channels/${channel}/${account}`
+  await signedJSON(`/servers/${id}${path}`, path, 'PUT', update)
+}
+
+export async function disableChannel(id: number, channel: string, account: string): Promise<void> {
+  const path = `/channels/${channel}/${account}/disable`
+  await signedJSON(`/servers/${id}${path}`, path, 'POST')
+}
+
+export async function removeChannel(id: number, channel: string, account: string): Promise<void> {
+  const path = `/channels/${channel}/${account}`
+  await signedJSON(`/servers/${id}${path}`, path, 'DELETE')
+}
+
 export async function signedRequest(
   creatorPath: string,
   _nodePath: string,
@@ -72,11 +153,12 @@ export async function verifyChallenge(
   address: string,
   signature: string,
   challenge: string,
+  inviteCode?: string,
 ): Promise<{ user: import('../types').User }> {
   return request('/auth/verify', {
     method: 'POST',
     headers: { 'Content-Type': 'application/json' },
-    body: JSON.stringify({ address, signature, challenge }),
+    body: JSON.stringify({ address, signature, challenge, invite_code: inviteCode }),
   })
 }
 
@@ -84,6 +166,10 @@ export async function getConfig(): Promise<{ walletconnect_project_id: string }>
   return request('/config')
 }
 
+export async function listSSOProviders(): Promise<{ id: number; name: string }[]> {
+  return request('/auth/oidc/providers')
+}
+
 export async function logout(): Promise<void> {
   await request('/auth/logout', { method: 'POST' })
 }
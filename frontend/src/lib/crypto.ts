@@ -101,6 +101,8 @@ function derToRaw(der: Uint8Array): Uint8Array {
   return raw
 }
 
+// Node API signing spec v2 (see nodeapi/auth.go). `path` may carry a query
+// string, which is signed separately and must reach the node unchanged.
 export async function signRequest(
   method: string,
   path: string,
@@ -108,6 +110,11 @@ export async function signRequest(
 ): Promise<Record<string, string>> {
   const { privateKey } = await getOrCreateKeypair()
   const timestamp = Math.floor(Date.now() / 1000).toString()
+  const nonceBytes = crypto.getRandomValues(new Uint8Array(16))
+  const nonce = Array.from(nonceBytes, (b) => b.toString(16).padStart(2, '0')).join('')
+  const q = path.indexOf('?')
+  const pathOnly = q < 0 ? path : path.slice(0, q)
+  const query = q < 0 ? '' : path.slice(q + 1)
 
   let digest = ''
   if (body) {
@@ -116,7 +123,7 @@ export async function signRequest(
     digest = btoa(String.fromCharCode(...new Uint8Array(hashBuf)))
   }
 
-  const signingString = method + '\n' + path + '\n' + timestamp + '\n' + digest
+  const signingString = ['v2', method, pathOnly, query, timestamp, nonce, digest].join('\n')
   const sigBuf = await crypto.subtle.sign(
     { name: 'ECDSA', hash: 'SHA-256' },
     privateKey,
@@ -129,8 +136,9 @@ export async function signRequest(
   return {
     'X-Signature': sigB64,
     'X-Signature-Timestamp': timestamp,
+    'X-Signature-Nonce': nonce,
     'X-Content-Digest': digest,
-    'X-Signature-Method': 'ECDSA-P256-SHA256',
+    'X-Signature-Method': 'ECDSA-P256-SHA256/v2',
   }
 }
 
@@ -0,0 +1,106 @@
+import type { User } from '../types'
+
+// WebAuthn helpers. The server speaks the JSON shape of
+// PublicKeyCredential.toJSON(), with binary fields as base64url.
+
+function fromB64url(s: string): ArrayBuffer {
+  const b64 = s.replace(/-/g, '+').replace(/_/g, '/')
+  const bin = atob(b64 + '='.repeat((4 - (b64.length % 4)) % 4))
+  const out = new Uint8Array(bin.length)
+  for (let i = 0; i < bin.length; i++) out[i] = bin.charCodeAt(i)
+  return out.buffer
+}
+
+function toB64url(buf: ArrayBuffer | null): string | undefined {
+  if (!buf) return undefined
+  let bin = ''
+  for (const b of new Uint8Array(buf)) bin += String.fromCharCode(b)
+  return btoa(bin).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '')
+}
+
+async function post<T>(path: string, body?: unknown): Promise<T> {
+  const res = await fetch(path, {
+    method: 'POST',
+    credentials: 'include',
+    headers: { 'Content-Type': 'application/json' },
+    body: body === undefined ? undefined : JSON.stringify(body),
+  })
+  const data = await res.json().catch(() => ({ error: res.statusText }))
+  if (!res.ok) throw new Error(data.error || res.statusText)
+  return data as T
+}
+
+interface CreationOptionsJSON {
+  challenge: string
+  user: { id: string; name: string; displayName: string }
+  excludeCredentials: { type: 'public-key'; id: string }[]
+  [key: string]: unknown
+}
+
+interface RequestOptionsJSON {
+  challenge: string
+  allowCredentials: { type: 'public-key'; id: string }[]
+  [key: string]: unknown
+}
+
+export function passkeysSupported(): boolean {
+  return typeof window !== 'undefined' && 'PublicKeyCredential' in window
+}
+
+/** Registers a passkey: adds it to the signed-in account, or signs up a new one. */
+export async function registerPasskey(name?: string, inviteCode?: string): Promise<{ user?: User }> {
+  const opts = await post<CreationOptionsJSON>('/auth/passkey/register/begin')
+  const cred = (await navigator.credentials.create({
+    publicKey: {
+      ...opts,
+      challenge: fromB64url(opts.challenge),
+      user: { ...opts.user, id: fromB64url(opts.user.id) },
+      excludeCredentials: opts.excludeCredentials.map((c) => ({ ...c, id: fromB64url(c.id) })),
+    } as PublicKeyCredentialCreationOptions,
+  })) as PublicKeyCredential | null
+  if (!cred) throw new Error('passkey registration cancelled')
+
+  const response = cred.response as AuthenticatorAttestationResponse
+  return post('/auth/passkey/register/finish', {
+    credential: {
+      id: cred.id,
+      rawId: toB64url(cred.rawId),
+      type: cred.type,
+      response: {
+        clientDataJSON: toB64url(response.clientDataJSON),
+        attestationObject: toB64url(response.attestationObject),
+      },
+    },
+    name,
+    invite_code: inviteCode,
+  })
+}
+
+/** Signs in with a discoverable passkey. */
+export async function loginWithPasskey(inviteCode?: string): Promise<{ user: User }> {
+  const opts = await post<RequestOptionsJSON>('/auth/passkey/login/begin')
+  const cred = (await navigator.credentials.get({
+    publicKey: {
+      ...opts,
+      challenge: fromB64url(opts.challenge),
+      allowCredentials: opts.allowCredentials.map((c) => ({ ...c, id: fromB64url(c.id) })),
+    } as PublicKeyCredentialRequestOptions,
+  })) as PublicKeyCredential | null
+  if (!cred) throw new Error('passkey sign-in cancelled')
+
+  const response = cred.response as AuthenticatorAssertionResponse
+  return post('/auth/passkey/login/finish', {
+    credential: {
+      id: cred.id,
+      rawId: toB64url(cred.rawId),
+      type: cred.type,
+      response: {
+        clientDataJSON: toB64url(response.clientDataJSON),
+        authenticatorData: toB64url(response.authenticatorData),
+        signature: toB64url(response.signature),
+        userHandle: toB64url(response.userHandle),
+      },
+    },
+    invite_code: inviteCode,
+  })
+}
@@ -1,4 +1,5 @@
 import type { WSMessage } from '../types'
+import { signRequest } from './crypto'
 
 export type WSMessageHandler = (msg: WSMessage) => void
 export type WSCloseHandler = () => void
@@ -26,3 +27,33 @@ export function connectWebSocket(
 
   return ws
 }
+
+export type LiveLogMessage =
+  | { type: 'log'; line: string }
+  | { type: 'end' }
+  | { type: 'error'; error: string }
+
+// Live openclaw logs. The node request is signed here and sent as the first
+// message, since a WebSocket can't carry the signature headers.
+export async function connectLiveLogs(
+  serverId: number,
+  query: string,
+  onMessage: (msg: LiveLogMessage) => void,
+  onClose?: WSCloseHandler,
+): Promise<WebSocket> {
+  const headers = await signRequest('GET', query ? `/logs/tail?${query}` : '/logs/tail')
+  const proto = location.protocol === 'https:' ? 'wss:' : 'ws:'
+  const ws = new WebSocket(`${proto}//${location.host}/servers/${serverId}/logs/live`)
+
+  ws.onopen = () => ws.send(JSON.stringify({ query, headers }))
+  ws.onmessage = (evt) => {
+    try {
+      onMessage(JSON.parse(evt.data) as LiveLogMessage)
+    } catch {
+      // ignore parse errors
+    }
+  }
+  ws.onclose = () => onClose?.()
+
+  return ws
+}
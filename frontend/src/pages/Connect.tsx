@@ -1,9 +1,19 @@
+import { useEffect, useState } from 'react'
 import { Navigate } from 'react-router-dom'
 import { ConnectButton } from '@rainbow-me/rainbowkit'
 import { useAuth } from '../contexts/AuthContext'
+import { passkeysSupported } from '../lib/passkey'
+import { listSSOProviders } from '../lib/api'
 
 export function Connect() {
-  const { user, error } = useAuth()
+  const { user, error, passkeySignIn } = useAuth()
+  const [providers, setProviders] = useState<{ id: number; name: string }[]>([])
+  // Failed SSO callbacks land on /?sso_error=<message>
+  const ssoError = new URLSearchParams(window.location.search).get('sso_error')
+
+  useEffect(() => {
+    listSSOProviders().then(setProviders).catch(() => setProviders([]))
+  }, [])
 
   if (user && user.approved) return <Navigate to="/" replace />
   if (user && !user.approved) return <Navigate to="/awaiting" replace />
@@ -34,18 +44,47 @@ export function Connect() {
           <p className="text-xs text-text-secondary mb-5 leading-relaxed">
             Connect your Ethereum wallet to sign in. MetaMask, Rabby, WalletConnect, and more are supported.
           </p>
-          {error && (
-            <div className="text-xs text-red-400 bg-danger-muted border border-danger/20 font-mono px-3 py-2 rounded-md mb-4">{error}</div>
+          {(error || ssoError) && (
+            <div className="text-xs text-red-400 bg-danger-muted border border-danger/20 font-mono px-3 py-2 rounded-md mb-4">{error || ssoError}</div>
           )}
           <div className="flex justify-center">
             <ConnectButton />
           </div>
+          {passkeysSupported() && (
+            <div className="mt-5 pt-5 border-t border-border flex flex-col items-center gap-2">
+              <button
+                onClick={() => passkeySignIn(false)}
+                className="font-mono text-xs text-text-secondary hover:text-accent-text transition-colors"
+              >
+                sign in with a passkey
+              </button>
+              <button
+                onClick={() => passkeySignIn(true)}
+                className="font-mono text-[0.65rem] text-text-dim hover:text-text-secondary transition-colors"
+              >
+                no wallet? create an account with a passkey
+              </button>
+            </div>
+          )}
+          {providers.length > 0 && (
+            <div className="mt-5 pt-5 border-t border-border flex flex-col items-center gap-2">
+              {providers.map((p) => (
+                <a
+                  key={p.id}
+                  href={`/auth/oidc/${p.id}/login`}
+                  className="font-mono text-xs text-text-secondary hover:text-accent-text transition-colors"
+                >
+                  sign in with {p.name}
+                </a>
+              ))}
+            </div>
+          )}
         </div>
 
         {/* Footer detail */}
         <div className="text-center mt-6">
           <span className="font-mono text-[0.65rem] text-text-dim">
-            wallet signature required for session
+            wallet signature, passkey or SSO required for session
           </span>
         </div>
       </div>
@@ -10,7 +10,7 @@ import * as api from '../lib/api'
 export function Dashboard() {
   const navigate = useNavigate()
   const { servers, refresh } = useServers()
-  const { requests, approve, deny } = usePairingRequests(servers)
+  const { requests, errors: pairingErrors, approve, deny } = usePairingRequests(servers)
 
   const handleDelete = useCallback(
     async (id: number) => {
@@ -66,7 +66,7 @@ export function Dashboard() {
           <ServerList servers={servers} onDelete={handleDelete} />
         )}
       </section>
-      <PairingRequests requests={requests} onApprove={approve} onDeny={deny} />
+      <PairingRequests requests={requests} errors={pairingErrors} onApprove={approve} onDeny={deny} />
     </Layout>
   )
 }
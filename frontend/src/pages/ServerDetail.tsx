@@ -3,6 +3,10 @@ import { useParams, useNavigate } from 'react-router-dom'
 import type { ServerInfo } from '../types'
 import { Layout } from '../components/Layout'
 import { LogViewer } from '../components/LogViewer'
+import { MetricsPanel } from '../components/MetricsPanel'
+import { ServicesPanel } from '../components/ServicesPanel'
+import { LiveLogs } from '../components/LiveLogs'
+import { ChannelManager } from '../components/ChannelManager'
 import { useWebSocket } from '../hooks/useWebSocket'
 import * as api from '../lib/api'
 
@@ -67,6 +71,10 @@ export function ServerDetail() {
 
   return (
     <Layout>
+      {server.status === 'ready' && <MetricsPanel serverId={server.id} />}
+      {server.status === 'ready' && <ServicesPanel serverId={server.id} />}
+      {server.status === 'ready' && <ChannelManager serverId={server.id} />}
+      {server.status === 'ready' && <LiveLogs serverId={server.id} />}
       <LogViewer
         serverName={ws.serverName || server.name}
         serverIP={ws.serverIP || server.ipv4}
@@ -9,6 +9,8 @@ export interface ServerInfo {
   has_node_api: boolean
   created_at?: string
   channel_count?: number
+  agent_online?: boolean
+  agent_seen_at?: string
 }
 
 export interface ChannelConfig {
@@ -41,6 +43,55 @@ export interface LogEntry {
   line: string
 }
 
+export interface NodeKey {
+  label: string
+  fingerprint: string
+  added_at: string
+}
+
+export interface NodeMetrics {
+  collected_at: string
+  uptime_seconds: number
+  cpu_count: number
+  cpu_percent: number
+  load_1: number
+  load_5: number
+  load_15: number
+  mem_total_bytes: number
+  mem_available_bytes: number
+  swap_total_bytes: number
+  swap_free_bytes: number
+  disk_path?: string
+  disk_total_bytes: number
+  disk_free_bytes: number
+  processes: number
+  daemon_processes: number
+  gateway_processes: number
+}
+
+export interface NodeService {
+  name: 'daemon' | 'gateway'
+  unit: string
+  load_state: string
+  active_state: string
+  sub_state: string
+  main_pid?: string
+  since?: string
+  restarts?: string
+  enabled?: string
+}
+
+export interface ServerAction {
+  id: number
+  server_id: number
+  user_id: number
+  user_address: string
+  action: string
+  target: string
+  status: number
+  created_at: string
+}
+
 export interface PairingRequest {
   id: string
   channel: string
@@ -56,11 +107,28 @@ export interface PairingRequest {
   }
 }
 
+// A channel whose pairing requests couldn't be listed
+export interface PairingChannelError {
+  server_id: number
+  server_name: string
+  channel: string
+  error: string
+}
+
+// GET /pairing/requests. Nodes predating per-channel errors return a bare
+// PairingRequest array.
+export interface PairingList {
+  requests: PairingRequest[]
+  errors: { channel: string; error: string }[]
+  fetched_at: string
+}
+
 export interface User {
   id: number
   address: string
-  role: 'admin' | 'user'
+  role: 'admin' | 'owner' | 'operator' | 'viewer'
   approved: boolean
+  suspended: boolean
   ssh_public_key?: string
   created_at: string
 }
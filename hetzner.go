@@ -20,13 +20,18 @@ func NewHetznerClient(cfg *Config) *HetznerClient {
 	}
 }
 
-func (h *HetznerClient) CreateServer(ctx context.Context, name string) (*ServerInfo, error) {
-	slog.Info("creating server", "name", name, "type", h.cfg.ServerType, "image", h.cfg.Image, "location", h.cfg.Location)
+// CreateServer creates a server of the given type, or the configured
+// SERVER_TYPE if serverType is empty.
+func (h *HetznerClient) CreateServer(ctx context.Context, name, serverType string) (*ServerInfo, error) {
+	if serverType == "" {
+		serverType = h.cfg.ServerType
+	}
+	slog.Info("creating server", "name", name, "type", serverType, "image", h.cfg.Image, "location", h.cfg.Location)
 
 	result, _, err := h.client.Server.Create(ctx, hcloud.ServerCreateOpts{
 		Name: name,
 		ServerType: &hcloud.ServerType{
-			Name: h.cfg.ServerType,
+			Name: serverType,
 		},
 		Image: &hcloud.Image{
 			Name: h.cfg.Image,
@@ -80,3 +85,21 @@ func (h *HetznerClient) DeleteServer(ctx context.Context, id int64) error {
 	slog.Info("server deleted", "id", id)
 	return nil
 }
+
+// PowerOff hard-stops a server, waiting for the action to finish.
+func (h *HetznerClient) PowerOff(ctx context.Context, id int64) error {
+	action, _, err := h.client.Server.Poweroff(ctx, &hcloud.Server{ID: id})
+	if err != nil {
+		return fmt.Errorf("power off server: %w", err)
+	}
+	return h.client.Action.WaitFor(ctx, action)
+}
+
+// PowerOn starts a powered-off server, waiting for the action to finish.
+func (h *HetznerClient) PowerOn(ctx context.Context, id int64) error {
+	action, _, err := h.client.Server.Poweron(ctx, &hcloud.Server{ID: id})
+	if err != nil {
+		return fmt.Errorf("power on server: %w", err)
+	}
+	return h.client.Action.WaitFor(ctx, action)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Invite links onboard teammates without an admin round-trip. An invite
+// link is an invite code (see approval.go) that also carries a role, a
+// server quota and a use count; like any code it is random and only its
+// hash is stored, so it can't be derived or enumerated.
+
+// redeemInvite applies an invite code to a newly signed-in user and returns
+// the updated user.
+func (s *Server) redeemInvite(code string, user *User) (*User, error) {
+	invite, err := s.store.RedeemInviteCode(hashAPIToken(code), user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("invite code not redeemable: %w", err)
+	}
+	slog.Info("invite code redeemed", "invite_id", invite.ID, "user_id", user.ID)
+	return s.store.GetUserByID(user.ID)
+}
+
+func (s *Server) inviteLink(token string) string {
+	return s.config.PublicURL + "/?invite=" + token
+}
+
+// Invite handlers (admin)
+
+func (s *Server) handleCreateInvite(w http.ResponseWriter, r *http.Request) {
+	admin := userFromContext(r.Context())
+
+	var req CreateInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+		return
+	}
+	if req.Role != "" && (!validRole(req.Role) || req.Role == RoleAdmin) {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "role must be one of viewer, operator, owner"})
+		return
+	}
+	if req.MaxServers != nil && *req.MaxServers < 0 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "max_servers must not be negative"})
+		return
+	}
+	maxUses := 1
+	if req.MaxUses != nil {
+		maxUses = *req.MaxUses
+	}
+	if maxUses < 0 || req.ExpiresInDays < 0 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "max_uses and expires_in_days must not be negative"})
+		return
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().Add(time.Duration(req.ExpiresInDays) * 24 * time.Hour)
+		expiresAt = &t
+	}
+
+	code, hash, err := newInviteCode()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "internal error"})
+		return
+	}
+	invite, err := s.store.CreateInviteCode(hash, admin.ID, expiresAt, req.Role, req.MaxServers, maxUses)
+	if err != nil {
+		slog.Error("failed to create invite", "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to create invite"})
+		return
+	}
+	invite.Code = code
+	invite.Link = s.inviteLink(code)
+	slog.Info("invite created", "invite_id", invite.ID, "role", invite.Role, "max_uses", invite.MaxUses, "by", admin.ID)
+
+	writeJSON(w, http.StatusCreated, invite)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Log levels, ordered by severity. Provisioning output is plain Ansible text,
+// so the level is derived from the line content rather than stored.
+var logLevels = map[string]int{
+	"info":  0,
+	"warn":  1,
+	"error": 2,
+}
+
+func logLevel(line string) string {
+	switch {
+	case strings.Contains(line, "fatal:"),
+		strings.Contains(line, "FAILED"),
+		strings.Contains(line, "UNREACHABLE"),
+		strings.Contains(line, "ERROR"),
+		strings.HasPrefix(line, "SSH wait failed"):
+		return "error"
+	case strings.Contains(line, "[WARNING]"),
+		strings.Contains(line, "[DEPRECATION WARNING]"),
+		strings.Contains(line, "...ignoring"):
+		return "warn"
+	default:
+		return "info"
+	}
+}
+
+// LogFilter narrows a server's log history. Zero values match everything.
+type LogFilter struct {
+	Query    string    // case-insensitive substring
+	MinLevel string    // "info", "warn" or "error"
+	Since    time.Time // only lines at or after this time
+}
+
+func (f LogFilter) match(entry LogEntry) bool {
+	if f.Query != "" && !strings.Contains(strings.ToLower(entry.Line), strings.ToLower(f.Query)) {
+		return false
+	}
+	if f.MinLevel != "" && logLevels[entry.Level] < logLevels[f.MinLevel] {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	return true
+}
+
+func parseLogFilter(r *http.Request) (LogFilter, error) {
+	q := r.URL.Query()
+	f := LogFilter{Query: q.Get("q")}
+
+	if level := strings.ToLower(q.Get("level")); level != "" {
+		if _, ok := logLevels[level]; !ok {
+			return f, fmt.Errorf("level must be one of info, warn, error")
+		}
+		f.MinLevel = level
+	}
+
+	if since := q.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			// Also accept a unix timestamp
+			unix, uerr := strconv.ParseInt(since, 10, 64)
+			if uerr != nil {
+				return f, fmt.Errorf("since must be an RFC 3339 time or unix timestamp")
+			}
+			t = time.Unix(unix, 0)
+		}
+		f.Since = t
+	}
+
+	return f, nil
+}
+
+// encodeLogArchive writes entries as "<RFC3339 time> <line>" text and gzips it.
+// The same format is served by the download endpoint.
+func encodeLogArchive(entries []LogEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := writeLogText(zw, entries); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeLogArchive(data []byte) ([]LogEntry, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("open archive: %w", err)
+	}
+	defer zr.Close()
+
+	var entries []LogEntry
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		ts, line, _ := strings.Cut(scanner.Text(), " ")
+		t, _ := time.Parse(time.RFC3339Nano, ts)
+		entries = append(entries, LogEntry{
+			Line:      line,
+			Level:     logLevel(line),
+			Time:      t,
+			CreatedAt: ts,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read archive: %w", err)
+	}
+	return entries, nil
+}
+
+func writeLogText(w io.Writer, entries []LogEntry) error {
+	bw := bufio.NewWriter(w)
+	for _, entry := range entries {
+		if _, err := fmt.Fprintf(bw, "%s %s\n", entry.Time.UTC().Format(time.RFC3339Nano), entry.Line); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// handleServerLogs returns a server's log history, filtered by ?q=, ?level= and ?since=.
+func (s *Server) handleServerLogs(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid server id"})
+		return
+	}
+
+	filter, err := parseLogFilter(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if _, err := s.lookupServer(user, id, PermServersRead); err != nil {
+		writeLookupError(w, err)
+		return
+	}
+
+	entries, err := s.store.ListLogs(id, filter)
+	if err != nil {
+		slog.Error("failed to list logs", "server_id", id, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to list logs"})
+		return
+	}
+	if entries == nil {
+		entries = []LogEntry{}
+	}
+
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// handleDownloadServerLogs streams the (filtered) log history as gzipped text.
+func (s *Server) handleDownloadServerLogs(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid server id"})
+		return
+	}
+
+	filter, err := parseLogFilter(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	info, err := s.lookupServer(user, id, PermServersRead)
+	if err != nil {
+		writeLookupError(w, err)
+		return
+	}
+
+	entries, err := s.store.ListLogs(id, filter)
+	if err != nil {
+		slog.Error("failed to list logs", "server_id", id, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to list logs"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-logs.txt.gz"`, info.Name))
+	zw := gzip.NewWriter(w)
+	if err := writeLogText(zw, entries); err != nil {
+		slog.Warn("log download interrupted", "server_id", id, "error", err)
+		return
+	}
+	zw.Close()
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Live openclaw logs: a WebSocket relay of the node's signed GET /logs/tail
+// stream (see nodeapi/journal.go). Browsers can't set headers on a
+// WebSocket, so the client sends the signed request as its first message:
+//
+//	{"query": "service=gateway&lines=200", "headers": {"X-Signature": ...}}
+//
+// where the signature covers GET /logs/tail with exactly that query. The
+// server answers with {"type":"log","line":...} messages, redacted like
+// provisioning output, then {"type":"end"} or {"type":"error","error":...}.
+
+const liveLogsStartTimeout = 10 * time.Second
+
+type liveLogsStart struct {
+	Query   string            `json:"query"`
+	Headers map[string]string `json:"headers"`
+}
+
+func (s *Server) handleLiveLogs(w http.ResponseWriter, r *http.Request) {
+	// WebSocket auth: check session cookie
+	user, r := s.sessionAuth(r)
+	if user == nil || !user.Approved {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if token := apiTokenFromContext(r.Context()); token != nil && !token.HasScope(PermServersRead) {
+		http.Error(w, "token missing scope "+PermServersRead, http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid server id", http.StatusBadRequest)
+		return
+	}
+	info, err := s.lookupServer(user, id, PermServersRead)
+	if err != nil {
+		http.Error(w, "server not found", http.StatusNotFound)
+		return
+	}
+	if info.Status != "ready" || !info.HasNodeAPI {
+		http.Error(w, "node API not available on this server", http.StatusConflict)
+		return
+	}
+	if s.replayToTunnel(w, r, info) {
+		return
+	}
+	secrets, err := s.store.GetServerSecrets(id)
+	if err != nil {
+		slog.Error("failed to load server secrets", "server_id", id, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Error("websocket upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	sendError := func(msg string) {
+		conn.WriteJSON(map[string]any{"type": "error", "error": msg})
+	}
+
+	conn.SetReadDeadline(time.Now().Add(liveLogsStartTimeout))
+	var start liveLogsStart
+	if err := conn.ReadJSON(&start); err != nil {
+		sendError("expected a signed start message")
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	// Read pump: drain reads, detect disconnect and stop the node stream
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Present the start message to callNode as the signed request
+	nodeReq := r.Clone(ctx)
+	nodeReq.URL.RawQuery = start.Query
+	nodeReq.Header = http.Header{}
+	for k, v := range start.Headers {
+		nodeReq.Header.Set(k, v)
+	}
+
+	resp, err := s.streamNode(nodeReq, info, "GET", "/logs/tail")
+	if err != nil {
+		slog.Error("proxy to node failed", "server_id", id, "path", "/logs/tail", "error", err)
+		sendError("node unreachable")
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		slog.Warn("node returned error", "server_id", id, "path", "/logs/tail", "status", resp.StatusCode, "body", string(body))
+		var nodeErr ErrorResponse
+		if json.Unmarshal(body, &nodeErr) != nil || nodeErr.Error == "" {
+			nodeErr.Error = http.StatusText(resp.StatusCode)
+		}
+		sendError(nodeErr.Error)
+		return
+	}
+	slog.Info("live logs started", "server_id", id, "user_id", user.ID, "query", start.Query)
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		sc := bufio.NewScanner(resp.Body)
+		sc.Buffer(make([]byte, 64<<10), 1<<20)
+		for sc.Scan() {
+			select {
+			case lines <- redactLine(sc.Text(), secrets):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(54 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				conn.WriteJSON(map[string]any{"type": "end"})
+				return
+			}
+			if err := conn.WriteJSON(map[string]any{"type": "log", "line": line}); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
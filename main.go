@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log/slog"
 	"net/http"
 	"os"
@@ -36,20 +37,46 @@ func main() {
 
 	store.FailStaleProvisioningServers()
 
-	// Periodically clean expired sessions
+	// Periodically clean expired sessions and old metrics samples
 	go func() {
 		for {
 			time.Sleep(1 * time.Hour)
 			store.CleanExpiredSessions()
+			store.PruneMetricsSamples(metricsRetention)
 		}
 	}()
 
+	// Periodically compact logs of finished servers
+	if cfg.LogRetentionDays > 0 {
+		retention := time.Duration(cfg.LogRetentionDays) * 24 * time.Hour
+		go func() {
+			for {
+				time.Sleep(6 * time.Hour)
+				store.CompactServerLogs(retention)
+			}
+		}()
+	}
+
 	hetzner := NewHetznerClient(cfg)
 	provisioner := NewProvisioner(cfg)
 	hub := NewLogHub()
 
 	srv := NewServer(cfg, hetzner, provisioner, store, hub)
 
+	// EIP-1271 and token-gated approval read ETH_RPC_URL's chain; a node on
+	// another chain than the one users sign in for would check the wrong state
+	if srv.eth != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		chainID, err := srv.eth.ChainID(ctx)
+		cancel()
+		if err != nil {
+			slog.Warn("could not check ETH_RPC_URL chain", "error", err)
+		} else if chainID != cfg.SIWEChainID {
+			slog.Error("ETH_RPC_URL serves a different chain than SIWE_CHAIN_ID", "rpc_chain_id", chainID, "siwe_chain_id", cfg.SIWEChainID)
+			os.Exit(1)
+		}
+	}
+
 	// Periodically clean expired challenges
 	go func() {
 		for {
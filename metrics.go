@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Node metrics history. The creator holds no node signing key, so it can't
+// poll nodes itself. Samples come from nodes in agent mode, which push one a
+// minute over their tunnel, and from users' own signed GET .../metrics
+// calls, recorded at most once per metricsSampleInterval.
+
+const (
+	metricsSampleInterval = 50 * time.Second // a little under the agent push interval
+	metricsRetention      = 7 * 24 * time.Hour
+	metricsMaxHistory     = 7 * 24 // hours
+)
+
+// recordMetrics stores a sample from a node's /system/metrics JSON.
+func (s *Server) recordMetrics(serverID int64, data []byte) {
+	var m NodeMetrics
+	if err := json.Unmarshal(data, &m); err != nil {
+		slog.Warn("invalid node metrics", "server_id", serverID, "error", err)
+		return
+	}
+	if _, err := s.store.RecordMetricsSample(serverID, &m, metricsSampleInterval); err != nil {
+		slog.Error("failed to record metrics sample", "server_id", serverID, "error", err)
+	}
+}
+
+func (s *Server) handleSystemMetrics(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	info, ok := s.nodeServer(w, r, id, PermServersRead)
+	if !ok {
+		return
+	}
+
+	resp, err := s.callNode(r, info, "GET", "/system/metrics", nil)
+	if err != nil {
+		slog.Error("proxy to node failed", "server_id", id, "path", "/system/metrics", "error", err)
+		writeJSON(w, http.StatusBadGateway, ErrorResponse{Error: "node unreachable"})
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		relayNodeResponse(w, id, "/system/metrics", resp)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, ErrorResponse{Error: "node unreachable"})
+		return
+	}
+	s.recordMetrics(id, body)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// handleMetricsHistory returns stored samples from the last ?hours= hours
+// (default 24, at most a week).
+func (s *Server) handleMetricsHistory(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid server id"})
+		return
+	}
+	if _, err := s.lookupServer(user, id, PermServersRead); err != nil {
+		writeLookupError(w, err)
+		return
+	}
+
+	hours := 24
+	if v := r.URL.Query().Get("hours"); v != "" {
+		hours, err = strconv.Atoi(v)
+		if err != nil || hours < 1 || hours > metricsMaxHistory {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "hours must be between 1 and 168"})
+			return
+		}
+	}
+
+	samples, err := s.store.ListMetricsSamples(id, time.Now().Add(-time.Duration(hours)*time.Hour))
+	if err != nil {
+		slog.Error("failed to list metrics samples", "server_id", id, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to load metrics"})
+		return
+	}
+	writeJSON(w, http.StatusOK, samples)
+}
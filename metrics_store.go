@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Metrics history operations
+
+// RecordMetricsSample stores a sample unless the server already has one
+// from the last minimum interval, so frequent polling doesn't bloat the
+// table. It reports whether the sample was stored.
+func (s *Store) RecordMetricsSample(serverID int64, m *NodeMetrics, minInterval time.Duration) (bool, error) {
+	result, err := s.db.Exec(`
+		INSERT INTO server_metrics (server_id, uptime_seconds, cpu_count, cpu_percent, load_1, load_5, load_15,
+			mem_total, mem_available, swap_total, swap_free, disk_total, disk_free,
+			processes, daemon_processes, gateway_processes)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16
+		WHERE NOT EXISTS (
+			SELECT 1 FROM server_metrics WHERE server_id=$1 AND sampled_at > now() - make_interval(secs => $17)
+		)
+	`, serverID, m.UptimeSeconds, m.CPUCount, m.CPUPercent, m.Load1, m.Load5, m.Load15,
+		m.MemTotal, m.MemAvailable, m.SwapTotal, m.SwapFree, m.DiskTotal, m.DiskFree,
+		m.Processes, m.DaemonProcs, m.GatewayProcs, minInterval.Seconds())
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// ListMetricsSamples returns a server's samples since the given time, oldest
+// first.
+func (s *Store) ListMetricsSamples(serverID int64, since time.Time) ([]NodeMetrics, error) {
+	rows, err := s.db.Query(`
+		SELECT sampled_at, uptime_seconds, cpu_count, cpu_percent, load_1, load_5, load_15,
+			mem_total, mem_available, swap_total, swap_free, disk_total, disk_free,
+			processes, daemon_processes, gateway_processes
+		FROM server_metrics WHERE server_id=$1 AND sampled_at >= $2
+		ORDER BY sampled_at
+	`, serverID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := []NodeMetrics{}
+	for rows.Next() {
+		var m NodeMetrics
+		if err := rows.Scan(&m.CollectedAt, &m.UptimeSeconds, &m.CPUCount, &m.CPUPercent, &m.Load1, &m.Load5, &m.Load15,
+			&m.MemTotal, &m.MemAvailable, &m.SwapTotal, &m.SwapFree, &m.DiskTotal, &m.DiskFree,
+			&m.Processes, &m.DaemonProcs, &m.GatewayProcs); err != nil {
+			return nil, err
+		}
+		samples = append(samples, m)
+	}
+	return samples, rows.Err()
+}
+
+func (s *Store) PruneMetricsSamples(retention time.Duration) {
+	result, err := s.db.Exec(`DELETE FROM server_metrics WHERE sampled_at < $1`, time.Now().Add(-retention))
+	if err != nil {
+		slog.Error("failed to prune metrics samples", "error", err)
+		return
+	}
+	n, _ := result.RowsAffected()
+	if n > 0 {
+		slog.Info("pruned metrics samples", "count", n)
+	}
+}
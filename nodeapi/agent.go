@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Agent mode: instead of (or as well as) listening on 8443, the node dials
+// out to the creator over a WebSocket and serves proxied requests through
+// it, so no inbound port needs to be open. The node authenticates with its
+// TLS key, whose fingerprint the creator already pins (see tls.go): right
+// after connecting, the creator sends a "challenge" (body: a random nonce)
+// and the node answers with "auth", carrying its certificate and a signature
+// over the creator's host and the nonce in X-Agent-Certificate and
+// X-Agent-Signature. A captured handshake is useless for another connection.
+//
+// Every message is a JSON tunnelMessage in a text frame. The creator sends
+// "request" (and "cancel" if its client goes away); the node answers with
+// "response" (status and headers), any number of "data" chunks, and "end".
+// Unprompted, the node also sends a "metrics" message (ID 0, body a
+// systemMetrics) every minute, so the creator can keep a history without
+// signing requests of its own.
+
+const (
+	agentMaxBackoff  = time.Minute
+	agentAuthTimeout = 10 * time.Second
+	agentReadTimeout = 90 * time.Second // the creator pings every 30s
+	agentChunkSize   = 32 << 10
+	agentMetricsPush = time.Minute
+)
+
+type tunnelMessage struct {
+	ID     uint64      `json:"id"`
+	Type   string      `json:"type"`
+	Method string      `json:"method,omitempty"`
+	Path   string      `json:"path,omitempty"`
+	Query  string      `json:"query,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	Status int         `json:"status,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+// answerChallenge proves possession of the node's TLS key: a signature over
+// the creator's host and the nonce it just sent.
+func answerChallenge(conn *wsConn, cert tls.Certificate, host string) error {
+	signer, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("TLS key cannot sign")
+	}
+
+	conn.conn.SetReadDeadline(time.Now().Add(agentAuthTimeout))
+	op, data, err := conn.readMessage()
+	if err != nil {
+		return fmt.Errorf("read challenge: %w", err)
+	}
+	var m tunnelMessage
+	if op != wsOpText || json.Unmarshal(data, &m) != nil || m.Type != "challenge" || len(m.Body) < 16 {
+		return fmt.Errorf("expected a challenge")
+	}
+
+	digest := sha256.Sum256([]byte("openclaw-agent-v2\n" + host + "\n" + base64.StdEncoding.EncodeToString(m.Body)))
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return err
+	}
+	h := http.Header{}
+	h.Set("X-Agent-Certificate", base64.StdEncoding.EncodeToString(cert.Certificate[0]))
+	h.Set("X-Agent-Signature", base64.StdEncoding.EncodeToString(sig))
+	reply, err := json.Marshal(&tunnelMessage{Type: "auth", Header: h})
+	if err != nil {
+		return err
+	}
+	return conn.writeMessage(wsOpText, reply)
+}
+
+// runAgent keeps a tunnel to the creator open, reconnecting with backoff.
+func runAgent(agentURL string, cert tls.Certificate, handler http.Handler) {
+	u, err := url.Parse(agentURL)
+	if err != nil {
+		log.Fatalf("invalid agent URL: %v", err)
+	}
+	if u.Scheme != "wss" {
+		log.Fatalf("agent URL must use wss://, not %s://", u.Scheme)
+	}
+
+	backoff := time.Second
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		conn, err := dialWebSocket(ctx, agentURL)
+		cancel()
+		if err == nil {
+			if err = answerChallenge(conn, cert, u.Host); err != nil {
+				conn.close()
+			}
+		}
+		if err != nil {
+			log.Printf("agent: connect to %s failed: %v (retrying in %s)", u.Host, err, backoff)
+			time.Sleep(backoff)
+			backoff = min(backoff*2, agentMaxBackoff)
+			continue
+		}
+
+		log.Printf("agent: connected to %s", u.Host)
+		started := time.Now()
+		err = serveTunnel(conn, handler)
+		log.Printf("agent: disconnected: %v", err)
+		if time.Since(started) > agentMaxBackoff {
+			backoff = time.Second
+		}
+		time.Sleep(backoff)
+		backoff = min(backoff*2, agentMaxBackoff)
+	}
+}
+
+// serveTunnel handles requests from the creator until the connection fails.
+func serveTunnel(conn *wsConn, handler http.Handler) error {
+	defer conn.close()
+
+	var mu sync.Mutex
+	inflight := make(map[uint64]context.CancelFunc)
+	defer func() {
+		mu.Lock()
+		for _, cancel := range inflight {
+			cancel()
+		}
+		mu.Unlock()
+	}()
+
+	send := func(m *tunnelMessage) error {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		return conn.writeMessage(wsOpText, data)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go pushMetrics(send, done)
+
+	for {
+		conn.conn.SetReadDeadline(time.Now().Add(agentReadTimeout))
+		op, data, err := conn.readMessage()
+		if err != nil {
+			return err
+		}
+		if op != wsOpText {
+			continue
+		}
+		var m tunnelMessage
+		if err := json.Unmarshal(data, &m); err != nil {
+			log.Printf("agent: bad message: %v", err)
+			continue
+		}
+
+		switch m.Type {
+		case "request":
+			ctx, cancel := context.WithCancel(context.Background())
+			mu.Lock()
+			inflight[m.ID] = cancel
+			mu.Unlock()
+			go func() {
+				defer func() {
+					mu.Lock()
+					delete(inflight, m.ID)
+					mu.Unlock()
+					cancel()
+				}()
+				serveTunnelRequest(ctx, &m, handler, send)
+			}()
+		case "cancel":
+			mu.Lock()
+			if cancel, ok := inflight[m.ID]; ok {
+				cancel()
+			}
+			mu.Unlock()
+		}
+	}
+}
+
+// pushMetrics sends a metrics sample now and every agentMetricsPush until
+// done is closed.
+func pushMetrics(send func(*tunnelMessage) error, done <-chan struct{}) {
+	ticker := time.NewTicker(agentMetricsPush)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		m, err := collectMetrics(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("agent: collect metrics: %v", err)
+		} else if body, err := json.Marshal(m); err == nil {
+			send(&tunnelMessage{Type: "metrics", Body: body})
+		}
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+func serveTunnelRequest(ctx context.Context, m *tunnelMessage, handler http.Handler, send func(*tunnelMessage) error) {
+	target := "http://tunnel" + m.Path
+	if m.Query != "" {
+		target += "?" + m.Query
+	}
+	req, err := http.NewRequestWithContext(ctx, m.Method, target, bytes.NewReader(m.Body))
+	if err != nil {
+		send(&tunnelMessage{ID: m.ID, Type: "response", Status: http.StatusBadRequest})
+		send(&tunnelMessage{ID: m.ID, Type: "end"})
+		return
+	}
+	if m.Header != nil {
+		req.Header = m.Header
+	}
+	req.RemoteAddr = "tunnel"
+
+	tw := &tunnelResponseWriter{id: m.ID, header: http.Header{}, send: send}
+	handler.ServeHTTP(tw, req)
+	tw.WriteHeader(http.StatusOK) // no-op if the handler wrote one
+	send(&tunnelMessage{ID: m.ID, Type: "end"})
+}
+
+// tunnelResponseWriter streams a handler's response back as messages. It
+// implements http.Flusher; every Write is sent immediately.
+type tunnelResponseWriter struct {
+	id          uint64
+	header      http.Header
+	wroteHeader bool
+	send        func(*tunnelMessage) error
+}
+
+func (w *tunnelResponseWriter) Header() http.Header { return w.header }
+
+func (w *tunnelResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.send(&tunnelMessage{ID: w.id, Type: "response", Status: status, Header: w.header})
+}
+
+func (w *tunnelResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	for off := 0; off < len(p); off += agentChunkSize {
+		end := min(off+agentChunkSize, len(p))
+		if err := w.send(&tunnelMessage{ID: w.id, Type: "data", Body: p[off:end]}); err != nil {
+			return off, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *tunnelResponseWriter) Flush() {}
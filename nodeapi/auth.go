@@ -1,30 +1,93 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"io"
 	"math/big"
 	"net/http"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
-const maxTimestampAge = 5 * time.Minute
+// Request signing, version 2.
+//
+// Clients sign with an ECDSA P-256 key and send:
+//
+//	X-Signature-Method:    ECDSA-P256-SHA256/v2
+//	X-Signature-Timestamp: unix seconds, within 5 minutes of the node's clock
+//	X-Signature-Nonce:     16-64 chars of [A-Za-z0-9_-], never reused
+//	X-Content-Digest:      base64(SHA-256(body)), or empty for an empty body
+//	X-Signature:           base64(r||s) over SHA-256 of the signing string
+//
+// The signing string is the lines
+//
+//	v2
+//	METHOD
+//	PATH
+//	RAW QUERY (without "?"; empty if none)
+//	TIMESTAMP
+//	NONCE
+//	DIGEST
+//
+// joined by "\n". The node checks the digest against the body it received
+// and refuses any nonce it has seen while the timestamp is still valid. The
+// method is echoed in every response so clients can discover the version.
+// Any authorized key (see keys.go) may sign.
 
-func requireAuth(pubKey *ecdsa.PublicKey, next http.HandlerFunc) http.HandlerFunc {
+const (
+	signatureMethod = "ECDSA-P256-SHA256/v2"
+	maxTimestampAge = 5 * time.Minute
+)
+
+var nonceRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{16,64}$`)
+
+var nonces = newNonceCache(maxNonces)
+
+type contextKey string
+
+const keyLabelContextKey contextKey = "keyLabel"
+
+// keyLabelFromContext returns the label of the key that signed the request.
+func keyLabelFromContext(ctx context.Context) string {
+	label, _ := ctx.Value(keyLabelContextKey).(string)
+	return label
+}
+
+func signingString(r *http.Request, ts, nonce, digest string) string {
+	return strings.Join([]string{"v2", r.Method, r.URL.Path, r.URL.RawQuery, ts, nonce, digest}, "\n")
+}
+
+func requireAuth(kr *keyring, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Signature-Method", signatureMethod)
+
 		sig := r.Header.Get("X-Signature")
 		ts := r.Header.Get("X-Signature-Timestamp")
+		nonce := r.Header.Get("X-Signature-Nonce")
 		digest := r.Header.Get("X-Content-Digest")
 
-		if sig == "" || ts == "" {
+		if sig == "" || ts == "" || nonce == "" {
 			writeError(w, http.StatusUnauthorized, "missing signature headers")
 			return
 		}
+		if m := r.Header.Get("X-Signature-Method"); m != signatureMethod {
+			writeError(w, http.StatusUnauthorized, "unsupported signature method, expected "+signatureMethod)
+			return
+		}
+		if !nonceRegex.MatchString(nonce) {
+			writeError(w, http.StatusUnauthorized, "invalid nonce")
+			return
+		}
 
 		// Verify timestamp freshness
 		tsUnix, err := strconv.ParseInt(ts, 10, 64)
@@ -32,7 +95,8 @@ func requireAuth(pubKey *ecdsa.PublicKey, next http.HandlerFunc) http.HandlerFun
 			writeError(w, http.StatusUnauthorized, "invalid timestamp")
 			return
 		}
-		age := time.Since(time.Unix(tsUnix, 0))
+		signedAt := time.Unix(tsUnix, 0)
+		age := time.Since(signedAt)
 		if age < 0 {
 			age = -age
 		}
@@ -41,9 +105,24 @@ func requireAuth(pubKey *ecdsa.PublicKey, next http.HandlerFunc) http.HandlerFun
 			return
 		}
 
-		// Reconstruct signing string: METHOD\nPATH\nTIMESTAMP\nDIGEST
-		signingString := fmt.Sprintf("%s\n%s\n%s\n%s", r.Method, r.URL.Path, ts, digest)
-		hash := sha256.Sum256([]byte(signingString))
+		// The digest must describe the body we actually received
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+		if err != nil {
+			writeError(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		want := ""
+		if len(body) > 0 {
+			sum := sha256.Sum256(body)
+			want = base64.StdEncoding.EncodeToString(sum[:])
+		}
+		if subtle.ConstantTimeCompare([]byte(digest), []byte(want)) != 1 {
+			writeError(w, http.StatusUnauthorized, "content digest mismatch")
+			return
+		}
+
+		hash := sha256.Sum256([]byte(signingString(r, ts, nonce, digest)))
 
 		// Decode base64 signature (64 bytes raw IEEE P1363: r||s, 32 bytes each)
 		sigBytes, err := base64.StdEncoding.DecodeString(sig)
@@ -61,12 +140,30 @@ func requireAuth(pubKey *ecdsa.PublicKey, next http.HandlerFunc) http.HandlerFun
 		rInt := new(big.Int).SetBytes(sigBytes[:byteLen])
 		sInt := new(big.Int).SetBytes(sigBytes[byteLen:])
 
-		if !ecdsa.Verify(pubKey, hash[:], rInt, sInt) {
+		var signer *authorizedKey
+		for _, k := range kr.list() {
+			if ecdsa.Verify(k.pub, hash[:], rInt, sInt) {
+				signer = k
+				break
+			}
+		}
+		if signer == nil {
 			writeError(w, http.StatusUnauthorized, "invalid signature")
 			return
 		}
 
-		next(w, r)
+		// Only now record the nonce, so unsigned junk can't fill the cache.
+		// It must outlive every moment the timestamp would still be accepted.
+		if err := nonces.use(nonce, signedAt.Add(maxTimestampAge)); err != nil {
+			if errors.Is(err, errReplayCacheFull) {
+				writeError(w, http.StatusServiceUnavailable, "too many requests in flight, retry shortly")
+				return
+			}
+			writeError(w, http.StatusUnauthorized, "replayed request")
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), keyLabelContextKey, signer.Label)))
 	}
 }
 
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Channel management after provisioning, through the openclaw CLI:
+//
+//	POST   /channels                              add a channel account
+//	PUT    /channels/{channel}/{account}          replace its token and/or name
+//	POST   /channels/{channel}/{account}/disable  keep its config but stop it
+//	DELETE /channels/{channel}/{account}          remove it and its config
+//
+// Re-adding an existing account overwrites it, which is how tokens rotate.
+// Tokens are never echoed back, not even in CLI error output, and never go on
+// the command line (anyone on the node can read /proc/*/cmdline). Instead
+// they go where provisioning puts them: TELEGRAM_BOT_TOKEN and friends, in
+// the CLI's environment and in provider.env, which the gateway unit loads on
+// every start. Those variables hold one token per channel type, the default
+// account's, so tokens can only be set on the default account.
+
+var channelTypes = map[string]bool{
+	"telegram": true, "discord": true, "slack": true, "whatsapp": true,
+	"signal": true, "googlechat": true, "mattermost": true,
+}
+
+// channelTokenEnv is the environment variable holding each channel's
+// default-account token.
+var channelTokenEnv = map[string]string{
+	"telegram": "TELEGRAM_BOT_TOKEN",
+	"discord":  "DISCORD_BOT_TOKEN",
+	"slack":    "SLACK_BOT_TOKEN",
+}
+
+// None of these may start with '-', so no value can be taken for a flag.
+// providerEnvPath is the gateway unit's EnvironmentFile.
+const providerEnvPath = "/home/clawdbot/.clawdbot/credentials/provider.env"
+
+var (
+	channelAccountRegex = regexp.MustCompile(`^[A-Za-z0-9_.][A-Za-z0-9_.-]{0,63}$`)
+	channelTokenRegex   = regexp.MustCompile(`^[A-Za-z0-9_:.+/=][A-Za-z0-9_:.+/=-]{0,511}$`)
+	channelNameRegex    = regexp.MustCompile(`^([A-Za-z0-9_.][A-Za-z0-9_. -]{0,63})?$`)
+)
+
+type channelRequest struct {
+	Channel string `json:"channel"`
+	Account string `json:"account"`
+	Token   string `json:"token"`
+	Name    string `json:"name"`
+}
+
+// validate checks the fields the CLI will see. The token is optional for
+// channels that pair by QR code (whatsapp, signal).
+func (c *channelRequest) validate() string {
+	if !channelTypes[c.Channel] {
+		return "unknown channel type"
+	}
+	if c.Account == "" {
+		c.Account = "default"
+	}
+	if !channelAccountRegex.MatchString(c.Account) {
+		return "account must be 1-64 chars of A-Z, a-z, 0-9, '.', '_' or '-', not starting with '-'"
+	}
+	if c.Token != "" {
+		if channelTokenEnv[c.Channel] == "" {
+			return c.Channel + " tokens can't be set through the API"
+		}
+		if c.Account != "default" {
+			return "tokens can only be set on the default account"
+		}
+		if !channelTokenRegex.MatchString(c.Token) {
+			return "token contains unsupported characters"
+		}
+	}
+	if !channelNameRegex.MatchString(c.Name) {
+		return "name must be at most 64 letters, digits, spaces, '.', '_' or '-', not starting with '-' or a space"
+	}
+	return ""
+}
+
+// addChannel runs channels add, which creates or overwrites the account.
+func addChannel(r *http.Request, c *channelRequest) ([]byte, error) {
+	args := []string{"channels", "add", "--channel=" + c.Channel, "--account=" + c.Account}
+	var env []string
+	if c.Token != "" {
+		env = append(env, channelTokenEnv[c.Channel]+"="+c.Token)
+	}
+	if c.Name != "" {
+		args = append(args, "--name="+c.Name)
+	}
+	out, err := runCLIEnv(r.Context(), env, args...)
+	pairing.invalidate()
+	if c.Token != "" {
+		out = []byte(strings.ReplaceAll(string(out), c.Token, "***"))
+		if err == nil {
+			err = setProviderEnv(channelTokenEnv[c.Channel], c.Token)
+		}
+	}
+	return out, err
+}
+
+// setProviderEnv sets key to value in provider.env, or removes it when value
+// is empty, so the token survives the gateway's next restart.
+func setProviderEnv(key, value string) error {
+	data, err := os.ReadFile(providerEnvPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read provider.env: %w", err)
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		if line != "" && !strings.HasPrefix(line, key+"=") {
+			lines = append(lines, line)
+		}
+	}
+	if value != "" {
+		lines = append(lines, key+"="+value)
+	}
+
+	// Write and rename, so the gateway never reads a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(providerEnvPath), ".provider.env-*")
+	if err != nil {
+		return fmt.Errorf("write provider.env: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write provider.env: %w", err)
+	}
+	if _, err := tmp.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write provider.env: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write provider.env: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), providerEnvPath); err != nil {
+		return fmt.Errorf("write provider.env: %w", err)
+	}
+	return nil
+}
+
+func handleAddChannel(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	var req channelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	out, err := addChannel(r, &req)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("add channel failed: %v: %s", err, string(out)))
+		return
+	}
+	log.Printf("channel %s/%s added by %s", req.Channel, req.Account, keyLabelFromContext(r.Context()))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"status": "added", "channel": req.Channel, "account": req.Account})
+}
+
+func handleUpdateChannel(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	var req channelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	req.Channel, req.Account = r.PathValue("channel"), r.PathValue("account")
+	if msg := req.validate(); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if req.Token == "" && req.Name == "" {
+		writeError(w, http.StatusBadRequest, "token or name is required")
+		return
+	}
+
+	out, err := addChannel(r, &req)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("update channel failed: %v: %s", err, string(out)))
+		return
+	}
+	log.Printf("channel %s/%s updated by %s (token rotated: %t)", req.Channel, req.Account, keyLabelFromContext(r.Context()), req.Token != "")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "updated", "channel": req.Channel, "account": req.Account})
+}
+
+func handleDisableChannel(w http.ResponseWriter, r *http.Request) {
+	removeChannel(w, r, false)
+}
+
+func handleRemoveChannel(w http.ResponseWriter, r *http.Request) {
+	removeChannel(w, r, true)
+}
+
+// removeChannel runs channels remove, which disables the account, or with
+// --delete also drops its config.
+func removeChannel(w http.ResponseWriter, r *http.Request, deleteConfig bool) {
+	req := channelRequest{Channel: r.PathValue("channel"), Account: r.PathValue("account")}
+	if msg := req.validate(); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	args := []string{"channels", "remove", "--channel=" + req.Channel, "--account=" + req.Account}
+	verb, status := "disable", "disabled"
+	if deleteConfig {
+		args = append(args, "--delete")
+		verb, status = "remove", "removed"
+	}
+	out, err := runCLI(r.Context(), args...)
+	pairing.invalidate()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("%s channel failed: %v: %s", verb, err, string(out)))
+		return
+	}
+	// A removed default account must not come back from provider.env
+	if key := channelTokenEnv[req.Channel]; deleteConfig && key != "" && req.Account == "default" {
+		if err := setProviderEnv(key, ""); err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("remove channel token: %v", err))
+			return
+		}
+	}
+	log.Printf("channel %s/%s %s by %s", req.Channel, req.Account, status, keyLabelFromContext(r.Context()))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": status, "channel": req.Channel, "account": req.Account})
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"os/exec"
 	"strings"
@@ -29,7 +28,7 @@ var cmdEnv = []string{
 
 func handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "signature_method": signatureMethod})
 }
 
 func handleChannelsStatus(w http.ResponseWriter, r *http.Request) {
@@ -42,70 +41,17 @@ func handleChannelsStatus(w http.ResponseWriter, r *http.Request) {
 	w.Write(out)
 }
 
+// handlePairingRequests lists pending pairing requests across all channels.
+// Results are cached briefly (see pairing.go); a channel whose listing
+// failed is reported in "errors" rather than dropped silently.
 func handlePairingRequests(w http.ResponseWriter, r *http.Request) {
-	channelsOut, err := runCLI(r.Context(), "channels", "status", "--json")
+	result, err := pairing.get(r.Context())
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("channels status failed: %v", err))
-		return
-	}
-
-	var channelsStatus struct {
-		ChannelOrder []string `json:"channelOrder"`
-	}
-	if err := json.Unmarshal(channelsOut, &channelsStatus); err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to parse channels: %v", err))
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-
-	type pairingRequest struct {
-		ID        string          `json:"id"`
-		Channel   string          `json:"channel"`
-		Code      string          `json:"code,omitempty"`
-		CreatedAt string          `json:"created_at"`
-		LastSeenAt string         `json:"last_seen_at,omitempty"`
-		Meta      json.RawMessage `json:"meta,omitempty"`
-	}
-
-	var allRequests []pairingRequest
-
-	for _, chName := range channelsStatus.ChannelOrder {
-		out, err := runCLI(r.Context(), "pairing", "list", chName, "--json")
-		if err != nil {
-			log.Printf("pairing list for %s failed: %v", chName, err)
-			continue
-		}
-		var pairingResp struct {
-			Channel  string `json:"channel"`
-			Requests []struct {
-				ID         string          `json:"id"`
-				Code       string          `json:"code"`
-				CreatedAt  string          `json:"createdAt"`
-				LastSeenAt string          `json:"lastSeenAt"`
-				Meta       json.RawMessage `json:"meta"`
-			} `json:"requests"`
-		}
-		if err := json.Unmarshal(out, &pairingResp); err != nil {
-			log.Printf("failed to parse pairing list for %s: %v", chName, err)
-			continue
-		}
-		for _, req := range pairingResp.Requests {
-			allRequests = append(allRequests, pairingRequest{
-				ID:         req.ID,
-				Channel:    chName,
-				Code:       req.Code,
-				CreatedAt:  req.CreatedAt,
-				LastSeenAt: req.LastSeenAt,
-				Meta:       req.Meta,
-			})
-		}
-	}
-
-	if allRequests == nil {
-		allRequests = []pairingRequest{}
-	}
-
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(allRequests)
+	json.NewEncoder(w).Encode(result)
 }
 
 func handlePairingApprove(w http.ResponseWriter, r *http.Request) {
@@ -120,6 +66,7 @@ func handlePairingApprove(w http.ResponseWriter, r *http.Request) {
 	}
 
 	out, err := runCLI(r.Context(), "pairing", "approve", req.Channel, req.Code)
+	pairing.invalidate()
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, fmt.Sprintf("approve failed: %v: %s", err, string(out)))
 		return
@@ -141,6 +88,7 @@ func handlePairingDeny(w http.ResponseWriter, r *http.Request) {
 	}
 
 	out, err := runCLI(r.Context(), "pairing", "deny", req.Channel, req.Code)
+	pairing.invalidate()
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, fmt.Sprintf("deny failed: %v: %s", err, string(out)))
 		return
@@ -151,6 +99,12 @@ func handlePairingDeny(w http.ResponseWriter, r *http.Request) {
 }
 
 func runCLI(ctx context.Context, args ...string) ([]byte, error) {
+	return runCLIEnv(ctx, nil, args...)
+}
+
+// runCLIEnv is runCLI with extra environment variables, for secrets that must
+// not appear in the process's argv (readable by anyone via /proc/*/cmdline).
+func runCLIEnv(ctx context.Context, env []string, args ...string) ([]byte, error) {
 	// Sanitize args
 	for _, arg := range args {
 		if strings.ContainsAny(arg, ";|&`$(){}[]\\'\"\n\r") {
@@ -170,7 +124,7 @@ func runCLI(ctx context.Context, args ...string) ([]byte, error) {
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, openclawBin, args...)
-	cmd.Env = cmdEnv
+	cmd.Env = append(append([]string{}, cmdEnv...), env...)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return out, err
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Live tail of the openclaw units' journal, streamed as plain text lines
+// until the client goes away. Query parameters:
+//
+//	service  daemon, gateway or all (default all)
+//	since    a duration such as 15m, or an RFC 3339 time
+//	lines    backlog lines to start with, 0-1000 (default 100)
+//	grep     RE2 regexp; only matching lines are sent. Applied after lines,
+//	         so the backlog may be shorter than asked for.
+//	follow   0 to return the backlog and stop
+
+const (
+	maxTailLines      = 1000
+	maxGrepLength     = 200
+	maxConcurrentTail = 4
+)
+
+var tailSem = make(chan struct{}, maxConcurrentTail)
+
+func handleJournalTail(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	args := []string{"--user", "--no-pager", "--output=short-iso"}
+
+	switch service := q.Get("service"); service {
+	case "", "all":
+		args = append(args, "--unit="+managedServices["daemon"], "--unit="+managedServices["gateway"])
+	default:
+		unit, ok := managedServices[service]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "service must be daemon, gateway or all")
+			return
+		}
+		args = append(args, "--unit="+unit)
+	}
+
+	lines := 100
+	if v := q.Get("lines"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxTailLines {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("lines must be between 0 and %d", maxTailLines))
+			return
+		}
+		lines = n
+	}
+	args = append(args, "--lines="+strconv.Itoa(lines))
+
+	if v := q.Get("since"); v != "" {
+		since, err := journalSince(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		args = append(args, "--since="+since)
+	}
+
+	var grep *regexp.Regexp
+	if v := q.Get("grep"); v != "" {
+		if len(v) > maxGrepLength {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("grep must be at most %d characters", maxGrepLength))
+			return
+		}
+		re, err := regexp.Compile(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid grep: %v", err))
+			return
+		}
+		grep = re
+	}
+
+	follow := q.Get("follow") != "0"
+	if follow {
+		args = append(args, "--follow")
+	}
+
+	select {
+	case tailSem <- struct{}{}:
+	default:
+		writeError(w, http.StatusTooManyRequests, "too many log tails in progress")
+		return
+	}
+	defer func() { <-tailSem }()
+
+	// journalctl is killed when the client disconnects
+	cmd := exec.CommandContext(r.Context(), "journalctl", args...)
+	cmd.Env = userSessionEnv()
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("journalctl failed: %v", err))
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("journalctl failed: %v", err))
+		return
+	}
+	defer cmd.Wait()
+	log.Printf("journal tail started by %s: %v", keyLabelFromContext(r.Context()), args)
+
+	// A follow stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	sc := bufio.NewScanner(stdout)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		line := sc.Bytes()
+		if grep != nil && !grep.Match(line) {
+			continue
+		}
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			return
+		}
+		rc.Flush()
+	}
+}
+
+// journalSince converts a duration or RFC 3339 time to a --since value.
+func journalSince(v string) (string, error) {
+	if d, err := time.ParseDuration(v); err == nil && d > 0 {
+		return fmt.Sprintf("-%ds", int(d.Seconds())), nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC().Format("2006-01-02 15:04:05") + " UTC", nil
+	}
+	return "", fmt.Errorf("since must be a duration like 15m or an RFC 3339 time")
+}
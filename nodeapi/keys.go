@@ -0,0 +1,379 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Authorized keys live in a directory, one PEM public key per file named
+// <label>.pem, like an authorized_keys file split per device. The directory
+// is reloaded on SIGHUP and whenever its contents change, and keys can be
+// added or revoked by a request signed with any currently authorized key.
+
+const (
+	maxAuthorizedKeys = 32
+	keysPollInterval  = 5 * time.Second
+)
+
+var keyLabelRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+
+type authorizedKey struct {
+	Label       string `json:"label"`
+	Fingerprint string `json:"fingerprint"` // SHA256:<base64 of SPKI digest>, like ssh-keygen -l
+	AddedAt     string `json:"added_at"`
+	pub         *ecdsa.PublicKey
+}
+
+type keyring struct {
+	dir string
+
+	mu    sync.RWMutex
+	keys  []*authorizedKey
+	stamp string // directory listing the keys were loaded from
+}
+
+func newKeyring(dir string) (*keyring, error) {
+	kr := &keyring{dir: dir}
+	if err := kr.reload(); err != nil {
+		return nil, err
+	}
+	return kr, nil
+}
+
+// list returns the loaded keys, sorted by label.
+func (kr *keyring) list() []*authorizedKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.keys
+}
+
+// dirStamp summarizes the directory's key files so changes can be detected
+// without a filesystem notification API.
+func (kr *keyring) dirStamp() (string, error) {
+	entries, err := os.ReadDir(kr.dir)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".pem") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%s:%d:%d\n", e.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
+
+// reload reads every <label>.pem in the directory. Unparseable files are
+// logged and skipped, so one bad file can't lock everyone out.
+func (kr *keyring) reload() error {
+	stamp, err := kr.dirStamp()
+	if err != nil {
+		return fmt.Errorf("read keys dir: %w", err)
+	}
+	entries, err := os.ReadDir(kr.dir)
+	if err != nil {
+		return fmt.Errorf("read keys dir: %w", err)
+	}
+
+	var keys []*authorizedKey
+	for _, e := range entries {
+		label, ok := strings.CutSuffix(e.Name(), ".pem")
+		if !ok || e.IsDir() {
+			continue
+		}
+		if !keyLabelRegex.MatchString(label) {
+			log.Printf("skipping key file %s: invalid label", e.Name())
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(kr.dir, e.Name()))
+		if err != nil {
+			log.Printf("skipping key file %s: %v", e.Name(), err)
+			continue
+		}
+		pub, err := parsePublicKey(data)
+		if err != nil {
+			log.Printf("skipping key file %s: %v", e.Name(), err)
+			continue
+		}
+		var added time.Time
+		if info, err := e.Info(); err == nil {
+			added = info.ModTime()
+		}
+		keys = append(keys, &authorizedKey{
+			Label:       label,
+			Fingerprint: keyFingerprint(pub),
+			AddedAt:     added.UTC().Format(time.RFC3339),
+			pub:         pub,
+		})
+		if len(keys) == maxAuthorizedKeys {
+			log.Printf("key limit of %d reached, ignoring the rest", maxAuthorizedKeys)
+			break
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Label < keys[j].Label })
+
+	kr.mu.Lock()
+	kr.keys = keys
+	kr.stamp = stamp
+	kr.mu.Unlock()
+
+	if len(keys) == 0 {
+		log.Printf("warning: no authorized keys in %s, signed endpoints are unusable", kr.dir)
+	}
+	return nil
+}
+
+// watch reloads on SIGHUP and when the directory changes.
+func (kr *keyring) watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(keysPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hup:
+			log.Printf("SIGHUP: reloading authorized keys")
+		case <-ticker.C:
+			stamp, err := kr.dirStamp()
+			kr.mu.RLock()
+			unchanged := err != nil || stamp == kr.stamp
+			kr.mu.RUnlock()
+			if unchanged {
+				continue
+			}
+			log.Printf("authorized keys changed, reloading")
+		}
+		if err := kr.reload(); err != nil {
+			log.Printf("reload authorized keys: %v", err)
+			continue
+		}
+		log.Printf("loaded %d authorized keys", len(kr.list()))
+	}
+}
+
+// add writes a new key file atomically and reloads.
+func (kr *keyring) add(label string, pemData []byte) (*authorizedKey, error) {
+	path := filepath.Join(kr.dir, label+".pem")
+	if _, err := os.Stat(path); err == nil {
+		return nil, errKeyExists
+	}
+	if len(kr.list()) >= maxAuthorizedKeys {
+		return nil, errTooManyKeys
+	}
+
+	tmp, err := os.CreateTemp(kr.dir, ".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(pemData); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return nil, err
+	}
+	// Link rather than rename so a concurrent add of the same label fails
+	if err := os.Link(tmp.Name(), path); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil, errKeyExists
+		}
+		return nil, err
+	}
+
+	if err := kr.reload(); err != nil {
+		return nil, err
+	}
+	for _, k := range kr.list() {
+		if k.Label == label {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("key %s did not load", label)
+}
+
+// revoke removes a key file and reloads. The last key can't be revoked,
+// since nobody could then sign a request to add another.
+func (kr *keyring) revoke(label string) error {
+	keys := kr.list()
+	found := false
+	for _, k := range keys {
+		found = found || k.Label == label
+	}
+	if !found {
+		return errKeyNotFound
+	}
+	if len(keys) == 1 {
+		return errLastKey
+	}
+	if err := os.Remove(filepath.Join(kr.dir, label+".pem")); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return errKeyNotFound
+		}
+		return err
+	}
+	return kr.reload()
+}
+
+var (
+	errKeyExists   = errors.New("a key with this label already exists")
+	errKeyNotFound = errors.New("key not found")
+	errLastKey     = errors.New("cannot revoke the last authorized key")
+	errTooManyKeys = fmt.Errorf("at most %d keys may be authorized", maxAuthorizedKeys)
+)
+
+// migrateLegacyKey seeds an empty keys directory from the single-key file
+// older deployments used, so upgrading a node doesn't lock anyone out.
+func migrateLegacyKey(dir, legacyPath string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".pem") {
+			return nil
+		}
+	}
+	data, err := os.ReadFile(legacyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := parsePublicKey(data); err != nil {
+		return fmt.Errorf("%s: %w", legacyPath, err)
+	}
+	log.Printf("migrating %s to %s/creator.pem", legacyPath, dir)
+	return os.WriteFile(filepath.Join(dir, "creator.pem"), data, 0644)
+}
+
+func parsePublicKey(data []byte) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse public key: %w", err)
+	}
+
+	ecdsaPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok || ecdsaPub.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("not an ECDSA P-256 public key")
+	}
+
+	return ecdsaPub, nil
+}
+
+func keyFingerprint(pub *ecdsa.PublicKey) string {
+	der, _ := x509.MarshalPKIXPublicKey(pub)
+	sum := sha256.Sum256(der)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// Handlers
+
+func handleListKeys(kr *keyring) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keys := kr.list()
+		if keys == nil {
+			keys = []*authorizedKey{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(keys)
+	}
+}
+
+func handleAddKey(kr *keyring) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+		var req struct {
+			Label        string `json:"label"`
+			PublicKeyPEM string `json:"public_key_pem"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		if !keyLabelRegex.MatchString(req.Label) {
+			writeError(w, http.StatusBadRequest, "label must be 1-64 chars of a-z, 0-9, '.', '_' or '-'")
+			return
+		}
+		if _, err := parsePublicKey([]byte(req.PublicKeyPEM)); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		key, err := kr.add(req.Label, []byte(req.PublicKeyPEM))
+		switch {
+		case errors.Is(err, errKeyExists):
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		case errors.Is(err, errTooManyKeys):
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("add key failed: %v", err))
+			return
+		}
+		log.Printf("authorized key %s added by %s", key.Label, keyLabelFromContext(r.Context()))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(key)
+	}
+}
+
+func handleRevokeKey(kr *keyring) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		label := r.PathValue("label")
+		err := kr.revoke(label)
+		switch {
+		case errors.Is(err, errKeyNotFound):
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		case errors.Is(err, errLastKey):
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("revoke key failed: %v", err))
+			return
+		}
+		log.Printf("authorized key %s revoked by %s", label, keyLabelFromContext(r.Context()))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "revoked"})
+	}
+}
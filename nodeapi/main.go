@@ -1,34 +1,78 @@
 package main
 
 import (
-	"crypto/ecdsa"
-	"crypto/x509"
-	"encoding/pem"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"time"
 )
 
 func main() {
-	listen := flag.String("listen", ":8443", "listen address")
-	pubkeyPath := flag.String("pubkey", "/home/clawdbot/.clawdbot/creator-public-key.pem", "path to creator ECDSA public key PEM")
+	listen := flag.String("listen", ":8443", "listen address; empty to serve only through the agent tunnel")
+	agentURL := flag.String("agent-url", "", "creator agent endpoint (wss://.../agent/connect) to dial out to")
+	keysDir := flag.String("keys-dir", "/home/clawdbot/.clawdbot/authorized_keys", "directory of authorized ECDSA public keys, one <label>.pem per key")
+	pubkeyPath := flag.String("pubkey", "/home/clawdbot/.clawdbot/creator-public-key.pem", "legacy single key PEM, imported into -keys-dir when that is empty")
+	certPath := flag.String("tls-cert", "/home/clawdbot/.clawdbot/node-api-tls/cert.pem", "TLS certificate PEM, self-signed on first start if missing")
+	keyPath := flag.String("tls-key", "/home/clawdbot/.clawdbot/node-api-tls/key.pem", "TLS private key PEM")
+	printFingerprint := flag.Bool("print-fingerprint", false, "print the TLS certificate's SPKI fingerprint (creating it if needed) and exit")
 	flag.Parse()
 
-	pubKey, err := loadPublicKey(*pubkeyPath)
+	cert, err := loadOrCreateCert(*certPath, *keyPath)
 	if err != nil {
-		log.Fatalf("failed to load public key from %s: %v", *pubkeyPath, err)
+		log.Fatalf("failed to load TLS certificate: %v", err)
 	}
-	log.Printf("loaded creator public key from %s", *pubkeyPath)
+	fingerprint, err := spkiFingerprint(cert)
+	if err != nil {
+		log.Fatalf("failed to fingerprint TLS certificate: %v", err)
+	}
+	if *printFingerprint {
+		fmt.Println(fingerprint)
+		return
+	}
+	log.Printf("TLS certificate SPKI fingerprint %s", fingerprint)
+
+	if err := migrateLegacyKey(*keysDir, *pubkeyPath); err != nil {
+		log.Fatalf("failed to import legacy key: %v", err)
+	}
+	keys, err := newKeyring(*keysDir)
+	if err != nil {
+		log.Fatalf("failed to load authorized keys from %s: %v", *keysDir, err)
+	}
+	log.Printf("loaded %d authorized keys from %s", len(keys.list()), *keysDir)
+	go keys.watch()
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /health", handleHealth)
-	mux.HandleFunc("GET /pairing/requests", requireAuth(pubKey, handlePairingRequests))
-	mux.HandleFunc("POST /pairing/approve", requireAuth(pubKey, handlePairingApprove))
-	mux.HandleFunc("POST /pairing/deny", requireAuth(pubKey, handlePairingDeny))
-	mux.HandleFunc("GET /channels/status", requireAuth(pubKey, handleChannelsStatus))
+	mux.HandleFunc("GET /pairing/requests", requireAuth(keys, handlePairingRequests))
+	mux.HandleFunc("POST /pairing/approve", requireAuth(keys, handlePairingApprove))
+	mux.HandleFunc("POST /pairing/deny", requireAuth(keys, handlePairingDeny))
+	mux.HandleFunc("GET /channels/status", requireAuth(keys, handleChannelsStatus))
+	mux.HandleFunc("POST /channels", requireAuth(keys, handleAddChannel))
+	mux.HandleFunc("PUT /channels/{channel}/{account}", requireAuth(keys, handleUpdateChannel))
+	mux.HandleFunc("POST /channels/{channel}/{account}/disable", requireAuth(keys, handleDisableChannel))
+	mux.HandleFunc("DELETE /channels/{channel}/{account}", requireAuth(keys, handleRemoveChannel))
+	mux.HandleFunc("GET /system/metrics", requireAuth(keys, handleSystemMetrics))
+	mux.HandleFunc("GET /services", requireAuth(keys, handleListServices))
+	mux.HandleFunc("GET /services/{name}", requireAuth(keys, handleServiceStatus))
+	mux.HandleFunc("POST /services/{name}/{action}", requireAuth(keys, handleServiceAction))
+	mux.HandleFunc("GET /logs/tail", requireAuth(keys, handleJournalTail))
+	mux.HandleFunc("GET /keys", requireAuth(keys, handleListKeys(keys)))
+	mux.HandleFunc("POST /keys", requireAuth(keys, handleAddKey(keys)))
+	mux.HandleFunc("DELETE /keys/{label}", requireAuth(keys, handleRevokeKey(keys)))
+
+	// Tunnelled requests skip the per-IP limiter: they all come from the
+	// creator, which rate limits its own users.
+	if *agentURL != "" {
+		go runAgent(*agentURL, cert, mux)
+	}
+	if *listen == "" {
+		if *agentURL == "" {
+			log.Fatalf("nothing to do: -listen and -agent-url are both empty")
+		}
+		select {}
+	}
 
 	// 10 requests/sec per IP, burst of 20
 	limiter := newIPLimiter(10, 20)
@@ -42,34 +86,14 @@ func main() {
 		WriteTimeout:      30 * time.Second,
 		IdleTimeout:       60 * time.Second,
 		MaxHeaderBytes:    1 << 14, // 16 KB
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		},
 	}
 
 	log.Printf("starting node API on %s", *listen)
-	if err := srv.ListenAndServe(); err != nil {
+	if err := srv.ListenAndServeTLS("", ""); err != nil {
 		log.Fatalf("server error: %v", err)
 	}
 }
-
-func loadPublicKey(path string) (*ecdsa.PublicKey, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("read file: %w", err)
-	}
-
-	block, _ := pem.Decode(data)
-	if block == nil {
-		return nil, fmt.Errorf("no PEM block found")
-	}
-
-	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
-	if err != nil {
-		return nil, fmt.Errorf("parse public key: %w", err)
-	}
-
-	ecdsaPub, ok := pub.(*ecdsa.PublicKey)
-	if !ok {
-		return nil, fmt.Errorf("not an ECDSA public key")
-	}
-
-	return ecdsaPub, nil
-}
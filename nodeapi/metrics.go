@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// System metrics, read straight from /proc and statfs so the creator can
+// tell whether a small VM is running out of memory or disk.
+
+const (
+	metricsDiskPath = "/home/clawdbot"
+	cpuSampleWindow = 250 * time.Millisecond
+)
+
+type systemMetrics struct {
+	CollectedAt   string  `json:"collected_at"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	CPUCount      int     `json:"cpu_count"`
+	CPUPercent    float64 `json:"cpu_percent"`
+	Load1         float64 `json:"load_1"`
+	Load5         float64 `json:"load_5"`
+	Load15        float64 `json:"load_15"`
+	MemTotal      uint64  `json:"mem_total_bytes"`
+	MemAvailable  uint64  `json:"mem_available_bytes"`
+	SwapTotal     uint64  `json:"swap_total_bytes"`
+	SwapFree      uint64  `json:"swap_free_bytes"`
+	DiskPath      string  `json:"disk_path"`
+	DiskTotal     uint64  `json:"disk_total_bytes"`
+	DiskFree      uint64  `json:"disk_free_bytes"`
+	Processes     int     `json:"processes"`
+	DaemonProcs   int     `json:"daemon_processes"`
+	GatewayProcs  int     `json:"gateway_processes"`
+}
+
+func handleSystemMetrics(w http.ResponseWriter, r *http.Request) {
+	m, err := collectMetrics(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("collect metrics failed: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
+
+func collectMetrics(ctx context.Context) (*systemMetrics, error) {
+	m := &systemMetrics{
+		CollectedAt: time.Now().UTC().Format(time.RFC3339),
+		CPUCount:    runtime.NumCPU(),
+		DiskPath:    metricsDiskPath,
+	}
+
+	cpu, err := cpuPercent(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cpu: %w", err)
+	}
+	m.CPUPercent = cpu
+
+	loadavg, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Sscanf(string(loadavg), "%f %f %f", &m.Load1, &m.Load5, &m.Load15); err != nil {
+		return nil, fmt.Errorf("parse /proc/loadavg: %w", err)
+	}
+
+	uptime, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Sscanf(string(uptime), "%f", &m.UptimeSeconds); err != nil {
+		return nil, fmt.Errorf("parse /proc/uptime: %w", err)
+	}
+
+	mem, err := readMeminfo()
+	if err != nil {
+		return nil, err
+	}
+	m.MemTotal = mem["MemTotal"]
+	m.MemAvailable = mem["MemAvailable"]
+	m.SwapTotal = mem["SwapTotal"]
+	m.SwapFree = mem["SwapFree"]
+
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(metricsDiskPath, &fs); err != nil {
+		return nil, fmt.Errorf("statfs %s: %w", metricsDiskPath, err)
+	}
+	m.DiskTotal = fs.Blocks * uint64(fs.Bsize)
+	m.DiskFree = fs.Bavail * uint64(fs.Bsize)
+
+	m.Processes, m.DaemonProcs, m.GatewayProcs = countProcesses()
+	return m, nil
+}
+
+// readMeminfo returns /proc/meminfo in bytes.
+func readMeminfo() (map[string]uint64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]uint64)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// "MemTotal:        2014856 kB"
+		key, rest, ok := strings.Cut(sc.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		n, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(fields) > 1 && fields[1] == "kB" {
+			n *= 1024
+		}
+		values[key] = n
+	}
+	return values, sc.Err()
+}
+
+// cpuPercent measures overall CPU utilization over a short window.
+func cpuPercent(ctx context.Context) (float64, error) {
+	idle1, total1, err := readCPUTimes()
+	if err != nil {
+		return 0, err
+	}
+	select {
+	case <-time.After(cpuSampleWindow):
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	idle2, total2, err := readCPUTimes()
+	if err != nil {
+		return 0, err
+	}
+	if total2 <= total1 {
+		return 0, nil
+	}
+	busy := float64((total2-total1)-(idle2-idle1)) / float64(total2-total1)
+	return busy * 100, nil
+}
+
+// readCPUTimes sums the aggregate "cpu" line of /proc/stat. Idle includes
+// iowait.
+func readCPUTimes() (idle, total uint64, err error) {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	fields := strings.Fields(line)
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0, fmt.Errorf("unexpected /proc/stat format")
+	}
+	for i, f := range fields[1:] {
+		n, err := strconv.ParseUint(f, 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("parse /proc/stat: %w", err)
+		}
+		total += n
+		if i == 3 || i == 4 { // idle, iowait
+			idle += n
+		}
+	}
+	return idle, total, nil
+}
+
+// countProcesses counts all processes and the openclaw daemon and gateway
+// processes, recognized by their command lines.
+func countProcesses() (total, daemon, gateway int) {
+	dirs, _ := filepath.Glob("/proc/[0-9]*")
+	for _, dir := range dirs {
+		cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline"))
+		if err != nil {
+			continue // exited while we were scanning
+		}
+		total++
+		args := bytes.Split(bytes.TrimRight(cmdline, "\x00"), []byte{0})
+		if !bytes.Contains(cmdline, []byte("openclaw")) || bytes.Contains(cmdline, []byte("openclaw-node-api")) {
+			continue
+		}
+		for _, arg := range args {
+			switch filepath.Base(string(arg)) {
+			case "daemon", "openclaw-daemon":
+				daemon++
+			case "gateway", "openclaw-gateway":
+				gateway++
+			default:
+				continue
+			}
+			break
+		}
+	}
+	return total, daemon, gateway
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Pending pairing requests are gathered with one openclaw CLI call per
+// channel, which is slow, while dashboards poll every server every 30s.
+// pairingCache runs the per-channel calls concurrently, shares one fetch
+// between concurrent callers and keeps the result for pairingCacheTTL.
+// Approving, denying or changing channels invalidates it.
+
+const (
+	pairingCacheTTL     = 10 * time.Second
+	pairingFetchTimeout = 45 * time.Second
+)
+
+var pairing = &pairingCache{}
+
+type pairingRequest struct {
+	ID         string          `json:"id"`
+	Channel    string          `json:"channel"`
+	Code       string          `json:"code,omitempty"`
+	CreatedAt  string          `json:"created_at"`
+	LastSeenAt string          `json:"last_seen_at,omitempty"`
+	Meta       json.RawMessage `json:"meta,omitempty"`
+}
+
+type pairingChannelError struct {
+	Channel string `json:"channel"`
+	Error   string `json:"error"`
+}
+
+type pairingResult struct {
+	Requests  []pairingRequest      `json:"requests"`
+	Errors    []pairingChannelError `json:"errors"`
+	FetchedAt string                `json:"fetched_at"`
+}
+
+type pairingCache struct {
+	mu      sync.Mutex
+	result  *pairingResult
+	expires time.Time
+	gen     uint64        // bumped by invalidate; stale fetches don't store
+	fetch   *pairingFetch // in progress, if any
+}
+
+type pairingFetch struct {
+	done   chan struct{}
+	result *pairingResult
+	err    error
+}
+
+// get returns the cached result, or waits for a fresh one.
+func (c *pairingCache) get(ctx context.Context) (*pairingResult, error) {
+	c.mu.Lock()
+	if c.result != nil && time.Now().Before(c.expires) {
+		result := c.result
+		c.mu.Unlock()
+		return result, nil
+	}
+	f := c.fetch
+	if f == nil {
+		f = &pairingFetch{done: make(chan struct{})}
+		c.fetch = f
+		go c.run(f, c.gen)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.result, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// run fetches on behalf of every waiter, so it doesn't use any one
+// request's context.
+func (c *pairingCache) run(f *pairingFetch, gen uint64) {
+	ctx, cancel := context.WithTimeout(context.Background(), pairingFetchTimeout)
+	defer cancel()
+	f.result, f.err = fetchPairingRequests(ctx)
+
+	c.mu.Lock()
+	if c.fetch == f {
+		c.fetch = nil
+	}
+	if f.err == nil && c.gen == gen {
+		c.result = f.result
+		c.expires = time.Now().Add(pairingCacheTTL)
+	}
+	c.mu.Unlock()
+	close(f.done)
+}
+
+func (c *pairingCache) invalidate() {
+	c.mu.Lock()
+	c.result = nil
+	c.gen++
+	c.fetch = nil // a fetch that started before the change must not be shared
+	c.mu.Unlock()
+}
+
+func fetchPairingRequests(ctx context.Context) (*pairingResult, error) {
+	channelsOut, err := runCLI(ctx, "channels", "status", "--json")
+	if err != nil {
+		return nil, fmt.Errorf("channels status failed: %v", err)
+	}
+	var channelsStatus struct {
+		ChannelOrder []string `json:"channelOrder"`
+	}
+	if err := json.Unmarshal(channelsOut, &channelsStatus); err != nil {
+		return nil, fmt.Errorf("failed to parse channels: %v", err)
+	}
+
+	result := &pairingResult{
+		Requests:  []pairingRequest{},
+		Errors:    []pairingChannelError{},
+		FetchedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, chName := range channelsStatus.ChannelOrder {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reqs, err := listChannelPairing(ctx, chName)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result.Errors = append(result.Errors, pairingChannelError{Channel: chName, Error: err.Error()})
+				return
+			}
+			result.Requests = append(result.Requests, reqs...)
+		}()
+	}
+	wg.Wait()
+
+	// Keep the channel order stable across fetches
+	order := make(map[string]int, len(channelsStatus.ChannelOrder))
+	for i, ch := range channelsStatus.ChannelOrder {
+		order[ch] = i
+	}
+	sort.SliceStable(result.Requests, func(i, j int) bool {
+		return order[result.Requests[i].Channel] < order[result.Requests[j].Channel]
+	})
+	sort.Slice(result.Errors, func(i, j int) bool {
+		return order[result.Errors[i].Channel] < order[result.Errors[j].Channel]
+	})
+	return result, nil
+}
+
+func listChannelPairing(ctx context.Context, chName string) ([]pairingRequest, error) {
+	out, err := runCLI(ctx, "pairing", "list", chName, "--json")
+	if err != nil {
+		return nil, fmt.Errorf("pairing list failed: %v", err)
+	}
+	var pairingResp struct {
+		Channel  string `json:"channel"`
+		Requests []struct {
+			ID         string          `json:"id"`
+			Code       string          `json:"code"`
+			CreatedAt  string          `json:"createdAt"`
+			LastSeenAt string          `json:"lastSeenAt"`
+			Meta       json.RawMessage `json:"meta"`
+		} `json:"requests"`
+	}
+	if err := json.Unmarshal(out, &pairingResp); err != nil {
+		return nil, fmt.Errorf("failed to parse pairing list: %v", err)
+	}
+
+	reqs := make([]pairingRequest, 0, len(pairingResp.Requests))
+	for _, req := range pairingResp.Requests {
+		reqs = append(reqs, pairingRequest{
+			ID:         req.ID,
+			Channel:    chName,
+			Code:       req.Code,
+			CreatedAt:  req.CreatedAt,
+			LastSeenAt: req.LastSeenAt,
+			Meta:       req.Meta,
+		})
+	}
+	return reqs, nil
+}
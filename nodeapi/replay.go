@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// maxNonces bounds the replay cache. Nonces only need remembering while
+// their timestamp is acceptable, so at 10 req/s per IP this is ample; when
+// full, new requests are refused rather than evicting live nonces.
+const maxNonces = 100000
+
+// nonceCache remembers request nonces until their signatures expire.
+type nonceCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time // nonce -> when it may be forgotten
+	max  int
+}
+
+func newNonceCache(max int) *nonceCache {
+	c := &nonceCache{
+		seen: make(map[string]time.Time),
+		max:  max,
+	}
+	go c.cleanup()
+	return c
+}
+
+var (
+	errNonceReused     = errors.New("nonce already used")
+	errReplayCacheFull = errors.New("replay cache full")
+)
+
+// use records nonce until expires, failing if it has been seen before.
+func (c *nonceCache) use(nonce string, expires time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.seen[nonce]; ok {
+		return errNonceReused
+	}
+	if len(c.seen) >= c.max {
+		c.evictExpired(time.Now())
+		if len(c.seen) >= c.max {
+			return errReplayCacheFull
+		}
+	}
+	c.seen[nonce] = expires
+	return nil
+}
+
+func (c *nonceCache) evictExpired(now time.Time) {
+	for nonce, expires := range c.seen {
+		if now.After(expires) {
+			delete(c.seen, nonce)
+		}
+	}
+}
+
+// cleanup evicts expired nonces every 60 seconds.
+func (c *nonceCache) cleanup() {
+	for {
+		time.Sleep(60 * time.Second)
+		c.mu.Lock()
+		c.evictExpired(time.Now())
+		c.mu.Unlock()
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Service control for the openclaw user units, so a wedged bot can be
+// restarted without SSH. The node API runs as the clawdbot user, which owns
+// these units (lingering is enabled), so plain systemctl --user suffices.
+
+// Under the server write timeout and the creator's 30s request timeout.
+const serviceTimeout = 25 * time.Second
+
+var managedServices = map[string]string{
+	"daemon":  "openclaw-daemon.service",
+	"gateway": "openclaw-gateway.service",
+}
+
+var serviceActions = map[string]bool{"start": true, "stop": true, "restart": true}
+
+// serviceLocks serializes actions per unit, so two restarts don't overlap.
+var serviceLocks = map[string]*sync.Mutex{
+	"daemon":  {},
+	"gateway": {},
+}
+
+type serviceStatus struct {
+	Name        string `json:"name"`
+	Unit        string `json:"unit"`
+	LoadState   string `json:"load_state"`
+	ActiveState string `json:"active_state"`
+	SubState    string `json:"sub_state"`
+	MainPID     string `json:"main_pid,omitempty"`
+	Since       string `json:"since,omitempty"`
+	Restarts    string `json:"restarts,omitempty"`
+	Enabled     string `json:"enabled,omitempty"`
+}
+
+func systemctlUser(ctx context.Context, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, serviceTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "systemctl", append([]string{"--user"}, args...)...)
+	cmd.Env = userSessionEnv()
+	return cmd.CombinedOutput()
+}
+
+// userSessionEnv is cmdEnv plus what systemctl --user and journalctl --user
+// need to find our user manager.
+func userSessionEnv() []string {
+	runtimeDir := fmt.Sprintf("/run/user/%d", os.Getuid())
+	env := append([]string{}, cmdEnv...)
+	return append(env,
+		"XDG_RUNTIME_DIR="+runtimeDir,
+		"DBUS_SESSION_BUS_ADDRESS=unix:path="+runtimeDir+"/bus",
+	)
+}
+
+func getServiceStatus(ctx context.Context, name string) (*serviceStatus, error) {
+	unit := managedServices[name]
+	out, err := systemctlUser(ctx, "show", unit,
+		"--property=LoadState,ActiveState,SubState,MainPID,ActiveEnterTimestamp,NRestarts,UnitFileState")
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	props := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		if k, v, ok := strings.Cut(line, "="); ok {
+			props[k] = v
+		}
+	}
+	st := &serviceStatus{
+		Name:        name,
+		Unit:        unit,
+		LoadState:   props["LoadState"],
+		ActiveState: props["ActiveState"],
+		SubState:    props["SubState"],
+		Since:       props["ActiveEnterTimestamp"],
+		Restarts:    props["NRestarts"],
+		Enabled:     props["UnitFileState"],
+	}
+	if pid := props["MainPID"]; pid != "0" {
+		st.MainPID = pid
+	}
+	return st, nil
+}
+
+func handleListServices(w http.ResponseWriter, r *http.Request) {
+	statuses := []*serviceStatus{}
+	for _, name := range []string{"daemon", "gateway"} {
+		st, err := getServiceStatus(r.Context(), name)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("%s status failed: %v", name, err))
+			return
+		}
+		statuses = append(statuses, st)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statuses)
+}
+
+func handleServiceStatus(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if _, ok := managedServices[name]; !ok {
+		writeError(w, http.StatusNotFound, "unknown service")
+		return
+	}
+	st, err := getServiceStatus(r.Context(), name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("status failed: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+// handleServiceAction runs start, stop or restart and returns the resulting
+// status.
+func handleServiceAction(w http.ResponseWriter, r *http.Request) {
+	name, action := r.PathValue("name"), r.PathValue("action")
+	unit, ok := managedServices[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown service")
+		return
+	}
+	if !serviceActions[action] {
+		writeError(w, http.StatusBadRequest, "action must be start, stop or restart")
+		return
+	}
+
+	lock := serviceLocks[name]
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Keep going if the caller disconnects: a half-finished restart is worse
+	// than one nobody watched finish.
+	ctx := context.WithoutCancel(r.Context())
+	signer := keyLabelFromContext(r.Context())
+	log.Printf("service %s: %s requested by %s", unit, action, signer)
+	out, err := systemctlUser(ctx, action, unit)
+	if err != nil {
+		log.Printf("service %s: %s by %s failed: %v: %s", unit, action, signer, err, strings.TrimSpace(string(out)))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("%s failed: %v: %s", action, err, strings.TrimSpace(string(out))))
+		return
+	}
+	log.Printf("service %s: %s by %s succeeded", unit, action, signer)
+
+	st, err := getServiceStatus(ctx, name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("status failed: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
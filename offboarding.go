@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Suspension and offboarding. A suspended user can't sign in, and their
+// sessions and API tokens stop working; their servers keep running unless
+// the admin asks for them to be powered off. Deleting a user requires
+// deciding what happens to their personal servers first. Org servers stay
+// with the org either way.
+
+// Server dispositions when deleting a user.
+const (
+	ServersReassign = "reassign"  // transfer to another user
+	ServersPowerOff = "power_off" // power off and transfer to the deleting admin
+	ServersDestroy  = "destroy"   // delete from Hetzner
+)
+
+// setServersPower powers the given servers off (or on), logging failures.
+// It returns how many succeeded.
+func (s *Server) setServersPower(ctx context.Context, ids []int64, on bool) int {
+	n := 0
+	for _, id := range ids {
+		var err error
+		if on {
+			err = s.hetzner.PowerOn(ctx, id)
+		} else {
+			err = s.hetzner.PowerOff(ctx, id)
+		}
+		if err != nil {
+			slog.Error("failed to change server power state", "server_id", id, "on", on, "error", err)
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+// destroyServer removes a server from the database and Hetzner.
+func (s *Server) destroyServer(ctx context.Context, id int64) error {
+	if err := s.store.DeleteServer(id); err != nil {
+		return err
+	}
+	if err := s.hetzner.DeleteServer(ctx, id); err != nil {
+		slog.Error("failed to delete server from hetzner", "server_id", id, "error", err)
+	}
+	s.hub.Remove(id)
+	return nil
+}
+
+func (s *Server) handleSuspendUser(w http.ResponseWriter, r *http.Request) {
+	admin := userFromContext(r.Context())
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid user id"})
+		return
+	}
+	if id == admin.ID {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "cannot suspend yourself"})
+		return
+	}
+
+	var req struct {
+		PowerOff bool `json:"power_off"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+			return
+		}
+	}
+
+	if err := s.store.SetUserSuspended(id, true); err != nil {
+		if err == sql.ErrNoRows {
+			writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "user not found"})
+			return
+		}
+		slog.Error("failed to suspend user", "user_id", id, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to suspend user"})
+		return
+	}
+	revoked, err := s.store.DeleteUserSessions(id)
+	if err != nil {
+		slog.Error("failed to revoke sessions of suspended user", "user_id", id, "error", err)
+	}
+
+	resp := map[string]any{"status": "suspended", "sessions_revoked": revoked}
+	if req.PowerOff {
+		ids, err := s.store.ListPersonalServerIDs(id)
+		if err != nil {
+			slog.Error("failed to list servers of suspended user", "user_id", id, "error", err)
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "suspended, but failed to power off servers"})
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+		defer cancel()
+		resp["servers_powered_off"] = s.setServersPower(ctx, ids, false)
+	}
+	slog.Info("user suspended", "user_id", id, "by", admin.ID, "power_off", req.PowerOff)
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleUnsuspendUser(w http.ResponseWriter, r *http.Request) {
+	admin := userFromContext(r.Context())
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid user id"})
+		return
+	}
+
+	var req struct {
+		PowerOn bool `json:"power_on"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+			return
+		}
+	}
+
+	if err := s.store.SetUserSuspended(id, false); err != nil {
+		if err == sql.ErrNoRows {
+			writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "user not found"})
+			return
+		}
+		slog.Error("failed to unsuspend user", "user_id", id, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to unsuspend user"})
+		return
+	}
+
+	resp := map[string]any{"status": "active"}
+	if req.PowerOn {
+		ids, err := s.store.ListPersonalServerIDs(id)
+		if err != nil {
+			slog.Error("failed to list servers of user", "user_id", id, "error", err)
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+		defer cancel()
+		resp["servers_powered_on"] = s.setServersPower(ctx, ids, true)
+	}
+	slog.Info("user unsuspended", "user_id", id, "by", admin.ID)
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleDeleteUser removes a user. If they own personal servers, ?servers=
+// must say what to do with them: reassign (to ?reassign_to=<user id>),
+// power_off (and hand them to the calling admin) or destroy. Org servers they
+// created stay running for the org, under another owner (see DeleteUser).
+func (s *Server) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	admin := userFromContext(r.Context())
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid user id"})
+		return
+	}
+
+	// Don't allow deleting yourself
+	if id == admin.ID {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "cannot delete yourself"})
+		return
+	}
+
+	if _, err := s.store.GetUserByID(id); err != nil {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "user not found"})
+		return
+	}
+
+	ids, err := s.store.ListPersonalServerIDs(id)
+	if err != nil {
+		slog.Error("failed to list servers of user", "user_id", id, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to delete user"})
+		return
+	}
+
+	disposition := r.URL.Query().Get("servers")
+	if len(ids) > 0 {
+		switch disposition {
+		case ServersReassign:
+			to, err := strconv.ParseInt(r.URL.Query().Get("reassign_to"), 10, 64)
+			if err != nil || to == id {
+				writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "reassign_to must be another user's id"})
+				return
+			}
+			target, err := s.store.GetUserByID(to)
+			if err != nil || !target.Approved || target.Suspended {
+				writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "reassign_to user not found"})
+				return
+			}
+			for _, sid := range ids {
+				if err := s.store.TransferServer(sid, to, 0); err != nil {
+					slog.Error("failed to reassign server", "server_id", sid, "error", err)
+					writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to reassign servers"})
+					return
+				}
+			}
+		case ServersPowerOff:
+			for _, sid := range ids {
+				if err := s.store.TransferServer(sid, admin.ID, 0); err != nil {
+					slog.Error("failed to reassign server", "server_id", sid, "error", err)
+					writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to reassign servers"})
+					return
+				}
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+			defer cancel()
+			s.setServersPower(ctx, ids, false)
+		case ServersDestroy:
+			for _, sid := range ids {
+				if err := s.destroyServer(r.Context(), sid); err != nil {
+					slog.Error("failed to destroy server", "server_id", sid, "error", err)
+				}
+			}
+		default:
+			writeJSON(w, http.StatusConflict, ErrorResponse{Error: fmt.Sprintf(
+				"user owns %d server(s); set servers to reassign, power_off or destroy", len(ids))})
+			return
+		}
+	}
+
+	if err := s.store.DeleteUser(id, admin.ID); err != nil {
+		slog.Error("failed to delete user", "user_id", id, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to delete user"})
+		return
+	}
+	slog.Info("user deleted", "user_id", id, "by", admin.ID, "servers", len(ids), "disposition", disposition)
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Minimal OpenID Connect relying party: issuer discovery, the authorization
+// code flow with PKCE, and ID token validation against the issuer's JWKS.
+// Only RS256 and ES256 ID tokens are accepted.
+
+const (
+	oidcDiscoveryTTL = time.Hour
+	oidcJWKSTTL      = time.Hour
+	oidcClockSkew    = time.Minute
+)
+
+type oidcDiscovery struct {
+	Issuer                   string   `json:"issuer"`
+	AuthorizationEndpoint    string   `json:"authorization_endpoint"`
+	TokenEndpoint            string   `json:"token_endpoint"`
+	JWKSURI                  string   `json:"jwks_uri"`
+	TokenEndpointAuthMethods []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+type oidcJWKS struct {
+	keys    map[string]crypto.PublicKey // by kid
+	fetched time.Time
+}
+
+// OIDCClient caches discovery documents and signing keys per issuer.
+type OIDCClient struct {
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery map[string]*oidcDiscovery
+	discAt    map[string]time.Time
+	jwks      map[string]*oidcJWKS // by jwks_uri
+}
+
+func NewOIDCClient() *OIDCClient {
+	return &OIDCClient{
+		client:    &http.Client{Timeout: 10 * time.Second},
+		discovery: make(map[string]*oidcDiscovery),
+		discAt:    make(map[string]time.Time),
+		jwks:      make(map[string]*oidcJWKS),
+	}
+}
+
+func (c *OIDCClient) getJSON(ctx context.Context, rawURL string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: http status %d", rawURL, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
+
+// Discover fetches (or returns the cached) discovery document for issuer.
+func (c *OIDCClient) Discover(ctx context.Context, issuer string) (*oidcDiscovery, error) {
+	issuer = strings.TrimRight(issuer, "/")
+	c.mu.Lock()
+	d, ok := c.discovery[issuer]
+	fresh := ok && time.Since(c.discAt[issuer]) < oidcDiscoveryTTL
+	c.mu.Unlock()
+	if fresh {
+		return d, nil
+	}
+
+	var doc oidcDiscovery
+	if err := c.getJSON(ctx, issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	if strings.TrimRight(doc.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("discovery: issuer mismatch (%q)", doc.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, errors.New("discovery: missing endpoints")
+	}
+
+	c.mu.Lock()
+	c.discovery[issuer] = &doc
+	c.discAt[issuer] = time.Now()
+	c.mu.Unlock()
+	return &doc, nil
+}
+
+// signingKey returns the JWKS key with kid. An unknown kid triggers a
+// refetch, since the issuer may have rotated its keys.
+func (c *OIDCClient) signingKey(ctx context.Context, jwksURI, kid string) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	set := c.jwks[jwksURI]
+	c.mu.Unlock()
+	if set != nil && time.Since(set.fetched) < oidcJWKSTTL {
+		if key := set.lookup(kid); key != nil {
+			return key, nil
+		}
+	}
+
+	set, err := c.fetchJWKS(ctx, jwksURI)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.jwks[jwksURI] = set
+	c.mu.Unlock()
+	if key := set.lookup(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("no signing key %q", kid)
+}
+
+func (set *oidcJWKS) lookup(kid string) crypto.PublicKey {
+	if kid == "" && len(set.keys) == 1 {
+		for _, k := range set.keys {
+			return k
+		}
+	}
+	return set.keys[kid]
+}
+
+func (c *OIDCClient) fetchJWKS(ctx context.Context, jwksURI string) (*oidcJWKS, error) {
+	var doc struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := c.getJSON(ctx, jwksURI, &doc); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+
+	set := &oidcJWKS{keys: make(map[string]crypto.PublicKey), fetched: time.Now()}
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil || len(e) == 0 || len(e) > 4 {
+				continue
+			}
+			exp := 0
+			for _, b := range e {
+				exp = exp<<8 | int(b)
+			}
+			set.keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+				continue
+			}
+			set.keys[k.Kid] = pub
+		}
+	}
+	return set, nil
+}
+
+// ExchangeCode redeems an authorization code (with its PKCE verifier) at the
+// token endpoint and returns the raw ID token.
+func (c *OIDCClient) ExchangeCode(ctx context.Context, d *oidcDiscovery, p *OIDCProvider, code, verifier, redirectURI string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	}
+	// client_secret_basic is the spec default; fall back to _post if the
+	// issuer only advertises that. Public clients send just client_id.
+	useBasic := p.ClientSecret != "" &&
+		(len(d.TokenEndpointAuthMethods) == 0 || slices.Contains(d.TokenEndpointAuthMethods, "client_secret_basic"))
+	if !useBasic {
+		form.Set("client_id", p.ClientID)
+		if p.ClientSecret != "" {
+			form.Set("client_secret", p.ClientSecret)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if useBasic {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&out); err != nil {
+		return "", fmt.Errorf("token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || out.Error != "" {
+		return "", fmt.Errorf("token endpoint: %s %s (status %d)", out.Error, out.ErrorDescription, resp.StatusCode)
+	}
+	if out.IDToken == "" {
+		return "", errors.New("token response has no id_token")
+	}
+	return out.IDToken, nil
+}
+
+// VerifyIDToken checks the ID token's signature, issuer, audience, expiry and
+// nonce, and returns its claims.
+func (c *OIDCClient) VerifyIDToken(ctx context.Context, d *oidcDiscovery, p *OIDCProvider, raw, nonce string) (map[string]any, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed id token")
+	}
+	headerJSON, err1 := base64.RawURLEncoding.DecodeString(parts[0])
+	payload, err2 := base64.RawURLEncoding.DecodeString(parts[1])
+	sig, err3 := base64.RawURLEncoding.DecodeString(parts[2])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return nil, errors.New("malformed id token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, errors.New("malformed id token header")
+	}
+	key, err := c.signingKey(ctx, d.JWKSURI, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) != nil {
+			return nil, errors.New("invalid id token signature")
+		}
+	case *ecdsa.PublicKey:
+		// JWS ES256 signatures are raw r||s, not ASN.1
+		if header.Alg != "ES256" || len(sig) != 64 ||
+			!ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+			return nil, errors.New("invalid id token signature")
+		}
+	default:
+		return nil, errors.New("unsupported signing key")
+	}
+
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("malformed id token claims")
+	}
+
+	if iss, _ := claims["iss"].(string); strings.TrimRight(iss, "/") != strings.TrimRight(d.Issuer, "/") {
+		return nil, fmt.Errorf("issuer mismatch (%q)", iss)
+	}
+	if !audienceContains(claims["aud"], p.ClientID) {
+		return nil, errors.New("audience mismatch")
+	}
+	now := time.Now()
+	exp, _ := claims["exp"].(float64)
+	if exp == 0 || now.After(time.Unix(int64(exp), 0).Add(oidcClockSkew)) {
+		return nil, errors.New("id token expired")
+	}
+	if iat, ok := claims["iat"].(float64); ok && time.Unix(int64(iat), 0).After(now.Add(oidcClockSkew)) {
+		return nil, errors.New("id token issued in the future")
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, errors.New("nonce mismatch")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("id token has no subject")
+	}
+	return claims, nil
+}
+
+func audienceContains(aud any, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []any:
+		for _, a := range v {
+			if s, _ := a.(string); s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// newPKCE returns a code verifier and its S256 challenge.
+func newPKCE() (verifier, challenge string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	verifier = base64.RawURLEncoding.EncodeToString(b)
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
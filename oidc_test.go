@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockIssuer is an OpenID provider serving discovery, a JWKS and a token
+// endpoint. ID tokens are handed out per authorization code by issue.
+type mockIssuer struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PrivateKey // published in the JWKS, by kid
+	tokens    map[string]string          // code -> id token
+	jwksFetch int
+	tokenForm map[string][]string // last token request
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	m := &mockIssuer{
+		t:      t,
+		keys:   make(map[string]*rsa.PrivateKey),
+		tokens: make(map[string]string),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                 m.srv.URL,
+			"authorization_endpoint": m.srv.URL + "/authorize",
+			"token_endpoint":         m.srv.URL + "/token",
+			"jwks_uri":               m.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.jwksFetch++
+		var keys []map[string]string
+		for kid, key := range m.keys {
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"kid": kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.tokenForm = r.PostForm
+		token, ok := m.tokens[r.PostForm.Get("code")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		delete(m.tokens, r.PostForm.Get("code"))
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": token})
+	})
+	m.srv = httptest.NewServer(mux)
+	t.Cleanup(m.srv.Close)
+	return m
+}
+
+// addKey generates and publishes a signing key.
+func (m *mockIssuer) addKey(kid string) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	m.mu.Lock()
+	m.keys[kid] = key
+	m.mu.Unlock()
+	return key
+}
+
+// claims returns valid ID token claims for clientID and nonce.
+func (m *mockIssuer) claims(clientID, nonce string) map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":   m.srv.URL,
+		"aud":   clientID,
+		"sub":   "user-123",
+		"email": "dev@example.com",
+		"nonce": nonce,
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+	}
+}
+
+// sign makes an RS256 ID token; key need not be published.
+func (m *mockIssuer) sign(kid string, key *rsa.PrivateKey, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// issue makes the token endpoint return idToken for code.
+func (m *mockIssuer) issue(code, idToken string) {
+	m.mu.Lock()
+	m.tokens[code] = idToken
+	m.mu.Unlock()
+}
+
+func (m *mockIssuer) jwksFetches() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.jwksFetch
+}
+
+func TestOIDCVerifyIDToken(t *testing.T) {
+	m := newMockIssuer(t)
+	key := m.addKey("k1")
+	c := NewOIDCClient()
+	p := &OIDCProvider{Issuer: m.srv.URL, ClientID: "creator"}
+	d, err := c.Discover(context.Background(), p.Issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		mutate  func(map[string]any)
+		nonce   string
+		wantErr string
+	}{
+		{name: "valid", mutate: func(map[string]any) {}, nonce: "n1"},
+		{name: "audience list", mutate: func(c map[string]any) { c["aud"] = []string{"other", "creator"} }, nonce: "n1"},
+		{name: "nonce mismatch", mutate: func(map[string]any) {}, nonce: "n2", wantErr: "nonce mismatch"},
+		{name: "wrong aud", mutate: func(c map[string]any) { c["aud"] = "someone-else" }, nonce: "n1", wantErr: "audience mismatch"},
+		{name: "wrong issuer", mutate: func(c map[string]any) { c["iss"] = "https://evil.example" }, nonce: "n1", wantErr: "issuer mismatch"},
+		{name: "expired", mutate: func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, nonce: "n1", wantErr: "expired"},
+		{name: "no subject", mutate: func(c map[string]any) { delete(c, "sub") }, nonce: "n1", wantErr: "no subject"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := m.claims("creator", "n1")
+			tt.mutate(claims)
+			_, err := c.VerifyIDToken(context.Background(), d, p, m.sign("k1", key, claims), tt.nonce)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("bad signature", func(t *testing.T) {
+		forged, _ := rsa.GenerateKey(rand.Reader, 2048)
+		_, err := c.VerifyIDToken(context.Background(), d, p, m.sign("k1", forged, m.claims("creator", "n1")), "n1")
+		if err == nil || !strings.Contains(err.Error(), "signature") {
+			t.Fatalf("got error %v, want invalid signature", err)
+		}
+	})
+}
+
+func TestOIDCUnknownKidRefetchesJWKS(t *testing.T) {
+	m := newMockIssuer(t)
+	k1 := m.addKey("k1")
+	c := NewOIDCClient()
+	p := &OIDCProvider{Issuer: m.srv.URL, ClientID: "creator"}
+	d, err := c.Discover(context.Background(), p.Issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify := func(kid string, key *rsa.PrivateKey) error {
+		_, err := c.VerifyIDToken(context.Background(), d, p, m.sign(kid, key, m.claims("creator", "n")), "n")
+		return err
+	}
+
+	if err := verify("k1", k1); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify("k1", k1); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.jwksFetches(); n != 1 {
+		t.Fatalf("jwks fetched %d times for a known kid, want 1", n)
+	}
+
+	// The issuer rotates in k2: the unknown kid forces a refetch
+	k2 := m.addKey("k2")
+	if err := verify("k2", k2); err != nil {
+		t.Fatalf("rotated key rejected: %v", err)
+	}
+	if n := m.jwksFetches(); n != 2 {
+		t.Fatalf("jwks fetched %d times after rotation, want 2", n)
+	}
+
+	// A kid the issuer never published fails after one more refetch
+	stray, _ := rsa.GenerateKey(rand.Reader, 2048)
+	if err := verify("k3", stray); err == nil || !strings.Contains(err.Error(), "no signing key") {
+		t.Fatalf("got error %v, want no signing key", err)
+	}
+	if n := m.jwksFetches(); n != 3 {
+		t.Fatalf("jwks fetched %d times for an unknown kid, want 3", n)
+	}
+}
+
+func TestOIDCExchangeCode(t *testing.T) {
+	m := newMockIssuer(t)
+	c := NewOIDCClient()
+	p := &OIDCProvider{Issuer: m.srv.URL, ClientID: "creator"}
+	d, err := c.Discover(context.Background(), p.Issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.issue("code-1", "id-token")
+	token, err := c.ExchangeCode(context.Background(), d, p, "code-1", "verifier-1", "https://creator.example/auth/oidc/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "id-token" {
+		t.Fatalf("got id token %q", token)
+	}
+	m.mu.Lock()
+	form := m.tokenForm
+	m.mu.Unlock()
+	for k, want := range map[string]string{
+		"grant_type":    "authorization_code",
+		"code_verifier": "verifier-1",
+		"client_id":     "creator",
+		"redirect_uri":  "https://creator.example/auth/oidc/callback",
+	} {
+		if got := form[k]; len(got) != 1 || got[0] != want {
+			t.Errorf("token request %s = %v, want %q", k, got, want)
+		}
+	}
+
+	// Codes are single use at the issuer
+	if _, err := c.ExchangeCode(context.Background(), d, p, "code-1", "verifier-1", "x"); err == nil {
+		t.Fatal("reused code accepted")
+	}
+}
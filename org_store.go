@@ -0,0 +1,138 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Organization operations
+
+func (s *Store) CreateOrg(name string, ownerID int64) (*Organization, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	org := Organization{Name: name, Role: RoleOwner}
+	err = tx.QueryRow(`
+		INSERT INTO organizations (name, created_by) VALUES ($1, $2)
+		RETURNING id, created_at
+	`, name, ownerID).Scan(&org.ID, &org.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`INSERT INTO org_members (org_id, user_id, role) VALUES ($1, $2, $3)`, org.ID, ownerID, RoleOwner); err != nil {
+		return nil, err
+	}
+	return &org, tx.Commit()
+}
+
+// ListOrgsForUser returns the orgs a user belongs to, with their role in each.
+func (s *Store) ListOrgsForUser(userID int64) ([]*Organization, error) {
+	rows, err := s.db.Query(`
+		SELECT o.id, o.name, m.role, o.created_at
+		FROM organizations o JOIN org_members m ON m.org_id = o.id
+		WHERE m.user_id=$1 ORDER BY o.name
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orgs []*Organization
+	for rows.Next() {
+		var org Organization
+		if err := rows.Scan(&org.ID, &org.Name, &org.Role, &org.CreatedAt); err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, &org)
+	}
+	return orgs, rows.Err()
+}
+
+func (s *Store) OrgExists(orgID int64) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM organizations WHERE id=$1)`, orgID).Scan(&exists)
+	return exists, err
+}
+
+// GetOrgRole returns the user's role in an org, or sql.ErrNoRows if they
+// aren't a member.
+func (s *Store) GetOrgRole(orgID, userID int64) (string, error) {
+	var role string
+	err := s.db.QueryRow(`SELECT role FROM org_members WHERE org_id=$1 AND user_id=$2`, orgID, userID).Scan(&role)
+	return role, err
+}
+
+// DeleteOrg deletes an org. Its servers become personal servers of their
+// creators; those whose creator was deleted go to heirID.
+func (s *Store) DeleteOrg(orgID, heirID int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE servers SET user_id=$2 WHERE org_id=$1 AND user_id IS NULL`, orgID, heirID); err != nil {
+		return err
+	}
+	result, err := tx.Exec(`DELETE FROM organizations WHERE id=$1`, orgID)
+	if err != nil {
+		return err
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("organization not found")
+	}
+	return tx.Commit()
+}
+
+func (s *Store) ListOrgMembers(orgID int64) ([]*OrgMember, error) {
+	rows, err := s.db.Query(`
+		SELECT u.id, u.address, m.role, m.created_at
+		FROM org_members m JOIN users u ON u.id = m.user_id
+		WHERE m.org_id=$1 ORDER BY m.created_at
+	`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []*OrgMember
+	for rows.Next() {
+		var m OrgMember
+		if err := rows.Scan(&m.UserID, &m.Address, &m.Role, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, &m)
+	}
+	return members, rows.Err()
+}
+
+// SetOrgMember adds a user to an org or changes their role.
+func (s *Store) SetOrgMember(orgID, userID int64, role string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO org_members (org_id, user_id, role) VALUES ($1, $2, $3)
+		ON CONFLICT (org_id, user_id) DO UPDATE SET role=EXCLUDED.role
+	`, orgID, userID, role)
+	return err
+}
+
+func (s *Store) RemoveOrgMember(orgID, userID int64) error {
+	result, err := s.db.Exec(`DELETE FROM org_members WHERE org_id=$1 AND user_id=$2`, orgID, userID)
+	if err != nil {
+		return err
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *Store) CountOrgOwners(orgID int64) (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM org_members WHERE org_id=$1 AND role=$2`, orgID, RoleOwner).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Organizations let a team co-manage servers. Members hold a per-org role
+// (viewer, operator or owner) that governs what they can do on org servers;
+// org owners also manage membership.
+
+var orgRoles = []string{RoleViewer, RoleOperator, RoleOwner}
+
+// orgRole returns the user's effective role in an org. Admins act as owners
+// of every org.
+func (s *Server) orgRole(user *User, orgID int64) (string, error) {
+	if user.Can(PermUsersManage) {
+		if ok, err := s.store.OrgExists(orgID); err != nil || !ok {
+			return "", sql.ErrNoRows
+		}
+		return RoleOwner, nil
+	}
+	return s.store.GetOrgRole(orgID, user.ID)
+}
+
+// requireOrgRole resolves {id} as an org and checks the caller's role in it is
+// one of roles. It writes the error response and returns ok=false on failure.
+func (s *Server) requireOrgRole(w http.ResponseWriter, r *http.Request, roles ...string) (orgID int64, ok bool) {
+	user := userFromContext(r.Context())
+	orgID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid organization id"})
+		return 0, false
+	}
+	role, err := s.orgRole(user, orgID)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "organization not found"})
+		return 0, false
+	}
+	if !slices.Contains(roles, role) {
+		writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "organization owner required"})
+		return 0, false
+	}
+	return orgID, true
+}
+
+func (s *Server) handleCreateOrg(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "name is required"})
+		return
+	}
+
+	org, err := s.store.CreateOrg(strings.TrimSpace(req.Name), user.ID)
+	if err != nil {
+		slog.Error("failed to create organization", "user_id", user.ID, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to create organization"})
+		return
+	}
+	slog.Info("organization created", "org_id", org.ID, "user_id", user.ID)
+
+	writeJSON(w, http.StatusCreated, org)
+}
+
+func (s *Server) handleListOrgs(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+
+	orgs, err := s.store.ListOrgsForUser(user.ID)
+	if err != nil {
+		slog.Error("failed to list organizations", "user_id", user.ID, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to list organizations"})
+		return
+	}
+	if orgs == nil {
+		orgs = []*Organization{}
+	}
+	writeJSON(w, http.StatusOK, orgs)
+}
+
+func (s *Server) handleDeleteOrg(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := s.requireOrgRole(w, r, RoleOwner)
+	if !ok {
+		return
+	}
+
+	// Org servers fall back to being personal servers of their creators, or
+	// of whoever deletes the org if their creator is gone
+	if err := s.store.DeleteOrg(orgID, userFromContext(r.Context()).ID); err != nil {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "organization not found"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+func (s *Server) handleListOrgMembers(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := s.requireOrgRole(w, r, orgRoles...)
+	if !ok {
+		return
+	}
+
+	members, err := s.store.ListOrgMembers(orgID)
+	if err != nil {
+		slog.Error("failed to list organization members", "org_id", orgID, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to list members"})
+		return
+	}
+	writeJSON(w, http.StatusOK, members)
+}
+
+// handleAddOrgMember adds a user (by address or id) to the org.
+func (s *Server) handleAddOrgMember(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := s.requireOrgRole(w, r, RoleOwner)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		UserID  int64  `json:"user_id"`
+		Address string `json:"address"`
+		Role    string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+		return
+	}
+	if req.Role == "" {
+		req.Role = RoleViewer
+	}
+	if !slices.Contains(orgRoles, req.Role) {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "role must be one of viewer, operator, owner"})
+		return
+	}
+
+	var member *User
+	var err error
+	if req.Address != "" {
+		member, err = s.store.GetUserByAddress(strings.ToLower(req.Address))
+	} else {
+		member, err = s.store.GetUserByID(req.UserID)
+	}
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "user not found"})
+		return
+	}
+
+	if err := s.store.SetOrgMember(orgID, member.ID, req.Role); err != nil {
+		slog.Error("failed to add organization member", "org_id", orgID, "user_id", member.ID, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to add member"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleSetOrgMemberRole(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := s.requireOrgRole(w, r, RoleOwner)
+	if !ok {
+		return
+	}
+	userID, err := strconv.ParseInt(r.PathValue("user_id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid user id"})
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !slices.Contains(orgRoles, req.Role) {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "role must be one of viewer, operator, owner"})
+		return
+	}
+
+	current, err := s.store.GetOrgRole(orgID, userID)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "member not found"})
+		return
+	}
+	if current == RoleOwner && req.Role != RoleOwner && !s.hasOtherOwner(orgID) {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "organization must keep at least one owner"})
+		return
+	}
+
+	if err := s.store.SetOrgMember(orgID, userID, req.Role); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to set role"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok", "role": req.Role})
+}
+
+// handleRemoveOrgMember removes a member. Owners can remove anyone; members
+// can remove themselves (leave).
+func (s *Server) handleRemoveOrgMember(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+	userID, err := strconv.ParseInt(r.PathValue("user_id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid user id"})
+		return
+	}
+
+	roles := []string{RoleOwner}
+	if userID == user.ID {
+		roles = orgRoles
+	}
+	orgID, ok := s.requireOrgRole(w, r, roles...)
+	if !ok {
+		return
+	}
+
+	current, err := s.store.GetOrgRole(orgID, userID)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "member not found"})
+		return
+	}
+	if current == RoleOwner && !s.hasOtherOwner(orgID) {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "organization must keep at least one owner"})
+		return
+	}
+
+	if err := s.store.RemoveOrgMember(orgID, userID); err != nil {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "member not found"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "removed"})
+}
+
+func (s *Server) hasOtherOwner(orgID int64) bool {
+	n, err := s.store.CountOrgOwners(orgID)
+	return err == nil && n > 1
+}
+
+// handleTransferServer moves a server to another user or into an org. The
+// caller needs servers:write on the server, and owner role in a target org.
+func (s *Server) handleTransferServer(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid server id"})
+		return
+	}
+
+	var req struct {
+		UserID int64 `json:"user_id"`
+		OrgID  int64 `json:"org_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.UserID == 0) == (req.OrgID == 0) {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "exactly one of user_id or org_id is required"})
+		return
+	}
+
+	if _, err := s.lookupServer(user, id, PermServersWrite); err != nil {
+		writeLookupError(w, err)
+		return
+	}
+
+	if req.OrgID != 0 {
+		role, err := s.orgRole(user, req.OrgID)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "organization not found"})
+			return
+		}
+		if role != RoleOwner {
+			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "organization owner required"})
+			return
+		}
+	} else {
+		target, err := s.store.GetUserByID(req.UserID)
+		if err != nil || !target.Approved {
+			writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "user not found"})
+			return
+		}
+	}
+
+	if err := s.store.TransferServer(id, req.UserID, req.OrgID); err != nil {
+		slog.Error("failed to transfer server", "server_id", id, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to transfer server"})
+		return
+	}
+	slog.Info("server transferred", "server_id", id, "by", user.ID, "to_user", req.UserID, "to_org", req.OrgID)
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "transferred"})
+}
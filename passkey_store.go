@@ -0,0 +1,160 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// WebAuthn ceremony operations
+
+// WebAuthnCeremony is a pending registration or login, keyed by challenge.
+type WebAuthnCeremony struct {
+	Kind       string // "register" or "login"
+	UserID     int64  // registering user; 0 when signing up
+	UserHandle []byte // registration only
+	ExpiresAt  time.Time
+}
+
+func (s *Store) CreateWebAuthnCeremony(challenge string, c *WebAuthnCeremony) error {
+	var userID sql.NullInt64
+	if c.UserID != 0 {
+		userID = sql.NullInt64{Int64: c.UserID, Valid: true}
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO webauthn_ceremonies (challenge, kind, user_id, user_handle, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, challenge, c.Kind, userID, c.UserHandle, c.ExpiresAt)
+	return err
+}
+
+// ConsumeWebAuthnCeremony atomically deletes and returns a ceremony.
+func (s *Store) ConsumeWebAuthnCeremony(challenge string) (*WebAuthnCeremony, error) {
+	var c WebAuthnCeremony
+	var userID sql.NullInt64
+	err := s.db.QueryRow(`
+		DELETE FROM webauthn_ceremonies WHERE challenge=$1
+		RETURNING kind, user_id, user_handle, expires_at
+	`, challenge).Scan(&c.Kind, &userID, &c.UserHandle, &c.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	c.UserID = userID.Int64
+	return &c, nil
+}
+
+// Passkey operations
+
+func (s *Store) GetWebAuthnHandle(userID int64) ([]byte, error) {
+	var handle []byte
+	err := s.db.QueryRow(`SELECT webauthn_handle FROM users WHERE id=$1`, userID).Scan(&handle)
+	return handle, err
+}
+
+// SetWebAuthnHandle sets the user's handle unless one is already set.
+func (s *Store) SetWebAuthnHandle(userID int64, handle []byte) error {
+	_, err := s.db.Exec(`UPDATE users SET webauthn_handle=$2 WHERE id=$1 AND webauthn_handle IS NULL`, userID, handle)
+	return err
+}
+
+func (s *Store) CreatePasskey(userID int64, credentialID, publicKey []byte, signCount uint32, name string) (*Passkey, error) {
+	p := Passkey{UserID: userID, Name: name, SignCount: signCount, PublicKey: publicKey}
+	err := s.db.QueryRow(`
+		INSERT INTO passkeys (user_id, credential_id, public_key, sign_count, name)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`, userID, credentialID, publicKey, int64(signCount), name).Scan(&p.ID, &p.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s *Store) GetPasskeyByCredentialID(credentialID []byte) (*Passkey, error) {
+	var p Passkey
+	var signCount int64
+	var lastUsed sql.NullString
+	err := s.db.QueryRow(`
+		SELECT id, user_id, name, sign_count, public_key, last_used_at, created_at
+		FROM passkeys WHERE credential_id=$1
+	`, credentialID).Scan(&p.ID, &p.UserID, &p.Name, &signCount, &p.PublicKey, &lastUsed, &p.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	p.SignCount = uint32(signCount)
+	p.LastUsedAt = lastUsed.String
+	return &p, nil
+}
+
+// ListPasskeyCredentialIDs returns the user's credential ids, for
+// excludeCredentials when registering another passkey.
+func (s *Store) ListPasskeyCredentialIDs(userID int64) ([][]byte, error) {
+	rows, err := s.db.Query(`SELECT credential_id FROM passkeys WHERE user_id=$1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids [][]byte
+	for rows.Next() {
+		var id []byte
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *Store) ListPasskeys(userID int64) ([]*Passkey, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, last_used_at, created_at
+		FROM passkeys WHERE user_id=$1 ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var passkeys []*Passkey
+	for rows.Next() {
+		p := Passkey{UserID: userID}
+		var lastUsed sql.NullString
+		if err := rows.Scan(&p.ID, &p.Name, &lastUsed, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		p.LastUsedAt = lastUsed.String
+		passkeys = append(passkeys, &p)
+	}
+	return passkeys, rows.Err()
+}
+
+// UpdatePasskeyUse records a successful assertion.
+func (s *Store) UpdatePasskeyUse(id int64, signCount uint32) error {
+	_, err := s.db.Exec(`UPDATE passkeys SET sign_count=$2, last_used_at=now() WHERE id=$1`, id, int64(signCount))
+	return err
+}
+
+func (s *Store) CountPasskeys(userID int64) (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM passkeys WHERE user_id=$1`, userID).Scan(&n)
+	return n, err
+}
+
+func (s *Store) DeletePasskey(id, userID int64) error {
+	result, err := s.db.Exec(`DELETE FROM passkeys WHERE id=$1 AND user_id=$2`, id, userID)
+	if err != nil {
+		return err
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("passkey not found")
+	}
+	return nil
+}
+
+// SetUserAddress links a wallet address to a passkey-only user.
+func (s *Store) SetUserAddress(userID int64, address string) error {
+	_, err := s.db.Exec(`UPDATE users SET address=$2 WHERE id=$1 AND address=''`, userID, address)
+	return err
+}
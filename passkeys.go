@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Passkey (WebAuthn) sign-in, an alternative to wallets. A signed-in user
+// can register passkeys alongside their wallet; a visitor can sign up with a
+// passkey alone (and link a wallet later via /auth/verify with "link").
+// Sign-in goes through startSession, so suspension, invites and approval
+// behave exactly as for wallets.
+
+const (
+	webauthnRPName  = "openclaw creator"
+	webauthnTimeout = 5 * time.Minute
+)
+
+var webauthnAlgs = []int64{coseAlgES256, coseAlgEdDSA, coseAlgRS256}
+
+// webauthnRPID is the relying party id: the public hostname, without port.
+func (s *Server) webauthnRPID() string {
+	domain, _ := s.siweOrigin()
+	if host, _, err := net.SplitHostPort(domain); err == nil {
+		return host
+	}
+	return domain
+}
+
+func newWebAuthnChallenge() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+type credentialDescriptor struct {
+	Type string `json:"type"`
+	ID   b64url `json:"id"`
+}
+
+// Request bodies, in the shape of PublicKeyCredential.toJSON()
+
+type passkeyRegistration struct {
+	Credential struct {
+		RawID    b64url `json:"rawId"`
+		Response struct {
+			ClientDataJSON    b64url `json:"clientDataJSON"`
+			AttestationObject b64url `json:"attestationObject"`
+		} `json:"response"`
+	} `json:"credential"`
+	Name       string `json:"name"`
+	InviteCode string `json:"invite_code,omitempty"`
+}
+
+type passkeyAssertion struct {
+	Credential struct {
+		RawID    b64url `json:"rawId"`
+		Response struct {
+			ClientDataJSON    b64url `json:"clientDataJSON"`
+			AuthenticatorData b64url `json:"authenticatorData"`
+			Signature         b64url `json:"signature"`
+			UserHandle        b64url `json:"userHandle"`
+		} `json:"response"`
+	} `json:"credential"`
+	InviteCode string `json:"invite_code,omitempty"`
+}
+
+// consumeCeremony validates clientDataJSON against the request origin and
+// redeems the ceremony its challenge refers to.
+func (s *Server) consumeCeremony(r *http.Request, raw []byte, clientType, kind string) (*WebAuthnCeremony, error) {
+	cd, err := parseClientData(raw, clientType)
+	if err != nil {
+		return nil, err
+	}
+	if !s.originAllowed(r, cd.Origin) {
+		return nil, fmt.Errorf("origin %q not allowed", cd.Origin)
+	}
+	c, err := s.store.ConsumeWebAuthnCeremony(cd.Challenge)
+	if err != nil || c.Kind != kind || time.Now().After(c.ExpiresAt) {
+		return nil, fmt.Errorf("invalid or expired challenge")
+	}
+	return c, nil
+}
+
+// handlePasskeyRegisterBegin returns PublicKeyCredentialCreationOptions. When
+// signed in, the passkey is added to the current account; otherwise finishing
+// the ceremony creates a new passkey-only account.
+func (s *Server) handlePasskeyRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	user, r := s.sessionAuth(r)
+	if user != nil && apiTokenFromContext(r.Context()) != nil {
+		writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "not available to API tokens"})
+		return
+	}
+
+	var handle []byte
+	var exclude []credentialDescriptor
+	displayName := "new user"
+	if user != nil {
+		var err error
+		if handle, err = s.store.GetWebAuthnHandle(user.ID); err != nil {
+			slog.Error("failed to load webauthn handle", "user_id", user.ID, "error", err)
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "internal error"})
+			return
+		}
+		ids, err := s.store.ListPasskeyCredentialIDs(user.ID)
+		if err != nil {
+			slog.Error("failed to list passkeys", "user_id", user.ID, "error", err)
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "internal error"})
+			return
+		}
+		for _, id := range ids {
+			exclude = append(exclude, credentialDescriptor{Type: "public-key", ID: id})
+		}
+		displayName = user.Address
+		if displayName == "" {
+			displayName = "user " + strconv.FormatInt(user.ID, 10)
+		}
+	}
+	if handle == nil {
+		handle = make([]byte, 32)
+		if _, err := rand.Read(handle); err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "internal error"})
+			return
+		}
+	}
+
+	challenge, err := newWebAuthnChallenge()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "internal error"})
+		return
+	}
+	ceremony := &WebAuthnCeremony{Kind: "register", UserHandle: handle, ExpiresAt: time.Now().Add(webauthnTimeout)}
+	if user != nil {
+		ceremony.UserID = user.ID
+	}
+	if err := s.store.CreateWebAuthnCeremony(challenge, ceremony); err != nil {
+		slog.Error("failed to store webauthn ceremony", "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "internal error"})
+		return
+	}
+
+	params := make([]map[string]any, len(webauthnAlgs))
+	for i, alg := range webauthnAlgs {
+		params[i] = map[string]any{"type": "public-key", "alg": alg}
+	}
+	if exclude == nil {
+		exclude = []credentialDescriptor{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"challenge": challenge,
+		"rp":        map[string]string{"id": s.webauthnRPID(), "name": webauthnRPName},
+		"user": map[string]any{
+			"id":          b64url(handle),
+			"name":        displayName,
+			"displayName": displayName,
+		},
+		"pubKeyCredParams": params,
+		"timeout":          webauthnTimeout.Milliseconds(),
+		"attestation":      "none",
+		"authenticatorSelection": map[string]string{
+			"residentKey":      "required",
+			"userVerification": "preferred",
+		},
+		"excludeCredentials": exclude,
+	})
+}
+
+func (s *Server) handlePasskeyRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	var req passkeyRegistration
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+		return
+	}
+	resp := req.Credential.Response
+
+	ceremony, err := s.consumeCeremony(r, resp.ClientDataJSON, "webauthn.create", "register")
+	if err != nil {
+		slog.Warn("passkey registration rejected", "error", err)
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	authData, err := parseAttestationObject(resp.AttestationObject)
+	if err == nil {
+		err = authData.check(s.webauthnRPID())
+	}
+	if err == nil {
+		_, _, err = parseCOSEKey(authData.PublicKey)
+	}
+	if err != nil {
+		slog.Warn("passkey registration rejected", "error", err)
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid credential: " + err.Error()})
+		return
+	}
+	if !bytes.Equal(authData.CredentialID, req.Credential.RawID) {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid credential: id mismatch"})
+		return
+	}
+
+	// The ceremony decides the account: the signed-in user who started it,
+	// or a new passkey-only user
+	var user *User
+	if ceremony.UserID != 0 {
+		current, _ := s.sessionAuth(r)
+		if current == nil || current.ID != ceremony.UserID {
+			writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "unauthorized"})
+			return
+		}
+		user = current
+	} else {
+		user, err = s.store.CreateUser("", "")
+		if err != nil {
+			slog.Error("failed to create user", "error", err)
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "internal error"})
+			return
+		}
+		slog.Info("auto-created passkey user", "user_id", user.ID, "role", user.Role)
+	}
+	if err := s.store.SetWebAuthnHandle(user.ID, ceremony.UserHandle); err != nil {
+		slog.Error("failed to set webauthn handle", "user_id", user.ID, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "internal error"})
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	passkey, err := s.store.CreatePasskey(user.ID, authData.CredentialID, authData.PublicKey, authData.SignCount, name)
+	if err != nil {
+		slog.Error("failed to store passkey", "user_id", user.ID, "error", err)
+		writeJSON(w, http.StatusConflict, ErrorResponse{Error: "passkey already registered"})
+		return
+	}
+	slog.Info("passkey registered", "user_id", user.ID, "passkey_id", passkey.ID)
+
+	if ceremony.UserID != 0 {
+		writeJSON(w, http.StatusCreated, passkey)
+		return
+	}
+	s.signIn(w, r, user, req.InviteCode)
+}
+
+// handlePasskeyLoginBegin returns PublicKeyCredentialRequestOptions for a
+// discoverable-credential sign-in.
+func (s *Server) handlePasskeyLoginBegin(w http.ResponseWriter, r *http.Request) {
+	challenge, err := newWebAuthnChallenge()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "internal error"})
+		return
+	}
+	ceremony := &WebAuthnCeremony{Kind: "login", ExpiresAt: time.Now().Add(webauthnTimeout)}
+	if err := s.store.CreateWebAuthnCeremony(challenge, ceremony); err != nil {
+		slog.Error("failed to store webauthn ceremony", "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "internal error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"challenge":        challenge,
+		"rpId":             s.webauthnRPID(),
+		"timeout":          webauthnTimeout.Milliseconds(),
+		"userVerification": "preferred",
+		"allowCredentials": []credentialDescriptor{},
+	})
+}
+
+func (s *Server) handlePasskeyLoginFinish(w http.ResponseWriter, r *http.Request) {
+	var req passkeyAssertion
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+		return
+	}
+	resp := req.Credential.Response
+
+	if _, err := s.consumeCeremony(r, resp.ClientDataJSON, "webauthn.get", "login"); err != nil {
+		slog.Warn("passkey sign-in rejected", "error", err)
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	passkey, err := s.store.GetPasskeyByCredentialID(req.Credential.RawID)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "unknown passkey"})
+		return
+	}
+	var handle []byte
+	if len(resp.UserHandle) > 0 {
+		handle, _ = s.store.GetWebAuthnHandle(passkey.UserID)
+	}
+
+	authData, err := verifyPasskeyAssertion(passkey, handle, s.webauthnRPID(),
+		resp.AuthenticatorData, resp.ClientDataJSON, resp.Signature, resp.UserHandle)
+	if err != nil {
+		slog.Warn("passkey sign-in rejected", "passkey_id", passkey.ID, "error", err)
+		msg := "invalid assertion"
+		if errors.Is(err, errPasskeyUserHandle) {
+			msg = "unknown passkey"
+		}
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: msg})
+		return
+	}
+	if err := s.store.UpdatePasskeyUse(passkey.ID, authData.SignCount); err != nil {
+		slog.Error("failed to update passkey", "passkey_id", passkey.ID, "error", err)
+	}
+
+	user, err := s.store.GetUserByID(passkey.UserID)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "unknown passkey"})
+		return
+	}
+	s.signIn(w, r, user, req.InviteCode)
+}
+
+var errPasskeyUserHandle = errors.New("user handle mismatch")
+
+// verifyPasskeyAssertion checks an assertion made with passkey: the user
+// handle, if the authenticator returned one, against handle (the account's),
+// then the RP ID, user presence, signature and sign count.
+func verifyPasskeyAssertion(passkey *Passkey, handle []byte, rpID string, rawAuthData, clientDataJSON, sig, userHandle []byte) (*authenticatorData, error) {
+	if len(userHandle) > 0 && !bytes.Equal(handle, userHandle) {
+		return nil, errPasskeyUserHandle
+	}
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := authData.check(rpID); err != nil {
+		return nil, err
+	}
+	if err := verifyAssertionSignature(passkey.PublicKey, rawAuthData, clientDataJSON, sig); err != nil {
+		return nil, err
+	}
+	// A counter that fails to advance suggests a cloned authenticator. Synced
+	// passkeys report 0 throughout, which is allowed.
+	if (authData.SignCount != 0 || passkey.SignCount != 0) && authData.SignCount <= passkey.SignCount {
+		return nil, fmt.Errorf("sign count did not increase: stored %d, got %d", passkey.SignCount, authData.SignCount)
+	}
+	return authData, nil
+}
+
+// Passkey management (signed in)
+
+func (s *Server) handleListPasskeys(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+
+	passkeys, err := s.store.ListPasskeys(user.ID)
+	if err != nil {
+		slog.Error("failed to list passkeys", "user_id", user.ID, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to list passkeys"})
+		return
+	}
+	if passkeys == nil {
+		passkeys = []*Passkey{}
+	}
+	writeJSON(w, http.StatusOK, passkeys)
+}
+
+func (s *Server) handleDeletePasskey(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid passkey id"})
+		return
+	}
+
+	// Keep at least one way to sign in: a wallet, an SSO identity or
+	// another passkey
+	if user.Address == "" {
+		identities, err := s.store.CountOIDCIdentities(user.ID)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "internal error"})
+			return
+		}
+		n, err := s.store.CountPasskeys(user.ID)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "internal error"})
+			return
+		}
+		if identities == 0 && n <= 1 {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "cannot remove your only sign-in method; link a wallet first"})
+			return
+		}
+	}
+
+	if err := s.store.DeletePasskey(id, user.ID); err != nil {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "passkey not found"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
@@ -54,6 +54,7 @@ type ProvisionOpts struct {
 	WayfinderAPIKey  string
 	Channels         []ChannelConfig
 	CreatorPublicKey string
+	AgentURL         string // node API dials out here instead of accepting inbound connections
 }
 
 func (p *Provisioner) WaitForSSH(ip string, logFn func(string)) error {
@@ -80,7 +81,8 @@ func (p *Provisioner) WaitForSSH(ip string, logFn func(string)) error {
 
 // ProvisionResult holds outputs extracted from provisioning.
 type ProvisionResult struct {
-	WalletAddress string
+	WalletAddress      string
+	NodeAPIFingerprint string // SPKI pin of the node API's TLS certificate
 }
 
 func (p *Provisioner) RunPlaybook(opts ProvisionOpts, logFn func(string)) (*ProvisionResult, error) {
@@ -186,7 +188,8 @@ func (p *Provisioner) RunPlaybook(opts ProvisionOpts, logFn func(string)) (*Prov
 	}
 
 	result := &ProvisionResult{
-		WalletAddress: parseWalletAddress(output.String()),
+		WalletAddress:      parseWalletAddress(output.String()),
+		NodeAPIFingerprint: parseNodeAPIFingerprint(output.String()),
 	}
 
 	logFn(fmt.Sprintf("Provisioning completed successfully in %s", elapsed))
@@ -215,6 +218,9 @@ func (p *Provisioner) buildExtraVars(opts ProvisionOpts) string {
 	if opts.CreatorPublicKey != "" {
 		vars["creator_public_key"] = opts.CreatorPublicKey
 	}
+	if opts.AgentURL != "" {
+		vars["creator_agent_url"] = opts.AgentURL
+	}
 	if len(opts.Channels) > 0 {
 		// Pass channels as a JSON-serializable list for Ansible
 		channels := make([]map[string]string, len(opts.Channels))
@@ -229,6 +235,9 @@ func (p *Provisioner) buildExtraVars(opts ProvisionOpts) string {
 			if ch.Account != "" {
 				m["account"] = ch.Account
 			}
+			if ch.AppToken != "" {
+				m["app_token"] = ch.AppToken
+			}
 			channels[i] = m
 		}
 		vars["channels"] = channels
@@ -258,6 +267,16 @@ func parseWalletAddress(output string) string {
 	return ""
 }
 
+// nodeAPIFingerprintRegex matches a base64 SHA-256 digest
+var nodeAPIFingerprintRegex = regexp.MustCompile(`NODE_API_FINGERPRINT=([A-Za-z0-9+/]{43}=)`)
+
+func parseNodeAPIFingerprint(output string) string {
+	if m := nodeAPIFingerprintRegex.FindStringSubmatch(output); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
 func (p *Provisioner) CheckSSH(ip string, logFn func(string)) error {
 	addr := net.JoinHostPort(ip, "22")
 	logFn("Checking SSH connectivity on " + addr + "...")
@@ -289,8 +308,10 @@ func collectSecrets(opts ProvisionOpts) []string {
 		}
 	}
 	for _, ch := range opts.Channels {
-		if len(ch.Token) > 3 {
-			secrets = append(secrets, ch.Token)
+		for _, token := range []string{ch.Token, ch.AppToken} {
+			if len(token) > 3 {
+				secrets = append(secrets, token)
+			}
 		}
 	}
 	return secrets
@@ -299,12 +320,23 @@ func collectSecrets(opts ProvisionOpts) []string {
 // pathPattern matches absolute file paths like /tmp/foo, /home/user/.ssh/key, etc.
 var pathPattern = regexp.MustCompile(`(?:/[\w.\-]+){2,}`)
 
+// tokenPattern matches channel tokens by format, so they're redacted even
+// when the server row no longer holds them (tokens are cleared once
+// provisioned): Telegram bot tokens, bare or in a bot API URL, Discord bot
+// tokens (user ID.timestamp.HMAC) and Slack bot, user and app tokens.
+var tokenPattern = regexp.MustCompile(`\b(?:bot)?\d{5,}:[A-Za-z0-9_-]{35}\b` +
+	`|\b[A-Za-z0-9_-]{23,28}\.[A-Za-z0-9_-]{6,7}\.[A-Za-z0-9_-]{27,}` +
+	`|\bxox[abpr]-[A-Za-z0-9-]+|\bxapp-[A-Za-z0-9-]+`)
+
 func redactLine(line string, secrets []string) string {
 	for _, s := range secrets {
 		if strings.Contains(line, s) {
 			line = strings.ReplaceAll(line, s, s[:3]+"***")
 		}
 	}
+	line = tokenPattern.ReplaceAllStringFunc(line, func(match string) string {
+		return match[:3] + "***"
+	})
 	// Obfuscate file paths — replace full paths with just the basename
 	line = pathPattern.ReplaceAllStringFunc(line, func(match string) string {
 		base := filepath.Base(match)
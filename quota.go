@@ -0,0 +1,203 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// Quotas cap how many servers a user may own, how many may provision at
+// once, and which Hetzner server types they may pick. Each role has a default
+// quota (none: unlimited, except that only SERVER_TYPE may be picked);
+// per-user overrides take precedence field by field.
+
+var serverTypeRegex = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+type quotaError struct{ msg string }
+
+func (e *quotaError) Error() string { return e.msg }
+
+// pendingCreates counts creations that passed the quota check but are not
+// yet in the servers table (the Hetzner call takes a while), so concurrent
+// requests can't overshoot a quota.
+type pendingCreates struct {
+	mu     sync.Mutex
+	byUser map[int64]int
+}
+
+// effectiveQuota merges the user's overrides over their role's quota. Other
+// server types than the configured one must be allowed explicitly.
+func (s *Server) effectiveQuota(user *User) (*Quota, error) {
+	q, err := s.store.GetRoleQuota(user.Role)
+	if err != nil {
+		return nil, err
+	}
+	if user.MaxServers != nil {
+		q.MaxServers = user.MaxServers
+	}
+	if user.MaxProvisioning != nil {
+		q.MaxProvisioning = user.MaxProvisioning
+	}
+	if user.ServerTypes != nil {
+		q.ServerTypes = user.ServerTypes
+	}
+	if len(q.ServerTypes) == 0 {
+		q.ServerTypes = []string{s.config.ServerType}
+	}
+	return q, nil
+}
+
+func (s *Server) quotaUsage(user *User) (*QuotaUsage, error) {
+	q, err := s.effectiveQuota(user)
+	if err != nil {
+		return nil, err
+	}
+	total, provisioning, err := s.store.CountUserServers(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	s.pendingCreates.mu.Lock()
+	pending := s.pendingCreates.byUser[user.ID]
+	s.pendingCreates.mu.Unlock()
+	return &QuotaUsage{Quota: *q, Servers: total + pending, Provisioning: provisioning + pending}, nil
+}
+
+// reserveServer checks the user may create a server of serverType (empty:
+// the configured default) and holds a slot until release is called. Quota
+// violations are returned as *quotaError.
+func (s *Server) reserveServer(user *User, serverType string) (release func(), err error) {
+	if serverType == "" {
+		serverType = s.config.ServerType
+	}
+
+	p := s.pendingCreates
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	q, err := s.effectiveQuota(user)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(q.ServerTypes, serverType) {
+		return nil, &quotaError{fmt.Sprintf("server type %q not allowed (allowed: %v)", serverType, q.ServerTypes)}
+	}
+
+	total, provisioning, err := s.store.CountUserServers(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	pending := p.byUser[user.ID]
+	if q.MaxServers != nil && total+pending >= *q.MaxServers {
+		return nil, &quotaError{fmt.Sprintf("server quota reached (%d of %d)", total+pending, *q.MaxServers)}
+	}
+	if q.MaxProvisioning != nil && provisioning+pending >= *q.MaxProvisioning {
+		return nil, &quotaError{fmt.Sprintf("too many servers provisioning (%d of %d); wait for one to finish", provisioning+pending, *q.MaxProvisioning)}
+	}
+
+	p.byUser[user.ID]++
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.byUser[user.ID]--; p.byUser[user.ID] <= 0 {
+			delete(p.byUser, user.ID)
+		}
+	}, nil
+}
+
+func validQuota(q *Quota) error {
+	if (q.MaxServers != nil && *q.MaxServers < 0) || (q.MaxProvisioning != nil && *q.MaxProvisioning < 0) {
+		return fmt.Errorf("limits must not be negative")
+	}
+	for _, t := range q.ServerTypes {
+		if !serverTypeRegex.MatchString(t) {
+			return fmt.Errorf("invalid server type %q", t)
+		}
+	}
+	return nil
+}
+
+// Quota handlers (admin)
+
+func (s *Server) handleListRoleQuotas(w http.ResponseWriter, r *http.Request) {
+	type roleQuota struct {
+		Role string `json:"role"`
+		*Quota
+	}
+	out := make([]roleQuota, 0, len(roleOrder))
+	for _, role := range roleOrder {
+		q, err := s.store.GetRoleQuota(role)
+		if err != nil {
+			slog.Error("failed to load role quota", "role", role, "error", err)
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to list quotas"})
+			return
+		}
+		out = append(out, roleQuota{Role: role, Quota: q})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) handleSetRoleQuota(w http.ResponseWriter, r *http.Request) {
+	role := r.PathValue("role")
+	if !validRole(role) {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "unknown role"})
+		return
+	}
+
+	var q Quota
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+		return
+	}
+	if err := validQuota(&q); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := s.store.SetRoleQuota(role, &q); err != nil {
+		slog.Error("failed to set role quota", "role", role, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to set quota"})
+		return
+	}
+	slog.Info("role quota changed", "role", role, "by", userFromContext(r.Context()).ID)
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleSetUserQuota replaces a user's quota overrides; null fields inherit
+// the role's quota.
+func (s *Server) handleSetUserQuota(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid user id"})
+		return
+	}
+
+	var q Quota
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+		return
+	}
+	if err := validQuota(&q); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := s.store.SetUserQuota(id, &q); err != nil {
+		if err == sql.ErrNoRows {
+			writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "user not found"})
+			return
+		}
+		slog.Error("failed to set user quota", "user_id", id, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to set quota"})
+		return
+	}
+	slog.Info("user quota changed", "user_id", id, "by", userFromContext(r.Context()).ID)
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// Quota operations
+
+func nullIntPtr(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	n := int(v.Int64)
+	return &n
+}
+
+// GetRoleQuota returns the role's quota; roles without one are unlimited.
+func (s *Store) GetRoleQuota(role string) (*Quota, error) {
+	var maxServers, maxProvisioning sql.NullInt64
+	var serverTypes string
+	err := s.db.QueryRow(`
+		SELECT max_servers, max_provisioning, server_types FROM role_quotas WHERE role=$1
+	`, role).Scan(&maxServers, &maxProvisioning, &serverTypes)
+	if err == sql.ErrNoRows {
+		return &Quota{ServerTypes: []string{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Quota{
+		MaxServers:      nullIntPtr(maxServers),
+		MaxProvisioning: nullIntPtr(maxProvisioning),
+		ServerTypes:     splitList(serverTypes),
+	}, nil
+}
+
+func (s *Store) SetRoleQuota(role string, q *Quota) error {
+	_, err := s.db.Exec(`
+		INSERT INTO role_quotas (role, max_servers, max_provisioning, server_types)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (role) DO UPDATE SET
+			max_servers=EXCLUDED.max_servers,
+			max_provisioning=EXCLUDED.max_provisioning,
+			server_types=EXCLUDED.server_types
+	`, role, q.MaxServers, q.MaxProvisioning, strings.Join(q.ServerTypes, ","))
+	return err
+}
+
+// SetUserQuota sets a user's quota overrides. Nil fields (and a nil
+// ServerTypes) inherit the role's quota.
+func (s *Store) SetUserQuota(userID int64, q *Quota) error {
+	var serverTypes sql.NullString
+	if q.ServerTypes != nil {
+		serverTypes = sql.NullString{String: strings.Join(q.ServerTypes, ","), Valid: true}
+	}
+	result, err := s.db.Exec(`
+		UPDATE users SET max_servers=$2, max_provisioning=$3, server_types=$4 WHERE id=$1
+	`, userID, q.MaxServers, q.MaxProvisioning, serverTypes)
+	if err != nil {
+		return err
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CountUserServers returns how many servers the user has created (including
+// ones placed in an org) and how many of those are still provisioning.
+func (s *Store) CountUserServers(userID int64) (total, provisioning int, err error) {
+	err = s.db.QueryRow(`
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE status='provisioning')
+		FROM servers WHERE user_id=$1
+	`, userID).Scan(&total, &provisioning)
+	return total, provisioning, err
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiter tracks per-key request rates using a token bucket. Keys are
+// client IPs or user IDs depending on the middleware.
+//
+// Like nodeapi/ratelimit.go, which it mirrors, the buckets live in this
+// instance's memory: with N creator machines a client gets up to N times the
+// configured rate, and a restart refills every bucket. It only blunts abuse;
+// hard caps such as server quotas are enforced in the database.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	rate    float64 // tokens per second
+	burst   int     // max tokens
+}
+
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	l := &rateLimiter{
+		buckets: make(map[string]*bucket),
+		rate:    rate,
+		burst:   burst,
+	}
+	go l.cleanup()
+	return l
+}
+
+// allow takes a token for key. When the bucket is empty it returns false and
+// how long until the next token is available.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		l.buckets[key] = &bucket{tokens: float64(l.burst) - 1, lastSeen: now}
+		return true, 0
+	}
+
+	// Refill tokens based on elapsed time
+	elapsed := now.Sub(b.lastSeen).Seconds()
+	b.tokens += elapsed * l.rate
+	if b.tokens > float64(l.burst) {
+		b.tokens = float64(l.burst)
+	}
+	b.lastSeen = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// cleanup evicts entries idle long enough to have fully refilled.
+func (l *rateLimiter) cleanup() {
+	idle := time.Duration(float64(l.burst)/l.rate*float64(time.Second)) + time.Minute
+	for {
+		time.Sleep(60 * time.Second)
+		l.mu.Lock()
+		for key, b := range l.buckets {
+			if time.Since(b.lastSeen) > idle {
+				delete(l.buckets, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+func writeRateLimited(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	writeJSON(w, http.StatusTooManyRequests, ErrorResponse{Error: "rate limit exceeded"})
+}
+
+// rateLimitIP wraps a handler with per-client-IP rate limiting.
+func rateLimitIP(limiter *rateLimiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := limiter.allow(clientIP(r)); !ok {
+			writeRateLimited(w, wait)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// rateLimitUser wraps an authenticated handler with per-user rate limiting.
+// It must sit inside requireApproved/requirePermission.
+func rateLimitUser(limiter *rateLimiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := userFromContext(r.Context())
+		if user != nil {
+			if ok, wait := limiter.allow(fmt.Sprintf("user:%d", user.ID)); !ok {
+				writeRateLimited(w, wait)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// Permissions. The first three double as API token scopes.
+const (
+	PermServersRead  = "servers:read"  // view own servers, logs and channel status
+	PermServersWrite = "servers:write" // create and delete own servers
+	PermPairingWrite = "pairing:write" // approve/deny pairing requests on own servers
+	PermServersAll   = "servers:all"   // read and act on any user's servers
+	PermUsersManage  = "users:manage"  // approve, delete and change roles of users
+)
+
+// Roles, from least to most privileged.
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleOwner    = "owner"
+	RoleAdmin    = "admin"
+)
+
+// DefaultRole is assigned to new users (other than the first, who is admin).
+const DefaultRole = RoleOwner
+
+var rolePermissions = map[string][]string{
+	RoleViewer:   {PermServersRead},
+	RoleOperator: {PermServersRead, PermPairingWrite},
+	RoleOwner:    {PermServersRead, PermPairingWrite, PermServersWrite},
+	RoleAdmin:    {PermServersRead, PermPairingWrite, PermServersWrite, PermServersAll, PermUsersManage},
+}
+
+var roleOrder = []string{RoleViewer, RoleOperator, RoleOwner, RoleAdmin}
+
+func validRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Can reports whether the user's role grants perm.
+func (u *User) Can(perm string) bool {
+	return slices.Contains(rolePermissions[u.Role], perm)
+}
+
+// requirePermission wraps a handler to require an approved user whose role
+// grants perm. API tokens must additionally carry perm as a scope.
+func (s *Server) requirePermission(perm string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, r := s.sessionAuth(r)
+		if user == nil {
+			writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "unauthorized"})
+			return
+		}
+		if !user.Approved {
+			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "account not approved"})
+			return
+		}
+		if !user.Can(perm) {
+			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "permission denied: " + perm})
+			return
+		}
+		if token := apiTokenFromContext(r.Context()); token != nil && !token.HasScope(perm) {
+			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "token missing scope " + perm})
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// requireServerPermission guards routes acting on a single server. Only
+// approval and token scope are checked here; the role check happens per
+// server in lookupServer, since org servers are governed by org roles.
+func (s *Server) requireServerPermission(perm string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, r := s.sessionAuth(r)
+		if user == nil {
+			writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "unauthorized"})
+			return
+		}
+		if !user.Approved {
+			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "account not approved"})
+			return
+		}
+		if token := apiTokenFromContext(r.Context()); token != nil && !token.HasScope(perm) {
+			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "token missing scope " + perm})
+			return
+		}
+		handler(w, r)
+	}
+}
+
+var errPermissionDenied = errors.New("permission denied")
+
+// lookupServer returns a server on which the user holds perm: a personal
+// server governed by their own role, an org server governed by their org
+// role, or any server if their role grants servers:all.
+func (s *Server) lookupServer(user *User, id int64, perm string) (*ServerInfo, error) {
+	if user.Can(PermServersAll) {
+		return s.store.GetServerAny(id)
+	}
+	info, orgRole, err := s.store.GetServerForUser(id, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	role := user.Role
+	if info.OrgID != 0 {
+		role = orgRole
+	}
+	if !slices.Contains(rolePermissions[role], perm) {
+		return nil, errPermissionDenied
+	}
+	return info, nil
+}
+
+// writeLookupError responds to a failed lookupServer.
+func writeLookupError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errPermissionDenied) {
+		writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "permission denied"})
+		return
+	}
+	writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "server not found"})
+}
+
+// Role management handlers
+
+func (s *Server) handleListRoles(w http.ResponseWriter, r *http.Request) {
+	type role struct {
+		Name        string   `json:"name"`
+		Permissions []string `json:"permissions"`
+	}
+	out := make([]role, len(roleOrder))
+	for i, name := range roleOrder {
+		out[i] = role{Name: name, Permissions: rolePermissions[name]}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) handleSetUserRole(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid user id"})
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !validRole(req.Role) {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "role must be one of viewer, operator, owner, admin"})
+		return
+	}
+
+	// Don't allow demoting yourself
+	admin := userFromContext(r.Context())
+	if admin != nil && admin.ID == id && req.Role != RoleAdmin {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "cannot demote yourself"})
+		return
+	}
+
+	if err := s.store.SetUserRole(id, req.Role); err != nil {
+		if err == sql.ErrNoRows {
+			writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "user not found"})
+			return
+		}
+		slog.Error("failed to set user role", "user_id", id, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to set role"})
+		return
+	}
+	slog.Info("user role changed", "user_id", id, "role", req.Role, "by", admin.ID)
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok", "role": req.Role})
+}
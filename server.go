@@ -4,11 +4,13 @@ import (
 	"crypto/rand"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"log/slog"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -26,57 +28,136 @@ type Server struct {
 	store       *Store
 	hub         *LogHub
 	challenges  *ChallengeStore
+	eth         *EthRPC // nil when ETH_RPC_URL is unset
+	oidc        *OIDCClient
+	tunnels     *tunnelRegistry
 	upgrader    websocket.Upgrader
+
+	authLimiter    *rateLimiter // per client IP
+	createLimiter  *rateLimiter // per user
+	pendingCreates *pendingCreates
 }
 
 func NewServer(cfg *Config, hetzner *HetznerClient, provisioner *Provisioner, store *Store, hub *LogHub) *Server {
-	return &Server{
+	var eth *EthRPC
+	if cfg.EthRPCURL != "" {
+		eth = NewEthRPC(cfg.EthRPCURL)
+	}
+	s := &Server{
 		config:      cfg,
 		hetzner:     hetzner,
 		provisioner: provisioner,
 		store:       store,
 		hub:         hub,
-		challenges:  NewChallengeStore(),
-		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool { return true },
-		},
-	}
+		challenges:  NewChallengeStore(store),
+		eth:         eth,
+		oidc:        NewOIDCClient(),
+		tunnels:     newTunnelRegistry(),
+		// 20 auth requests/min per IP, burst of 10
+		authLimiter: newRateLimiter(20.0/60, 10),
+		// 5 server creations/hour per user, burst of 3
+		createLimiter:  newRateLimiter(5.0/3600, 3),
+		pendingCreates: &pendingCreates{byUser: make(map[int64]int)},
+	}
+	s.upgrader = websocket.Upgrader{CheckOrigin: s.checkWebSocketOrigin}
+	return s
 }
 
 func (s *Server) Router() http.Handler {
 	mux := http.NewServeMux()
 
 	// Auth routes (public)
-	mux.HandleFunc("POST /auth/challenge", s.handleChallenge)
-	mux.HandleFunc("POST /auth/verify", s.handleVerify)
+	mux.HandleFunc("POST /auth/challenge", rateLimitIP(s.authLimiter, s.handleChallenge))
+	mux.HandleFunc("POST /auth/verify", rateLimitIP(s.authLimiter, s.handleVerify))
+	mux.HandleFunc("POST /auth/passkey/register/begin", rateLimitIP(s.authLimiter, s.handlePasskeyRegisterBegin))
+	mux.HandleFunc("POST /auth/passkey/register/finish", rateLimitIP(s.authLimiter, s.handlePasskeyRegisterFinish))
+	mux.HandleFunc("POST /auth/passkey/login/begin", rateLimitIP(s.authLimiter, s.handlePasskeyLoginBegin))
+	mux.HandleFunc("POST /auth/passkey/login/finish", rateLimitIP(s.authLimiter, s.handlePasskeyLoginFinish))
+	mux.HandleFunc("GET /auth/passkeys", s.requireApproved(s.handleListPasskeys))
+	mux.HandleFunc("DELETE /auth/passkeys/{id}", s.requireApproved(s.handleDeletePasskey))
+	mux.HandleFunc("GET /auth/oidc/providers", s.handleListSSOProviders)
+	mux.HandleFunc("GET /auth/oidc/{id}/login", rateLimitIP(s.authLimiter, s.handleOIDCLogin))
+	mux.HandleFunc("GET /auth/oidc/callback", rateLimitIP(s.authLimiter, s.handleOIDCCallback))
 	mux.HandleFunc("POST /auth/logout", s.handleLogout)
 	mux.HandleFunc("GET /auth/me", s.handleMe)
 	mux.HandleFunc("PUT /auth/ssh-key", s.requireApproved(s.handleSetSSHKey))
+	mux.HandleFunc("GET /auth/sessions", s.requireApproved(s.handleListSessions))
+	mux.HandleFunc("DELETE /auth/sessions", s.requireApproved(s.handleRevokeAllSessions))
+	mux.HandleFunc("DELETE /auth/sessions/{id}", s.requireApproved(s.handleRevokeSession))
 
 	// Admin routes (require admin)
 	mux.HandleFunc("GET /admin/users", s.requireAdmin(s.handleListUsers))
 	mux.HandleFunc("POST /admin/users/{id}/approve", s.requireAdmin(s.handleApproveUser))
 	mux.HandleFunc("DELETE /admin/users/{id}", s.requireAdmin(s.handleDeleteUser))
-
-	// Server routes (require approved user)
-	mux.HandleFunc("POST /servers", s.requireApproved(s.handleCreateServer))
-	mux.HandleFunc("GET /servers", s.requireApproved(s.handleListServers))
+	mux.HandleFunc("POST /admin/users/{id}/suspend", s.requireAdmin(s.handleSuspendUser))
+	mux.HandleFunc("POST /admin/users/{id}/unsuspend", s.requireAdmin(s.handleUnsuspendUser))
+	mux.HandleFunc("DELETE /admin/users/{id}/sessions", s.requireAdmin(s.handleAdminRevokeUserSessions))
+	mux.HandleFunc("PUT /admin/users/{id}/role", s.requireAdmin(s.handleSetUserRole))
+	mux.HandleFunc("GET /admin/roles", s.requireAdmin(s.handleListRoles))
+	mux.HandleFunc("GET /admin/quotas", s.requireAdmin(s.handleListRoleQuotas))
+	mux.HandleFunc("PUT /admin/roles/{role}/quota", s.requireAdmin(s.handleSetRoleQuota))
+	mux.HandleFunc("PUT /admin/users/{id}/quota", s.requireAdmin(s.handleSetUserQuota))
+	mux.HandleFunc("GET /admin/approval-rules", s.requireAdmin(s.handleListApprovalRules))
+	mux.HandleFunc("POST /admin/approval-rules", s.requireAdmin(s.handleCreateApprovalRule))
+	mux.HandleFunc("DELETE /admin/approval-rules/{id}", s.requireAdmin(s.handleDeleteApprovalRule))
+	mux.HandleFunc("GET /admin/invite-codes", s.requireAdmin(s.handleListInviteCodes))
+	mux.HandleFunc("POST /admin/invite-codes", s.requireAdmin(s.handleCreateInviteCode))
+	mux.HandleFunc("DELETE /admin/invite-codes/{id}", s.requireAdmin(s.handleDeleteInviteCode))
+	mux.HandleFunc("POST /admin/invites", s.requireAdmin(s.handleCreateInvite))
+	mux.HandleFunc("GET /admin/oidc-providers", s.requireAdmin(s.handleListOIDCProviders))
+	mux.HandleFunc("POST /admin/oidc-providers", s.requireAdmin(s.handleCreateOIDCProvider))
+	mux.HandleFunc("DELETE /admin/oidc-providers/{id}", s.requireAdmin(s.handleDeleteOIDCProvider))
+
+	// API tokens (session only)
+	mux.HandleFunc("POST /auth/tokens", s.requireApproved(s.handleCreateAPIToken))
+	mux.HandleFunc("GET /auth/tokens", s.requireApproved(s.handleListAPITokens))
+	mux.HandleFunc("DELETE /auth/tokens/{id}", s.requireApproved(s.handleRevokeAPIToken))
+
+	// Server routes (require a role with the given permission; API tokens also need it as a scope)
+	mux.HandleFunc("POST /servers", s.requireServerPermission(PermServersWrite, rateLimitUser(s.createLimiter, s.handleCreateServer)))
+	mux.HandleFunc("GET /servers", s.requirePermission(PermServersRead, s.handleListServers))
 	mux.HandleFunc("GET /servers/{id}/ws", s.handleWebSocket) // WS auth handled inline
-	mux.HandleFunc("POST /servers/{id}/public-key", s.requireApproved(s.handleSetPublicKey))
-	mux.HandleFunc("GET /servers/{id}/pairing/requests", s.requireApproved(s.handlePairingRequests))
-	mux.HandleFunc("POST /servers/{id}/pairing/approve", s.requireApproved(s.handlePairingApprove))
-	mux.HandleFunc("POST /servers/{id}/pairing/deny", s.requireApproved(s.handlePairingDeny))
-	mux.HandleFunc("GET /servers/{id}/channels/status", s.requireApproved(s.handleChannelsStatus))
-	mux.HandleFunc("GET /servers/{id}", s.requireApproved(s.handleGetServer))
-	mux.HandleFunc("DELETE /servers/{id}", s.requireApproved(s.handleDeleteServer))
+	mux.HandleFunc("GET /servers/{id}/logs", s.requireServerPermission(PermServersRead, s.handleServerLogs))
+	mux.HandleFunc("GET /servers/{id}/logs/live", s.handleLiveLogs) // WS auth handled inline
+	mux.HandleFunc("GET /servers/{id}/logs/download", s.requireServerPermission(PermServersRead, s.handleDownloadServerLogs))
+	mux.HandleFunc("POST /servers/{id}/public-key", s.requireServerPermission(PermServersWrite, s.handleSetPublicKey))
+	mux.HandleFunc("GET /servers/{id}/keys", s.requireServerPermission(PermServersRead, s.handleListNodeKeys))
+	mux.HandleFunc("DELETE /servers/{id}/keys/{label}", s.requireServerPermission(PermServersWrite, s.handleRevokeNodeKey))
+	mux.HandleFunc("GET /servers/{id}/pairing/requests", s.requireServerPermission(PermServersRead, s.handlePairingRequests))
+	mux.HandleFunc("POST /servers/{id}/pairing/approve", s.requireServerPermission(PermPairingWrite, s.handlePairingApprove))
+	mux.HandleFunc("POST /servers/{id}/pairing/deny", s.requireServerPermission(PermPairingWrite, s.handlePairingDeny))
+	mux.HandleFunc("GET /servers/{id}/metrics", s.requireServerPermission(PermServersRead, s.handleSystemMetrics))
+	mux.HandleFunc("GET /servers/{id}/metrics/history", s.requireServerPermission(PermServersRead, s.handleMetricsHistory))
+	mux.HandleFunc("GET /servers/{id}/services", s.requireServerPermission(PermServersRead, s.handleListServices))
+	mux.HandleFunc("GET /servers/{id}/services/{name}", s.requireServerPermission(PermServersRead, s.handleServiceStatus))
+	mux.HandleFunc("POST /servers/{id}/services/{name}/{action}", s.requireServerPermission(PermServersWrite, s.handleServiceAction))
+	mux.HandleFunc("GET /servers/{id}/actions", s.requireServerPermission(PermServersRead, s.handleListServerActions))
+	mux.HandleFunc("GET /servers/{id}/channels/status", s.requireServerPermission(PermServersRead, s.handleChannelsStatus))
+	mux.HandleFunc("POST /servers/{id}/channels", s.requireServerPermission(PermServersWrite, s.handleAddChannel))
+	mux.HandleFunc("PUT /servers/{id}/channels/{channel}/{account}", s.requireServerPermission(PermServersWrite, s.handleUpdateChannel))
+	mux.HandleFunc("POST /servers/{id}/channels/{channel}/{account}/disable", s.requireServerPermission(PermServersWrite, s.handleDisableChannel))
+	mux.HandleFunc("DELETE /servers/{id}/channels/{channel}/{account}", s.requireServerPermission(PermServersWrite, s.handleRemoveChannel))
+	mux.HandleFunc("GET /servers/{id}", s.requireServerPermission(PermServersRead, s.handleGetServer))
+	mux.HandleFunc("DELETE /servers/{id}", s.requireServerPermission(PermServersWrite, s.handleDeleteServer))
+	mux.HandleFunc("POST /servers/{id}/transfer", s.requireServerPermission(PermServersWrite, s.handleTransferServer))
+
+	// Organizations (session only)
+	mux.HandleFunc("POST /orgs", s.requireApproved(s.handleCreateOrg))
+	mux.HandleFunc("GET /orgs", s.requireApproved(s.handleListOrgs))
+	mux.HandleFunc("DELETE /orgs/{id}", s.requireApproved(s.handleDeleteOrg))
+	mux.HandleFunc("GET /orgs/{id}/members", s.requireApproved(s.handleListOrgMembers))
+	mux.HandleFunc("POST /orgs/{id}/members", s.requireApproved(s.handleAddOrgMember))
+	mux.HandleFunc("PUT /orgs/{id}/members/{user_id}", s.requireApproved(s.handleSetOrgMemberRole))
+	mux.HandleFunc("DELETE /orgs/{id}/members/{user_id}", s.requireApproved(s.handleRemoveOrgMember))
 
 	// Public config
 	mux.HandleFunc("GET /config", s.handleConfig)
+	mux.HandleFunc("GET /agent/connect", rateLimitIP(s.authLimiter, s.handleAgentConnect)) // agent auth handled inline
 
 	// SPA static files
 	mux.HandleFunc("GET /", s.handleSPA)
 
-	return mux
+	return s.resolveClientIP(s.trackSessions(s.requireSameOrigin(mux)))
 }
 
 func (s *Server) handleCreateServer(w http.ResponseWriter, r *http.Request) {
@@ -92,7 +173,43 @@ func (s *Server) handleCreateServer(w http.ResponseWriter, r *http.Request) {
 		req.Name = randomName()
 	}
 
-	info, err := s.hetzner.CreateServer(r.Context(), req.Name)
+	// Personal servers need the user's own role to allow it, org servers
+	// their org role
+	if req.OrgID == 0 && !user.Can(PermServersWrite) {
+		writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "permission denied: " + PermServersWrite})
+		return
+	}
+	if req.OrgID != 0 {
+		role, err := s.orgRole(user, req.OrgID)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "organization not found"})
+			return
+		}
+		if !slices.Contains(rolePermissions[role], PermServersWrite) {
+			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "permission denied: " + PermServersWrite})
+			return
+		}
+	}
+
+	if req.ServerType != "" && !serverTypeRegex.MatchString(req.ServerType) {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid server type"})
+		return
+	}
+
+	release, err := s.reserveServer(user, req.ServerType)
+	if err != nil {
+		var qerr *quotaError
+		if errors.As(err, &qerr) {
+			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: qerr.Error()})
+			return
+		}
+		slog.Error("failed to check quota", "user_id", user.ID, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "internal error"})
+		return
+	}
+	defer release()
+
+	info, err := s.hetzner.CreateServer(r.Context(), req.Name, req.ServerType)
 	if err != nil {
 		slog.Error("failed to create server", "error", err)
 		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
@@ -108,9 +225,10 @@ func (s *Server) handleCreateServer(w http.ResponseWriter, r *http.Request) {
 		WayfinderAPIKey:  req.WayfinderAPIKey,
 		Channels:         req.Channels,
 		CreatorPublicKey: req.PublicKeyPEM,
+		AgentURL:         s.agentURL(),
 	}
 
-	if err := s.store.CreateServer(info, opts, user.ID); err != nil {
+	if err := s.store.CreateServer(info, opts, user.ID, req.OrgID); err != nil {
 		slog.Error("failed to store server", "error", err)
 		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to persist server"})
 		return
@@ -164,6 +282,9 @@ func (s *Server) runProvision(id int64, opts ProvisionOpts, logFn func(string))
 	if result.WalletAddress != "" {
 		s.store.SetWalletAddress(id, result.WalletAddress)
 	}
+	if result.NodeAPIFingerprint != "" {
+		s.store.SetNodeAPIFingerprint(id, result.NodeAPIFingerprint)
+	}
 	if opts.SSHPublicKey != "" {
 		s.store.SetDefaultKeyRemoved(id, true)
 	}
@@ -180,9 +301,9 @@ func (s *Server) handleGetServer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	info, err := s.store.GetServer(id, user.ID)
+	info, err := s.lookupServer(user, id, PermServersRead)
 	if err != nil {
-		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "server not found"})
+		writeLookupError(w, err)
 		return
 	}
 
@@ -205,18 +326,16 @@ func (s *Server) handleDeleteServer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.store.DeleteServer(id, user.ID); err != nil {
-		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "server not found"})
+	if _, err := s.lookupServer(user, id, PermServersWrite); err != nil {
+		writeLookupError(w, err)
 		return
 	}
-
-	if err := s.hetzner.DeleteServer(r.Context(), id); err != nil {
-		slog.Error("failed to delete server from hetzner", "error", err)
-		// Server already deleted from DB, log the error but don't fail
+	// Hetzner failures are logged but don't fail the request once the DB row is gone
+	if err := s.destroyServer(r.Context(), id); err != nil {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "server not found"})
+		return
 	}
 
-	s.hub.Remove(id)
-
 	writeJSON(w, http.StatusOK, DeleteServerResponse{
 		ID:      id,
 		Deleted: true,
@@ -225,7 +344,15 @@ func (s *Server) handleDeleteServer(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) handleListServers(w http.ResponseWriter, r *http.Request) {
 	user := userFromContext(r.Context())
-	servers, err := s.store.ListServers(user.ID)
+
+	// Admins can list every user's servers with ?all=1
+	var servers []*ServerInfo
+	var err error
+	if r.URL.Query().Get("all") == "1" && user.Can(PermServersAll) {
+		servers, err = s.store.ListAllServers()
+	} else {
+		servers, err = s.store.ListServers(user.ID)
+	}
 	if err != nil {
 		slog.Error("failed to list servers", "error", err)
 		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to list servers"})
@@ -242,6 +369,10 @@ func (s *Server) handleListServers(w http.ResponseWriter, r *http.Request) {
 		HasNodeAPI        bool   `json:"has_node_api"`
 		CreatedAt         string `json:"created_at,omitempty"`
 		ChannelCount      int    `json:"channel_count"`
+		UserID            int64  `json:"user_id"`
+		OrgID             int64  `json:"org_id,omitempty"`
+		AgentOnline       bool   `json:"agent_online"`
+		AgentSeenAt       string `json:"agent_seen_at,omitempty"`
 	}
 	out := make([]item, len(servers))
 	for i, info := range servers {
@@ -256,6 +387,10 @@ func (s *Server) handleListServers(w http.ResponseWriter, r *http.Request) {
 			HasNodeAPI:        info.HasNodeAPI,
 			CreatedAt:         info.CreatedAt,
 			ChannelCount:      info.ChannelCount,
+			UserID:            info.UserID,
+			OrgID:             info.OrgID,
+			AgentOnline:       info.AgentOnline,
+			AgentSeenAt:       info.AgentSeenAt,
 		}
 	}
 	writeJSON(w, http.StatusOK, out)
@@ -268,6 +403,10 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
+	if token := apiTokenFromContext(r.Context()); token != nil && !token.HasScope(PermServersRead) {
+		http.Error(w, "token missing scope "+PermServersRead, http.StatusForbidden)
+		return
+	}
 
 	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
 	if err != nil {
@@ -275,7 +414,7 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	info, err := s.store.GetServer(id, user.ID)
+	info, err := s.lookupServer(user, id, PermServersRead)
 	if err != nil {
 		http.Error(w, "server not found", http.StatusNotFound)
 		return
@@ -317,15 +456,17 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		},
 	})
 
-	// Replay all logs
+	// Replay all logs, including any compacted into the archive
 	var lastLogID int64
-	logs, err := s.store.GetLogsSince(id, 0)
+	logs, err := s.store.ListLogs(id, LogFilter{})
 	if err == nil {
 		for _, entry := range logs {
 			if err := sendJSON(map[string]any{"type": "log", "line": entry.Line}); err != nil {
 				return
 			}
-			lastLogID = entry.ID
+			if entry.ID > 0 {
+				lastLogID = entry.ID
+			}
 		}
 	}
 
@@ -387,97 +528,153 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleSetPublicKey rotates the node API key: it authorizes a new key on the
+// node, in a request signed by a currently authorized key, and records it as
+// the server's current key. Revoke the old one with DELETE .../keys/{label}.
 func (s *Server) handleSetPublicKey(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
-	if err != nil {
-		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid server id"})
-		return
-	}
-
+	body, _ := io.ReadAll(io.LimitReader(r.Body, 4096))
 	var req struct {
+		Label        string `json:"label"`
 		PublicKeyPEM string `json:"public_key_pem"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PublicKeyPEM == "" {
-		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "public_key_pem is required"})
+	if err := json.Unmarshal(body, &req); err != nil || req.Label == "" || req.PublicKeyPEM == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "label and public_key_pem are required"})
 		return
 	}
 
-	if err := s.store.SetPublicKey(id, req.PublicKeyPEM); err != nil {
-		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to set public key"})
+	s.nodeAction(w, r, "POST", "/keys", body, "key.add", req.Label, func(id int64) {
+		if err := s.store.SetPublicKey(id, req.PublicKeyPEM); err != nil {
+			slog.Error("key authorized on node but not recorded", "server_id", id, "label", req.Label, "error", err)
+		}
+	})
+}
+
+func (s *Server) handleListNodeKeys(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	s.proxyToNode(w, r, id, PermServersRead, "GET", "/keys", nil)
+}
+
+func (s *Server) handleRevokeNodeKey(w http.ResponseWriter, r *http.Request) {
+	label := r.PathValue("label")
+	if strings.ContainsAny(label, "/?#%") {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid key label"})
 		return
 	}
-
-	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	s.nodeAction(w, r, "DELETE", "/keys/"+label, nil, "key.revoke", label, nil)
 }
 
 func (s *Server) handlePairingRequests(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
-	s.proxyToNode(w, r, id, "GET", "/pairing/requests", nil)
+	s.proxyToNode(w, r, id, PermServersRead, "GET", "/pairing/requests", nil)
 }
 
 func (s *Server) handlePairingApprove(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
 	body, _ := io.ReadAll(r.Body)
-	s.proxyToNode(w, r, id, "POST", "/pairing/approve", body)
+	s.proxyToNode(w, r, id, PermPairingWrite, "POST", "/pairing/approve", body)
 }
 
 func (s *Server) handlePairingDeny(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
 	body, _ := io.ReadAll(r.Body)
-	s.proxyToNode(w, r, id, "POST", "/pairing/deny", body)
+	s.proxyToNode(w, r, id, PermPairingWrite, "POST", "/pairing/deny", body)
 }
 
 func (s *Server) handleChannelsStatus(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
-	s.proxyToNode(w, r, id, "GET", "/channels/status", nil)
+	s.proxyToNode(w, r, id, PermServersRead, "GET", "/channels/status", nil)
 }
 
-func (s *Server) proxyToNode(w http.ResponseWriter, r *http.Request, serverID int64, method, path string, body []byte) {
-	user := userFromContext(r.Context())
-	info, err := s.store.GetServer(serverID, user.ID)
+func (s *Server) proxyToNode(w http.ResponseWriter, r *http.Request, serverID int64, perm, method, path string, body []byte) {
+	info, ok := s.nodeServer(w, r, serverID, perm)
+	if !ok {
+		return
+	}
+	resp, err := s.callNode(r, info, method, path, body)
 	if err != nil {
-		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "server not found"})
+		slog.Error("proxy to node failed", "server_id", serverID, "path", path, "error", err)
+		writeJSON(w, http.StatusBadGateway, ErrorResponse{Error: "node unreachable"})
 		return
 	}
+	defer resp.Body.Close()
+	relayNodeResponse(w, serverID, path, resp)
+}
+
+// nodeServer resolves a server whose node API the caller may use with perm.
+// It writes the error response and returns ok=false on failure, or when the
+// request was replayed to the machine holding the node's tunnel.
+func (s *Server) nodeServer(w http.ResponseWriter, r *http.Request, serverID int64, perm string) (*ServerInfo, bool) {
+	user := userFromContext(r.Context())
+	info, err := s.lookupServer(user, serverID, perm)
+	if err != nil {
+		writeLookupError(w, err)
+		return nil, false
+	}
 	if info.Status != "ready" {
 		writeJSON(w, http.StatusConflict, ErrorResponse{Error: "server is not ready"})
-		return
+		return nil, false
 	}
 	if !info.HasNodeAPI {
 		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "node API not deployed on this server"})
-		return
+		return nil, false
 	}
-
-	url := fmt.Sprintf("http://%s:8443%s", info.IPv4, path)
-
-	var bodyReader io.Reader
-	if body != nil {
-		bodyReader = strings.NewReader(string(body))
+	if s.replayToTunnel(w, r, info) {
+		return nil, false
 	}
+	return info, true
+}
 
-	proxyReq, err := http.NewRequest(method, url, bodyReader)
-	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to create proxy request"})
-		return
-	}
+// callNode forwards a browser-signed request to the node API, through the
+// node's agent tunnel when one is live.
+func (s *Server) callNode(r *http.Request, info *ServerInfo, method, path string, body []byte) (*http.Response, error) {
+	return s.nodeRequest(r, info, method, path, body, nodeClient)
+}
 
+// streamNode is callNode for responses that stream indefinitely; the
+// caller ends the stream by cancelling r's context.
+func (s *Server) streamNode(r *http.Request, info *ServerInfo, method, path string) (*http.Response, error) {
+	return s.nodeRequest(r, info, method, path, nil, nodeStreamClient)
+}
+
+func (s *Server) nodeRequest(r *http.Request, info *ServerInfo, method, path string, body []byte, client func(fingerprint string) *http.Client) (*http.Response, error) {
 	// Forward signature headers unchanged
-	for _, h := range []string{"X-Signature", "X-Signature-Timestamp", "X-Content-Digest", "X-Signature-Method", "Content-Type"} {
+	header := http.Header{}
+	for _, h := range []string{"X-Signature", "X-Signature-Timestamp", "X-Signature-Nonce", "X-Content-Digest", "X-Signature-Method", "Content-Type"} {
 		if v := r.Header.Get(h); v != "" {
-			proxyReq.Header.Set(h, v)
+			header.Set(h, v)
 		}
 	}
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(proxyReq)
+	// The query is part of the signed request, so it passes through verbatim
+	if t := s.tunnels.get(info.ID); t != nil {
+		return t.roundTrip(r.Context(), method, path, r.URL.RawQuery, header, body)
+	}
+
+	// Nodes provisioned before TLS was added still serve plain HTTP
+	scheme := "http"
+	if info.NodeAPIFingerprint != "" {
+		scheme = "https"
+	}
+	url := fmt.Sprintf("%s://%s:8443%s", scheme, info.IPv4, path)
+	if r.URL.RawQuery != "" {
+		url += "?" + r.URL.RawQuery
+	}
+
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = strings.NewReader(string(body))
+	}
+
+	proxyReq, err := http.NewRequestWithContext(r.Context(), method, url, bodyReader)
 	if err != nil {
-		slog.Error("proxy to node failed", "server_id", serverID, "url", url, "error", err)
-		writeJSON(w, http.StatusBadGateway, ErrorResponse{Error: "node unreachable"})
-		return
+		return nil, err
 	}
-	defer resp.Body.Close()
+	proxyReq.Header = header
+
+	return client(info.NodeAPIFingerprint).Do(proxyReq)
+}
 
-	// Forward response
+func relayNodeResponse(w http.ResponseWriter, serverID int64, path string, resp *http.Response) {
 	if resp.StatusCode >= 400 {
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		slog.Warn("node returned error", "server_id", serverID, "path", path, "status", resp.StatusCode, "body", string(bodyBytes))
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// Remote control of the openclaw daemon and gateway units on a node (see
+// nodeapi/services.go). Every start, stop and restart is recorded in the
+// server's audit log, whether or not the node carried it out.
+
+var (
+	nodeServices   = map[string]bool{"daemon": true, "gateway": true}
+	serviceActions = map[string]bool{"start": true, "stop": true, "restart": true}
+)
+
+const auditLogLimit = 100
+
+func (s *Server) handleListServices(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	s.proxyToNode(w, r, id, PermServersRead, "GET", "/services", nil)
+}
+
+func (s *Server) handleServiceStatus(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	name := r.PathValue("name")
+	if !nodeServices[name] {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "unknown service"})
+		return
+	}
+	s.proxyToNode(w, r, id, PermServersRead, "GET", "/services/"+name, nil)
+}
+
+func (s *Server) handleServiceAction(w http.ResponseWriter, r *http.Request) {
+	name, action := r.PathValue("name"), r.PathValue("action")
+	if !nodeServices[name] {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "unknown service"})
+		return
+	}
+	if !serviceActions[action] {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "action must be start, stop or restart"})
+		return
+	}
+	s.nodeAction(w, r, "POST", "/services/"+name+"/"+action, nil, "service."+action, name, nil)
+}
+
+// nodeAction forwards a signed, state-changing request to the node of the
+// server in the {id} path value, audits it whether or not the node was
+// reached, and relays the node's response. If the node accepted the change,
+// onSuccess (when set) records it on our side first.
+func (s *Server) nodeAction(w http.ResponseWriter, r *http.Request, method, path string, body []byte, action, target string, onSuccess func(serverID int64)) {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	info, ok := s.nodeServer(w, r, id, PermServersWrite)
+	if !ok {
+		return
+	}
+
+	resp, err := s.callNode(r, info, method, path, body)
+	if err != nil {
+		s.auditServerAction(r, id, action, target, 0)
+		slog.Error("proxy to node failed", "server_id", id, "path", path, "error", err)
+		writeJSON(w, http.StatusBadGateway, ErrorResponse{Error: "node unreachable"})
+		return
+	}
+	defer resp.Body.Close()
+
+	s.auditServerAction(r, id, action, target, resp.StatusCode)
+	if resp.StatusCode < 300 && onSuccess != nil {
+		onSuccess(id)
+	}
+	relayNodeResponse(w, id, path, resp)
+}
+
+// auditServerAction records a state-changing node call made by the request's
+// user. Failures are logged rather than failing the request: the node has
+// already acted.
+func (s *Server) auditServerAction(r *http.Request, serverID int64, action, target string, status int) {
+	user := userFromContext(r.Context())
+	slog.Info("server action", "server_id", serverID, "user_id", user.ID, "action", action, "target", target, "status", status)
+	if err := s.store.RecordServerAction(serverID, user.ID, action, target, status); err != nil {
+		slog.Error("failed to record server action", "server_id", serverID, "action", action, "error", err)
+	}
+}
+
+func (s *Server) handleListServerActions(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid server id"})
+		return
+	}
+	if _, err := s.lookupServer(user, id, PermServersRead); err != nil {
+		writeLookupError(w, err)
+		return
+	}
+
+	actions, err := s.store.ListServerActions(id, auditLogLimit)
+	if err != nil {
+		slog.Error("failed to list server actions", "server_id", id, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to load audit log"})
+		return
+	}
+	writeJSON(w, http.StatusOK, actions)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	sessionCookieName = "session"
+	sessionTTL        = 30 * 24 * time.Hour
+	// Sessions get a fresh id this often; the old id stays valid briefly so
+	// in-flight requests don't fail.
+	sessionRotateInterval = 24 * time.Hour
+	sessionRotateGrace    = 1 * time.Minute
+	// Minimum interval between last-seen/expiry writes for a session
+	sessionTouchInterval = 1 * time.Minute
+)
+
+const sessionContextKey contextKey = "session"
+
+func sessionFromContext(ctx context.Context) *Session {
+	sess, _ := ctx.Value(sessionContextKey).(*Session)
+	return sess
+}
+
+const clientIPContextKey contextKey = "client_ip"
+
+// clientIP returns the caller's IP: the one resolveClientIP took from Fly's
+// proxy, else the connection's.
+func clientIP(r *http.Request) string {
+	if ip, _ := r.Context().Value(clientIPContextKey).(string); ip != "" {
+		return ip
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// resolveClientIP wraps the router to take the caller's IP from the
+// Fly-Client-IP header. Only Fly's proxy sets it, so it's trusted only when
+// running on Fly (FLY_MACHINE_ID is set); elsewhere anyone could forge it.
+func (s *Server) resolveClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.config.MachineID != "" {
+			if ip := r.Header.Get("Fly-Client-IP"); ip != "" {
+				r = r.WithContext(context.WithValue(r.Context(), clientIPContextKey, ip))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func setSessionCookie(w http.ResponseWriter, r *http.Request, sessionID string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    sessionID,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(sessionTTL.Seconds()),
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+		Expires:  time.Unix(0, 0),
+	})
+}
+
+// trackSessions wraps the router to resolve the session cookie, record
+// session activity, slide the expiry forward and periodically rotate the
+// session id. The session is passed on in the request context, where
+// sessionAuth picks it up.
+func (s *Server) trackSessions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(sessionCookieName)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		session, err := s.store.GetSession(cookie.Value)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// A WebSocket upgrade can't carry a Set-Cookie, so never rotate on one
+		createdAt, _ := time.Parse(time.RFC3339Nano, session.CreatedAt)
+		if !session.Replaced && time.Since(createdAt) > sessionRotateInterval && !websocket.IsWebSocketUpgrade(r) {
+			rotated, err := s.store.RotateSession(session.ID, sessionRotateGrace)
+			if err != nil {
+				slog.Error("failed to rotate session", "user_id", session.UserID, "error", err)
+			} else if rotated != nil {
+				setSessionCookie(w, r, rotated.ID)
+				session = rotated
+			}
+		}
+
+		lastSeen, _ := time.Parse(time.RFC3339Nano, session.LastSeenAt)
+		if !session.Replaced && time.Since(lastSeen) > sessionTouchInterval {
+			s.store.TouchSession(session.ID, r.UserAgent(), clientIP(r), sessionTTL)
+			if !websocket.IsWebSocketUpgrade(r) {
+				setSessionCookie(w, r, session.ID)
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), sessionContextKey, session)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Session handlers
+
+func (s *Server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+
+	sessions, err := s.store.ListSessions(user.ID)
+	if err != nil {
+		slog.Error("failed to list sessions", "user_id", user.ID, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to list sessions"})
+		return
+	}
+	if current := sessionFromContext(r.Context()); current != nil {
+		for _, sess := range sessions {
+			sess.Current = sess.PublicID == current.PublicID
+		}
+	}
+	if sessions == nil {
+		sessions = []*Session{}
+	}
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+func (s *Server) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+	publicID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid session id"})
+		return
+	}
+
+	if err := s.store.DeleteSessionByPublicID(publicID, user.ID); err != nil {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "session not found"})
+		return
+	}
+	if current := sessionFromContext(r.Context()); current != nil && current.PublicID == publicID {
+		clearSessionCookie(w, r)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "revoked"})
+}
+
+// handleRevokeAllSessions logs the user out everywhere, including here.
+func (s *Server) handleRevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+
+	n, err := s.store.DeleteUserSessions(user.ID)
+	if err != nil {
+		slog.Error("failed to revoke sessions", "user_id", user.ID, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to revoke sessions"})
+		return
+	}
+	clearSessionCookie(w, r)
+	slog.Info("revoked all sessions", "user_id", user.ID, "count", n)
+
+	writeJSON(w, http.StatusOK, map[string]any{"status": "revoked", "count": n})
+}
+
+func (s *Server) handleAdminRevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid user id"})
+		return
+	}
+
+	n, err := s.store.DeleteUserSessions(id)
+	if err != nil {
+		slog.Error("failed to revoke user sessions", "user_id", id, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to revoke sessions"})
+		return
+	}
+	slog.Info("admin revoked user sessions", "user_id", id, "count", n)
+
+	writeJSON(w, http.StatusOK, map[string]any{"status": "revoked", "count": n})
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name      string
+		machineID string
+		header    string
+		want      string
+	}{
+		{name: "on Fly uses the proxy header", machineID: "148e21ea7e5589", header: "203.0.113.7", want: "203.0.113.7"},
+		{name: "on Fly without the header", machineID: "148e21ea7e5589", want: "192.0.2.1"},
+		{name: "off Fly ignores a forged header", header: "203.0.113.7", want: "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{config: &Config{MachineID: tt.machineID}}
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = "192.0.2.1:41234"
+			if tt.header != "" {
+				req.Header.Set("Fly-Client-IP", tt.header)
+			}
+			var got string
+			s.resolveClientIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = clientIP(r)
+			})).ServeHTTP(httptest.NewRecorder(), req)
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// EIP-4361 (Sign-In with Ethereum) messages.
+// See https://eips.ethereum.org/EIPS/eip-4361
+
+const (
+	siweHeaderSuffix = " wants you to sign in with your Ethereum account:"
+	siweStatement    = "Sign in to openclaw creator"
+	siweVersion      = "1"
+	siweTTL          = 5 * time.Minute
+	siweClockSkew    = 1 * time.Minute
+)
+
+type SIWEMessage struct {
+	Domain         string
+	Address        string // EIP-55 checksummed
+	Statement      string
+	URI            string
+	Version        string
+	ChainID        int64
+	Nonce          string
+	IssuedAt       time.Time
+	ExpirationTime time.Time
+	NotBefore      time.Time // optional
+	RequestID      string    // optional
+	Resources      []string  // optional
+}
+
+func newSIWEMessage(domain, uri, address string, chainID int64) (*SIWEMessage, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	return &SIWEMessage{
+		Domain:         domain,
+		Address:        common.HexToAddress(address).Hex(),
+		Statement:      siweStatement,
+		URI:            uri,
+		Version:        siweVersion,
+		ChainID:        chainID,
+		Nonce:          hex.EncodeToString(b),
+		IssuedAt:       now,
+		ExpirationTime: now.Add(siweTTL),
+	}, nil
+}
+
+// String renders the message in the exact EIP-4361 layout wallets expect.
+func (m *SIWEMessage) String() string {
+	var b strings.Builder
+	b.WriteString(m.Domain + siweHeaderSuffix + "\n")
+	b.WriteString(m.Address + "\n\n")
+	if m.Statement != "" {
+		b.WriteString(m.Statement + "\n")
+	}
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "URI: %s\n", m.URI)
+	fmt.Fprintf(&b, "Version: %s\n", m.Version)
+	fmt.Fprintf(&b, "Chain ID: %d\n", m.ChainID)
+	fmt.Fprintf(&b, "Nonce: %s\n", m.Nonce)
+	fmt.Fprintf(&b, "Issued At: %s", m.IssuedAt.Format(time.RFC3339))
+	if !m.ExpirationTime.IsZero() {
+		fmt.Fprintf(&b, "\nExpiration Time: %s", m.ExpirationTime.Format(time.RFC3339))
+	}
+	if !m.NotBefore.IsZero() {
+		fmt.Fprintf(&b, "\nNot Before: %s", m.NotBefore.Format(time.RFC3339))
+	}
+	if m.RequestID != "" {
+		fmt.Fprintf(&b, "\nRequest ID: %s", m.RequestID)
+	}
+	if len(m.Resources) > 0 {
+		b.WriteString("\nResources:")
+		for _, res := range m.Resources {
+			fmt.Fprintf(&b, "\n- %s", res)
+		}
+	}
+	return b.String()
+}
+
+// parseSIWEMessage parses an EIP-4361 message. Fields must appear in the
+// order defined by the spec.
+func parseSIWEMessage(msg string) (*SIWEMessage, error) {
+	lines := strings.Split(msg, "\n")
+	if len(lines) < 8 {
+		return nil, fmt.Errorf("message too short")
+	}
+
+	var m SIWEMessage
+
+	domain, ok := strings.CutSuffix(lines[0], siweHeaderSuffix)
+	if !ok || domain == "" {
+		return nil, fmt.Errorf("invalid header line")
+	}
+	m.Domain = domain
+
+	if !common.IsHexAddress(lines[1]) || !strings.HasPrefix(lines[1], "0x") {
+		return nil, fmt.Errorf("invalid address")
+	}
+	if common.HexToAddress(lines[1]).Hex() != lines[1] {
+		return nil, fmt.Errorf("address is not EIP-55 checksummed")
+	}
+	m.Address = lines[1]
+
+	if lines[2] != "" {
+		return nil, fmt.Errorf("expected blank line after address")
+	}
+
+	// Optional statement followed by a blank line
+	i := 3
+	if lines[i] != "" {
+		m.Statement = lines[i]
+		i++
+	}
+	if i >= len(lines) || lines[i] != "" {
+		return nil, fmt.Errorf("expected blank line before URI")
+	}
+	i++
+
+	field := func(name string, required bool) (string, error) {
+		if i < len(lines) {
+			if v, ok := strings.CutPrefix(lines[i], name+": "); ok {
+				i++
+				return v, nil
+			}
+		}
+		if required {
+			return "", fmt.Errorf("missing %s", name)
+		}
+		return "", nil
+	}
+	parseTime := func(name, v string) (time.Time, error) {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		return t, nil
+	}
+
+	var err error
+	if m.URI, err = field("URI", true); err != nil {
+		return nil, err
+	}
+	if _, err := url.Parse(m.URI); err != nil {
+		return nil, fmt.Errorf("invalid URI: %w", err)
+	}
+	if m.Version, err = field("Version", true); err != nil {
+		return nil, err
+	}
+	chainID, err := field("Chain ID", true)
+	if err != nil {
+		return nil, err
+	}
+	if m.ChainID, err = strconv.ParseInt(chainID, 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid Chain ID: %w", err)
+	}
+	if m.Nonce, err = field("Nonce", true); err != nil {
+		return nil, err
+	}
+	if len(m.Nonce) < 8 {
+		return nil, fmt.Errorf("nonce too short")
+	}
+	issuedAt, err := field("Issued At", true)
+	if err != nil {
+		return nil, err
+	}
+	if m.IssuedAt, err = parseTime("Issued At", issuedAt); err != nil {
+		return nil, err
+	}
+	if v, _ := field("Expiration Time", false); v != "" {
+		if m.ExpirationTime, err = parseTime("Expiration Time", v); err != nil {
+			return nil, err
+		}
+	}
+	if v, _ := field("Not Before", false); v != "" {
+		if m.NotBefore, err = parseTime("Not Before", v); err != nil {
+			return nil, err
+		}
+	}
+	m.RequestID, _ = field("Request ID", false)
+	if i < len(lines) && lines[i] == "Resources:" {
+		i++
+		for i < len(lines) {
+			res, ok := strings.CutPrefix(lines[i], "- ")
+			if !ok {
+				break
+			}
+			m.Resources = append(m.Resources, res)
+			i++
+		}
+	}
+	if i != len(lines) {
+		return nil, fmt.Errorf("unexpected content: %q", lines[i])
+	}
+
+	return &m, nil
+}
+
+// Validate checks the message is bound to this deployment and currently valid.
+func (m *SIWEMessage) Validate(domain, uri string, chainID int64, now time.Time) error {
+	if m.Domain != domain {
+		return fmt.Errorf("domain mismatch: %s", m.Domain)
+	}
+	if m.URI != uri {
+		return fmt.Errorf("URI mismatch: %s", m.URI)
+	}
+	if m.Version != siweVersion {
+		return fmt.Errorf("unsupported version: %s", m.Version)
+	}
+	if m.ChainID != chainID {
+		return fmt.Errorf("chain id mismatch: %d", m.ChainID)
+	}
+	if m.IssuedAt.After(now.Add(siweClockSkew)) {
+		return fmt.Errorf("issued in the future")
+	}
+	if m.ExpirationTime.IsZero() {
+		return fmt.Errorf("missing expiration time")
+	}
+	if !now.Before(m.ExpirationTime) {
+		return fmt.Errorf("message expired")
+	}
+	if !m.NotBefore.IsZero() && now.Before(m.NotBefore) {
+		return fmt.Errorf("message not yet valid")
+	}
+	return nil
+}
+
+// siweOrigin returns the domain and URI sign-in messages must be bound to:
+// those of PUBLIC_URL, never the client-controlled Host or X-Forwarded-Proto.
+func (s *Server) siweOrigin() (domain, uri string) {
+	u, err := url.Parse(s.config.PublicURL)
+	if err != nil {
+		return "", ""
+	}
+	return u.Host, u.Scheme + "://" + u.Host
+}
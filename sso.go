@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OIDC single sign-on for organizations. An admin registers an identity
+// provider for an org; users signing in through it are approved, joined to
+// the org with a role mapped from their ID token claims, and given the same
+// session cookie as wallet and passkey users. Accounts it creates are only
+// viewers outside the org. A signed-in user can link an
+// identity to their existing account with ?link=1.
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcStateTTL    = 10 * time.Minute
+)
+
+// ssoUserRole is the global role of accounts created by SSO sign-in.
+const ssoUserRole = RoleViewer
+
+var errIdentityTaken = errors.New("this identity is linked to another account")
+
+// oidcRedirectURI is the callback registered with every provider.
+func (s *Server) oidcRedirectURI() string {
+	return s.config.PublicURL + "/auth/oidc/callback"
+}
+
+// oidcRole maps a user's claims to an org role: the highest role any claim
+// value maps to, else the provider's default. "" means sign-in is denied.
+func oidcRole(p *OIDCProvider, claims map[string]any) string {
+	var values []string
+	if p.RoleClaim != "" {
+		switch v := claims[p.RoleClaim].(type) {
+		case string:
+			values = append(values, v)
+		case []any:
+			for _, item := range v {
+				if s, ok := item.(string); ok {
+					values = append(values, s)
+				}
+			}
+		}
+	}
+
+	best := -1
+	for _, v := range values {
+		if i := slices.Index(orgRoles, p.RoleMap[v]); i > best {
+			best = i
+		}
+	}
+	if best >= 0 {
+		return orgRoles[best]
+	}
+	return p.DefaultRole
+}
+
+func newOIDCState() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// handleListSSOProviders lists sign-in options for the connect page.
+func (s *Server) handleListSSOProviders(w http.ResponseWriter, r *http.Request) {
+	providers, err := s.store.ListOIDCProviders()
+	if err != nil {
+		slog.Error("failed to list oidc providers", "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "internal error"})
+		return
+	}
+
+	type option struct {
+		ID   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+	out := make([]option, 0, len(providers))
+	for _, p := range providers {
+		out = append(out, option{ID: p.ID, Name: p.Name})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// handleOIDCLogin starts the authorization code flow and redirects to the
+// provider.
+func (s *Server) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid provider id"})
+		return
+	}
+	p, err := s.store.GetOIDCProvider(id)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "provider not found"})
+		return
+	}
+	d, err := s.oidc.Discover(r.Context(), p.Issuer)
+	if err != nil {
+		slog.Error("oidc discovery failed", "provider_id", p.ID, "error", err)
+		writeJSON(w, http.StatusBadGateway, ErrorResponse{Error: "identity provider unavailable"})
+		return
+	}
+
+	st := &OIDCState{ProviderID: p.ID, ExpiresAt: time.Now().Add(oidcStateTTL)}
+	if r.URL.Query().Get("link") == "1" {
+		user, r := s.sessionAuth(r)
+		if user == nil || apiTokenFromContext(r.Context()) != nil {
+			writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "sign in to link an identity"})
+			return
+		}
+		st.UserID = user.ID
+	}
+
+	state, err1 := newOIDCState()
+	nonce, err2 := newOIDCState()
+	verifier, challenge, err3 := newPKCE()
+	if err1 != nil || err2 != nil || err3 != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "internal error"})
+		return
+	}
+	st.Nonce, st.Verifier = nonce, verifier
+	if err := s.store.CreateOIDCState(state, st); err != nil {
+		slog.Error("failed to store oidc state", "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "internal error"})
+		return
+	}
+
+	// Binds the callback to this browser, so a state value can't be replayed
+	// from elsewhere. Lax so it survives the top-level redirect back.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/auth/oidc",
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(oidcStateTTL.Seconds()),
+	})
+
+	scopes := p.Scopes
+	if !slices.Contains(scopes, "openid") {
+		scopes = append([]string{"openid"}, scopes...)
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {s.oidcRedirectURI()},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+	target := d.AuthorizationEndpoint
+	if strings.Contains(target, "?") {
+		target += "&" + q.Encode()
+	} else {
+		target += "?" + q.Encode()
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// handleOIDCCallback completes the flow. Failures redirect to the app with
+// ?sso_error so the connect page can show them.
+func (s *Server) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	fail := func(msg string) {
+		http.Redirect(w, r, "/?sso_error="+url.QueryEscape(msg), http.StatusFound)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/auth/oidc",
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		slog.Warn("oidc provider returned error", "error", e, "description", q.Get("error_description"))
+		fail("sign-in was cancelled or refused")
+		return
+	}
+	state := q.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if state == "" || err != nil || cookie.Value != state {
+		fail("sign-in session expired, please try again")
+		return
+	}
+	st, err := s.store.ConsumeOIDCState(state)
+	if err != nil || time.Now().After(st.ExpiresAt) {
+		fail("sign-in session expired, please try again")
+		return
+	}
+
+	p, err := s.store.GetOIDCProvider(st.ProviderID)
+	if err != nil {
+		fail("identity provider not found")
+		return
+	}
+	subject, email, role, err := s.oidcClaims(r.Context(), p, st, q.Get("code"), s.oidcRedirectURI())
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+
+	user, err := s.oidcUser(p, st, subject, email)
+	if err != nil {
+		if errors.Is(err, errIdentityTaken) {
+			fail(err.Error())
+			return
+		}
+		slog.Error("oidc user resolution failed", "provider_id", p.ID, "subject", subject, "error", err)
+		fail("internal error")
+		return
+	}
+	// The provider vouches for org members, so accounts it created need no
+	// separate approval. An account linked with ?link=1 may hold a role with
+	// global rights (personal servers) the provider can't vouch for, so it
+	// keeps waiting for an admin.
+	if !user.Approved && !user.Suspended && user.Role == ssoUserRole {
+		if err := s.store.ApproveUser(user.ID); err != nil {
+			slog.Error("failed to approve sso user", "user_id", user.ID, "error", err)
+			fail("internal error")
+			return
+		}
+		user.Approved = true
+	}
+	if _, err := s.startSession(w, r, user, ""); err != nil {
+		fail(err.Error())
+		return
+	}
+	s.syncOrgRole(p.OrgID, user.ID, role)
+
+	slog.Info("oidc sign-in", "user_id", user.ID, "provider_id", p.ID, "org_id", p.OrgID, "role", role)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// oidcClaims redeems the callback's code, verifies the ID token and maps its
+// claims to an org role. Errors are *signInError, safe to show the user.
+func (s *Server) oidcClaims(ctx context.Context, p *OIDCProvider, st *OIDCState, code, redirectURI string) (subject, email, role string, err error) {
+	d, err := s.oidc.Discover(ctx, p.Issuer)
+	if err != nil {
+		slog.Error("oidc discovery failed", "provider_id", p.ID, "error", err)
+		return "", "", "", &signInError{http.StatusBadGateway, "identity provider unavailable"}
+	}
+	rawIDToken, err := s.oidc.ExchangeCode(ctx, d, p, code, st.Verifier, redirectURI)
+	if err != nil {
+		slog.Warn("oidc code exchange failed", "provider_id", p.ID, "error", err)
+		return "", "", "", &signInError{http.StatusUnauthorized, "sign-in failed"}
+	}
+	claims, err := s.oidc.VerifyIDToken(ctx, d, p, rawIDToken, st.Nonce)
+	if err != nil {
+		slog.Warn("oidc id token rejected", "provider_id", p.ID, "error", err)
+		return "", "", "", &signInError{http.StatusUnauthorized, "sign-in failed"}
+	}
+	subject, _ = claims["sub"].(string)
+	email, _ = claims["email"].(string)
+
+	role = oidcRole(p, claims)
+	if role == "" {
+		slog.Warn("oidc sign-in denied: no role mapped", "provider_id", p.ID, "subject", subject)
+		return "", "", "", &signInError{http.StatusForbidden, "your account is not permitted to sign in here"}
+	}
+	return subject, email, role, nil
+}
+
+// oidcUser finds the user linked to subject, links it to the user who started
+// the flow, or creates a new account.
+func (s *Server) oidcUser(p *OIDCProvider, st *OIDCState, subject, email string) (*User, error) {
+	userID, err := s.store.GetOIDCIdentity(p.ID, subject)
+	switch {
+	case err == nil:
+		if st.UserID != 0 && st.UserID != userID {
+			return nil, errIdentityTaken
+		}
+		return s.store.GetUserByID(userID)
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	}
+
+	var user *User
+	if st.UserID != 0 {
+		user, err = s.store.GetUserByID(st.UserID)
+	} else {
+		user, err = s.newSSOUser()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.LinkOIDCIdentity(p.ID, subject, user.ID, email); err != nil {
+		return nil, err
+	}
+	slog.Info("oidc identity linked", "user_id", user.ID, "provider_id", p.ID, "subject", subject)
+	return user, nil
+}
+
+// newSSOUser creates the account for a first SSO sign-in. The provider only
+// speaks for its org, so the account gets ssoUserRole globally: no personal
+// servers, with what it may do in the org left to the mapped org role.
+func (s *Server) newSSOUser() (*User, error) {
+	user, err := s.store.CreateUser("", "")
+	if err != nil || user.Role != DefaultRole {
+		return user, err // the first user is admin
+	}
+	if err := s.store.SetUserRole(user.ID, ssoUserRole); err != nil {
+		return nil, err
+	}
+	user.Role = ssoUserRole
+	return user, nil
+}
+
+// syncOrgRole applies the mapped org role on each sign-in, so changes at the
+// provider take effect. The org's last owner is never demoted.
+func (s *Server) syncOrgRole(orgID, userID int64, role string) {
+	current, err := s.store.GetOrgRole(orgID, userID)
+	if err == nil && current == role {
+		return
+	}
+	if current == RoleOwner && !s.hasOtherOwner(orgID) {
+		return
+	}
+	if err := s.store.SetOrgMember(orgID, userID, role); err != nil {
+		slog.Error("failed to set org role from sso", "org_id", orgID, "user_id", userID, "error", err)
+	}
+}
+
+// Admin handlers
+
+func (s *Server) handleListOIDCProviders(w http.ResponseWriter, r *http.Request) {
+	providers, err := s.store.ListOIDCProviders()
+	if err != nil {
+		slog.Error("failed to list oidc providers", "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "internal error"})
+		return
+	}
+	for _, p := range providers {
+		p.ClientSecret = ""
+	}
+	if providers == nil {
+		providers = []*OIDCProvider{}
+	}
+	writeJSON(w, http.StatusOK, providers)
+}
+
+func (s *Server) handleCreateOIDCProvider(w http.ResponseWriter, r *http.Request) {
+	var p OIDCProvider
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+		return
+	}
+	p.Name = strings.TrimSpace(p.Name)
+	p.Issuer = strings.TrimRight(strings.TrimSpace(p.Issuer), "/")
+	if p.Name == "" || p.ClientID == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "name and client_id are required"})
+		return
+	}
+	if u, err := url.Parse(p.Issuer); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "issuer must be an http(s) URL"})
+		return
+	}
+	if ok, err := s.store.OrgExists(p.OrgID); err != nil || !ok {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "organization not found"})
+		return
+	}
+	if p.DefaultRole != "" && !slices.Contains(orgRoles, p.DefaultRole) {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "default_role must be one of " + strings.Join(orgRoles, ", ")})
+		return
+	}
+	for value, role := range p.RoleMap {
+		if !slices.Contains(orgRoles, role) {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "role_map[" + value + "] must be one of " + strings.Join(orgRoles, ", ")})
+			return
+		}
+	}
+	if len(p.RoleMap) > 0 && p.RoleClaim == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "role_claim is required with role_map"})
+		return
+	}
+	if len(p.Scopes) == 0 {
+		p.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	// Fail early on a mistyped issuer rather than at first sign-in
+	if _, err := s.oidc.Discover(r.Context(), p.Issuer); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := s.store.CreateOIDCProvider(&p); err != nil {
+		slog.Error("failed to create oidc provider", "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to create provider"})
+		return
+	}
+	slog.Info("oidc provider created", "provider_id", p.ID, "org_id", p.OrgID, "issuer", p.Issuer)
+
+	p.ClientSecret = ""
+	writeJSON(w, http.StatusCreated, p)
+}
+
+func (s *Server) handleDeleteOIDCProvider(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid provider id"})
+		return
+	}
+	if err := s.store.DeleteOIDCProvider(id); err != nil {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "provider not found"})
+		return
+	}
+	slog.Info("oidc provider deleted", "provider_id", id)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// OIDC provider operations
+
+const oidcProviderColumns = `id, org_id, name, issuer, client_id, client_secret, scopes, role_claim, role_map, default_role, created_at`
+
+func scanOIDCProvider(row interface{ Scan(...any) error }) (*OIDCProvider, error) {
+	var p OIDCProvider
+	var scopes, roleMap string
+	err := row.Scan(&p.ID, &p.OrgID, &p.Name, &p.Issuer, &p.ClientID, &p.ClientSecret,
+		&scopes, &p.RoleClaim, &roleMap, &p.DefaultRole, &p.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	p.Scopes = splitList(scopes)
+	if err := json.Unmarshal([]byte(roleMap), &p.RoleMap); err != nil {
+		return nil, fmt.Errorf("provider %d role_map: %w", p.ID, err)
+	}
+	return &p, nil
+}
+
+func (s *Store) CreateOIDCProvider(p *OIDCProvider) error {
+	roleMap, err := json.Marshal(p.RoleMap)
+	if err != nil {
+		return err
+	}
+	return s.db.QueryRow(`
+		INSERT INTO oidc_providers (org_id, name, issuer, client_id, client_secret, scopes, role_claim, role_map, default_role)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at
+	`, p.OrgID, p.Name, p.Issuer, p.ClientID, p.ClientSecret, strings.Join(p.Scopes, ","),
+		p.RoleClaim, string(roleMap), p.DefaultRole).Scan(&p.ID, &p.CreatedAt)
+}
+
+func (s *Store) GetOIDCProvider(id int64) (*OIDCProvider, error) {
+	return scanOIDCProvider(s.db.QueryRow(`SELECT `+oidcProviderColumns+` FROM oidc_providers WHERE id=$1`, id))
+}
+
+func (s *Store) ListOIDCProviders() ([]*OIDCProvider, error) {
+	rows, err := s.db.Query(`SELECT ` + oidcProviderColumns + ` FROM oidc_providers ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var providers []*OIDCProvider
+	for rows.Next() {
+		p, err := scanOIDCProvider(rows)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+	return providers, rows.Err()
+}
+
+func (s *Store) DeleteOIDCProvider(id int64) error {
+	result, err := s.db.Exec(`DELETE FROM oidc_providers WHERE id=$1`, id)
+	if err != nil {
+		return err
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("provider not found")
+	}
+	return nil
+}
+
+// OIDC identity operations
+
+// GetOIDCIdentity returns the user linked to an issuer subject.
+func (s *Store) GetOIDCIdentity(providerID int64, subject string) (int64, error) {
+	var userID int64
+	err := s.db.QueryRow(`
+		SELECT user_id FROM oidc_identities WHERE provider_id=$1 AND subject=$2
+	`, providerID, subject).Scan(&userID)
+	return userID, err
+}
+
+func (s *Store) CountOIDCIdentities(userID int64) (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM oidc_identities WHERE user_id=$1`, userID).Scan(&n)
+	return n, err
+}
+
+func (s *Store) LinkOIDCIdentity(providerID int64, subject string, userID int64, email string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO oidc_identities (provider_id, subject, user_id, email) VALUES ($1, $2, $3, $4)
+	`, providerID, subject, userID, email)
+	return err
+}
+
+// OIDC login state operations
+
+// OIDCState is an in-flight authorization request, keyed by its state value.
+type OIDCState struct {
+	ProviderID int64
+	Nonce      string
+	Verifier   string
+	UserID     int64 // signed-in user linking an identity; 0 for sign-in
+	ExpiresAt  time.Time
+}
+
+func (s *Store) CreateOIDCState(state string, st *OIDCState) error {
+	var userID sql.NullInt64
+	if st.UserID != 0 {
+		userID = sql.NullInt64{Int64: st.UserID, Valid: true}
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO oidc_states (state, provider_id, nonce, verifier, user_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, state, st.ProviderID, st.Nonce, st.Verifier, userID, st.ExpiresAt)
+	return err
+}
+
+// ConsumeOIDCState atomically deletes and returns a login state.
+func (s *Store) ConsumeOIDCState(state string) (*OIDCState, error) {
+	var st OIDCState
+	var userID sql.NullInt64
+	err := s.db.QueryRow(`
+		DELETE FROM oidc_states WHERE state=$1
+		RETURNING provider_id, nonce, verifier, user_id, expires_at
+	`, state).Scan(&st.ProviderID, &st.Nonce, &st.Verifier, &userID, &st.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	st.UserID = userID.Int64
+	return &st, nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOIDCRole(t *testing.T) {
+	p := &OIDCProvider{
+		RoleClaim: "groups",
+		RoleMap:   map[string]string{"eng": RoleOperator, "leads": RoleOwner, "contractors": RoleViewer},
+	}
+	tests := []struct {
+		name        string
+		groups      any
+		defaultRole string
+		want        string
+	}{
+		{name: "string claim", groups: "eng", want: RoleOperator},
+		{name: "highest of several", groups: []any{"contractors", "leads", "eng"}, want: RoleOwner},
+		{name: "unmapped uses default", groups: []any{"sales"}, defaultRole: RoleViewer, want: RoleViewer},
+		{name: "unmapped without default is denied", groups: []any{"sales"}, want: ""},
+		{name: "missing claim uses default", groups: nil, defaultRole: RoleOperator, want: RoleOperator},
+		{name: "non-string values ignored", groups: []any{42, "eng"}, want: RoleOperator},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p.DefaultRole = tt.defaultRole
+			claims := map[string]any{"sub": "u"}
+			if tt.groups != nil {
+				claims["groups"] = tt.groups
+			}
+			if got := oidcRole(p, claims); got != tt.want {
+				t.Fatalf("oidcRole = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestOIDCClaims runs the callback's exchange and verification against the
+// mock issuer.
+func TestOIDCClaims(t *testing.T) {
+	m := newMockIssuer(t)
+	key := m.addKey("k1")
+	s := &Server{oidc: NewOIDCClient()}
+	p := &OIDCProvider{
+		ID:        1,
+		Issuer:    m.srv.URL,
+		ClientID:  "creator",
+		RoleClaim: "groups",
+		RoleMap:   map[string]string{"eng": RoleOperator},
+	}
+	st := &OIDCState{ProviderID: 1, Nonce: "nonce-1", Verifier: "verifier-1"}
+	const redirectURI = "https://creator.example/auth/oidc/callback"
+
+	callback := func(code string, claims map[string]any) (string, string, error) {
+		m.issue(code, m.sign("k1", key, claims))
+		subject, _, role, err := s.oidcClaims(context.Background(), p, st, code, redirectURI)
+		return subject, role, err
+	}
+	wantRefusal := func(t *testing.T, err error, msg string) {
+		t.Helper()
+		var serr *signInError
+		if !errors.As(err, &serr) || serr.msg != msg {
+			t.Fatalf("got error %v, want sign-in refusal %q", err, msg)
+		}
+	}
+
+	t.Run("role mapped", func(t *testing.T) {
+		claims := m.claims("creator", "nonce-1")
+		claims["groups"] = []string{"eng"}
+		subject, role, err := callback("c1", claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if subject != "user-123" || role != RoleOperator {
+			t.Fatalf("got subject %q role %q", subject, role)
+		}
+	})
+	t.Run("no role mapped", func(t *testing.T) {
+		claims := m.claims("creator", "nonce-1")
+		claims["groups"] = []string{"sales"}
+		_, _, err := callback("c2", claims)
+		wantRefusal(t, err, "your account is not permitted to sign in here")
+	})
+	t.Run("nonce mismatch", func(t *testing.T) {
+		claims := m.claims("creator", "nonce-from-another-login")
+		claims["groups"] = []string{"eng"}
+		_, _, err := callback("c3", claims)
+		wantRefusal(t, err, "sign-in failed")
+	})
+	t.Run("wrong aud", func(t *testing.T) {
+		claims := m.claims("another-client", "nonce-1")
+		claims["groups"] = []string{"eng"}
+		_, _, err := callback("c4", claims)
+		wantRefusal(t, err, "sign-in failed")
+	})
+	t.Run("unknown code", func(t *testing.T) {
+		_, _, _, err := s.oidcClaims(context.Background(), p, st, "never-issued", redirectURI)
+		wantRefusal(t, err, "sign-in failed")
+	})
+}
+
+// TestOIDCCallback drives the whole callback, including account creation and
+// the org role, against a real database. Set TEST_DATABASE_URL to run it.
+func TestOIDCCallback(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	store, err := NewStore(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newMockIssuer(t)
+	key := m.addKey("k1")
+	s := NewServer(&Config{PublicURL: "https://creator.example"}, nil, nil, store, nil)
+
+	// Make sure SSO users aren't the first (admin) account
+	admin, err := store.CreateUser("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	org, err := store.CreateOrg("sso-test", admin.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &OIDCProvider{
+		OrgID:     org.ID,
+		Name:      "mock",
+		Issuer:    m.srv.URL,
+		ClientID:  "creator",
+		Scopes:    []string{"openid"},
+		RoleClaim: "groups",
+		RoleMap:   map[string]string{"eng": RoleOperator},
+	}
+	if err := store.CreateOIDCProvider(p); err != nil {
+		t.Fatal(err)
+	}
+
+	// callback stores a login state and hits the callback with the ID token
+	// the issuer returns for it.
+	callback := func(t *testing.T, subject, nonce string, linkUserID int64) *http.Response {
+		t.Helper()
+		state, _ := newOIDCState()
+		st := &OIDCState{ProviderID: p.ID, UserID: linkUserID, Nonce: "nonce-" + state, Verifier: "v", ExpiresAt: time.Now().Add(time.Minute)}
+		if err := store.CreateOIDCState(state, st); err != nil {
+			t.Fatal(err)
+		}
+		if nonce == "" {
+			nonce = st.Nonce
+		}
+		claims := m.claims("creator", nonce)
+		claims["sub"] = subject
+		claims["groups"] = []string{"eng"}
+		m.issue("code-"+state, m.sign("k1", key, claims))
+
+		req := httptest.NewRequest("GET", "/auth/oidc/callback?"+url.Values{"state": {state}, "code": {"code-" + state}}.Encode(), nil)
+		req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: state})
+		rec := httptest.NewRecorder()
+		s.handleOIDCCallback(rec, req)
+		return rec.Result()
+	}
+	sessionCookie := func(resp *http.Response) *http.Cookie {
+		for _, c := range resp.Cookies() {
+			if c.Name == sessionCookieName && c.Value != "" {
+				return c
+			}
+		}
+		return nil
+	}
+
+	subject := "sub-" + time.Now().Format("150405.000000000")
+	t.Run("sign-in creates a viewer with the mapped org role", func(t *testing.T) {
+		resp := callback(t, subject, "", 0)
+		if loc := resp.Header.Get("Location"); loc != "/" {
+			t.Fatalf("redirected to %q", loc)
+		}
+		cookie := sessionCookie(resp)
+		if cookie == nil {
+			t.Fatal("no session cookie")
+		}
+		session, err := store.GetSession(cookie.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user, err := store.GetUserByID(session.UserID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Role != ssoUserRole || !user.Approved || user.Can(PermServersWrite) {
+			t.Fatalf("sso user has role %q approved=%t", user.Role, user.Approved)
+		}
+		if role, err := store.GetOrgRole(org.ID, user.ID); err != nil || role != RoleOperator {
+			t.Fatalf("org role %q (%v), want %q", role, err, RoleOperator)
+		}
+	})
+	t.Run("nonce mismatch", func(t *testing.T) {
+		resp := callback(t, subject, "stale-nonce", 0)
+		if loc := resp.Header.Get("Location"); !strings.Contains(loc, "sso_error=") {
+			t.Fatalf("redirected to %q, want an sso_error", loc)
+		}
+		if sessionCookie(resp) != nil {
+			t.Fatal("session issued despite nonce mismatch")
+		}
+	})
+	t.Run("suspended user", func(t *testing.T) {
+		user, err := store.GetUserByID(mustIdentity(t, store, p.ID, subject))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SetUserSuspended(user.ID, true); err != nil {
+			t.Fatal(err)
+		}
+		resp := callback(t, subject, "", 0)
+		if loc := resp.Header.Get("Location"); !strings.Contains(loc, "suspended") {
+			t.Fatalf("redirected to %q, want account suspended", loc)
+		}
+		if sessionCookie(resp) != nil {
+			t.Fatal("session issued to a suspended user")
+		}
+	})
+	t.Run("linking doesn't approve an account with global rights", func(t *testing.T) {
+		wallet, err := store.CreateUser("", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		callback(t, subject+"-linked", "", wallet.ID)
+		user, err := store.GetUserByID(wallet.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Approved || user.Role != DefaultRole {
+			t.Fatalf("linked account has role %q approved=%t", user.Role, user.Approved)
+		}
+	})
+}
+
+func mustIdentity(t *testing.T, store *Store, providerID int64, subject string) int64 {
+	t.Helper()
+	id, err := store.GetOIDCIdentity(providerID, subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
@@ -52,6 +53,19 @@ func (s *Store) Migrate() error {
 		);
 		CREATE INDEX IF NOT EXISTS idx_server_logs_server_id ON server_logs(server_id);
 		ALTER TABLE servers ADD COLUMN IF NOT EXISTS public_key TEXT NOT NULL DEFAULT '';
+		ALTER TABLE servers ADD COLUMN IF NOT EXISTS node_api_fingerprint TEXT NOT NULL DEFAULT '';
+		ALTER TABLE servers ADD COLUMN IF NOT EXISTS agent_seen_at TIMESTAMPTZ;
+		ALTER TABLE servers ADD COLUMN IF NOT EXISTS agent_machine TEXT NOT NULL DEFAULT '';
+
+		-- Slack's app token used to be sent as its account, which kept it out
+		-- of ClearChannelTokens
+		UPDATE servers SET channels = (
+			SELECT jsonb_agg(CASE WHEN ch->>'type' = 'slack' AND ch->>'account' LIKE 'xapp-%' THEN ch - 'account' ELSE ch END)
+			FROM jsonb_array_elements(channels) AS ch
+		) WHERE EXISTS (
+			SELECT 1 FROM jsonb_array_elements(channels) AS ch
+			WHERE ch->>'type' = 'slack' AND ch->>'account' LIKE 'xapp-%'
+		);
 
 		CREATE TABLE IF NOT EXISTS users (
 			id BIGSERIAL PRIMARY KEY,
@@ -73,7 +87,9 @@ func (s *Store) Migrate() error {
 
 		ALTER TABLE users ADD COLUMN IF NOT EXISTS address TEXT NOT NULL DEFAULT '';
 		ALTER TABLE users ADD COLUMN IF NOT EXISTS public_key TEXT NOT NULL DEFAULT '';
-		CREATE UNIQUE INDEX IF NOT EXISTS idx_users_address ON users(address);
+		-- Passkey-only users have no address, so only set addresses are unique
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_users_address_set ON users(address) WHERE address != '';
+		DROP INDEX IF EXISTS idx_users_address;
 
 		-- Drop legacy email/password auth constraints
 		ALTER TABLE users ALTER COLUMN email DROP NOT NULL;
@@ -81,6 +97,188 @@ func (s *Store) Migrate() error {
 		ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
 
 		ALTER TABLE users ADD COLUMN IF NOT EXISTS ssh_public_key TEXT NOT NULL DEFAULT '';
+
+		-- Compacted logs of finished servers: one gzipped text blob per server
+		CREATE TABLE IF NOT EXISTS server_log_archives (
+			server_id BIGINT PRIMARY KEY REFERENCES servers(id) ON DELETE CASCADE,
+			data BYTEA NOT NULL,
+			line_count INTEGER NOT NULL DEFAULT 0,
+			archived_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+
+		CREATE TABLE IF NOT EXISTS api_tokens (
+			id BIGSERIAL PRIMARY KEY,
+			user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			prefix TEXT NOT NULL,
+			scopes TEXT NOT NULL DEFAULT '',
+			expires_at TIMESTAMPTZ,
+			last_used_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+		CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id);
+
+		CREATE TABLE IF NOT EXISTS auth_challenges (
+			key TEXT PRIMARY KEY,
+			address TEXT NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+		CREATE INDEX IF NOT EXISTS idx_auth_challenges_expires_at ON auth_challenges(expires_at);
+
+		ALTER TABLE sessions ADD COLUMN IF NOT EXISTS public_id BIGSERIAL;
+		ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_agent TEXT NOT NULL DEFAULT '';
+		ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip TEXT NOT NULL DEFAULT '';
+		ALTER TABLE sessions ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now();
+		ALTER TABLE sessions ADD COLUMN IF NOT EXISTS replaced BOOLEAN NOT NULL DEFAULT false;
+		CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+
+		-- Legacy "user" role predates RBAC; it could create and manage its own servers
+		UPDATE users SET role='owner' WHERE role='user';
+		ALTER TABLE users ALTER COLUMN role SET DEFAULT 'owner';
+
+		CREATE TABLE IF NOT EXISTS organizations (
+			id BIGSERIAL PRIMARY KEY,
+			name TEXT NOT NULL,
+			created_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+		CREATE TABLE IF NOT EXISTS org_members (
+			org_id BIGINT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+			user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			role TEXT NOT NULL DEFAULT 'viewer',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (org_id, user_id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_org_members_user_id ON org_members(user_id);
+		ALTER TABLE servers ADD COLUMN IF NOT EXISTS org_id BIGINT REFERENCES organizations(id) ON DELETE SET NULL;
+
+		CREATE TABLE IF NOT EXISTS approval_rules (
+			id BIGSERIAL PRIMARY KEY,
+			type TEXT NOT NULL,
+			address TEXT NOT NULL,
+			min_balance TEXT NOT NULL DEFAULT '',
+			note TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+		CREATE TABLE IF NOT EXISTS invite_codes (
+			id BIGSERIAL PRIMARY KEY,
+			code_hash TEXT NOT NULL UNIQUE,
+			created_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+			redeemed_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+			redeemed_at TIMESTAMPTZ,
+			expires_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+
+		-- Invite links: invite codes with a role, server quota and use count
+		ALTER TABLE invite_codes ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT '';
+		ALTER TABLE invite_codes ADD COLUMN IF NOT EXISTS max_servers INT;
+		ALTER TABLE invite_codes ADD COLUMN IF NOT EXISTS max_uses INT NOT NULL DEFAULT 1;
+		ALTER TABLE invite_codes ADD COLUMN IF NOT EXISTS uses INT NOT NULL DEFAULT 0;
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS max_servers INT;
+
+		-- Quotas: per-role defaults, overridden per user (NULL inherits)
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS max_provisioning INT;
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS server_types TEXT;
+		CREATE TABLE IF NOT EXISTS role_quotas (
+			role TEXT PRIMARY KEY,
+			max_servers INT,
+			max_provisioning INT,
+			server_types TEXT NOT NULL DEFAULT ''
+		);
+
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_at TIMESTAMPTZ;
+		-- Deleting a user must not fail on (or orphan) their servers; the
+		-- offboarding flow deals with them first
+		ALTER TABLE servers DROP CONSTRAINT IF EXISTS servers_user_id_fkey;
+		ALTER TABLE servers ADD CONSTRAINT servers_user_id_fkey
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
+
+		-- WebAuthn passkeys
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS webauthn_handle BYTEA;
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_users_webauthn_handle ON users(webauthn_handle);
+		CREATE TABLE IF NOT EXISTS passkeys (
+			id BIGSERIAL PRIMARY KEY,
+			user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			credential_id BYTEA NOT NULL UNIQUE,
+			public_key BYTEA NOT NULL,
+			sign_count BIGINT NOT NULL DEFAULT 0,
+			name TEXT NOT NULL DEFAULT '',
+			last_used_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+		CREATE INDEX IF NOT EXISTS idx_passkeys_user_id ON passkeys(user_id);
+		CREATE TABLE IF NOT EXISTS webauthn_ceremonies (
+			challenge TEXT PRIMARY KEY,
+			kind TEXT NOT NULL,
+			user_id BIGINT REFERENCES users(id) ON DELETE CASCADE,
+			user_handle BYTEA,
+			expires_at TIMESTAMPTZ NOT NULL
+		);
+
+		-- OIDC single sign-on
+		CREATE TABLE IF NOT EXISTS oidc_providers (
+			id BIGSERIAL PRIMARY KEY,
+			org_id BIGINT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			issuer TEXT NOT NULL,
+			client_id TEXT NOT NULL,
+			client_secret TEXT NOT NULL DEFAULT '',
+			scopes TEXT NOT NULL DEFAULT 'openid,email,profile',
+			role_claim TEXT NOT NULL DEFAULT '',
+			role_map TEXT NOT NULL DEFAULT '{}',
+			default_role TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+		CREATE TABLE IF NOT EXISTS oidc_identities (
+			provider_id BIGINT NOT NULL REFERENCES oidc_providers(id) ON DELETE CASCADE,
+			subject TEXT NOT NULL,
+			user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			email TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (provider_id, subject)
+		);
+		CREATE TABLE IF NOT EXISTS oidc_states (
+			state TEXT PRIMARY KEY,
+			provider_id BIGINT NOT NULL REFERENCES oidc_providers(id) ON DELETE CASCADE,
+			nonce TEXT NOT NULL,
+			verifier TEXT NOT NULL,
+			user_id BIGINT REFERENCES users(id) ON DELETE CASCADE,
+			expires_at TIMESTAMPTZ NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS server_metrics (
+			id BIGSERIAL PRIMARY KEY,
+			server_id BIGINT NOT NULL REFERENCES servers(id) ON DELETE CASCADE,
+			sampled_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			uptime_seconds DOUBLE PRECISION NOT NULL,
+			cpu_count INTEGER NOT NULL,
+			cpu_percent DOUBLE PRECISION NOT NULL,
+			load_1 DOUBLE PRECISION NOT NULL,
+			load_5 DOUBLE PRECISION NOT NULL,
+			load_15 DOUBLE PRECISION NOT NULL,
+			mem_total BIGINT NOT NULL,
+			mem_available BIGINT NOT NULL,
+			swap_total BIGINT NOT NULL,
+			swap_free BIGINT NOT NULL,
+			disk_total BIGINT NOT NULL,
+			disk_free BIGINT NOT NULL,
+			processes INTEGER NOT NULL,
+			daemon_processes INTEGER NOT NULL,
+			gateway_processes INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_server_metrics_server_id_sampled_at ON server_metrics(server_id, sampled_at);
+		CREATE TABLE IF NOT EXISTS server_actions (
+			id BIGSERIAL PRIMARY KEY,
+			server_id BIGINT NOT NULL REFERENCES servers(id) ON DELETE CASCADE,
+			user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+			action TEXT NOT NULL,
+			target TEXT NOT NULL DEFAULT '',
+			status INTEGER NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+		CREATE INDEX IF NOT EXISTS idx_server_actions_server_id ON server_actions(server_id);
 	`)
 	return err
 }
@@ -105,59 +303,142 @@ func (s *Store) FailStaleProvisioningServers() {
 	}
 }
 
-func (s *Store) CreateServer(info *ServerInfo, opts ProvisionOpts, userID int64) error {
+func (s *Store) CreateServer(info *ServerInfo, opts ProvisionOpts, userID, orgID int64) error {
 	channelsJSON, err := json.Marshal(opts.Channels)
 	if err != nil {
 		channelsJSON = []byte("[]")
 	}
+	var org sql.NullInt64
+	if orgID != 0 {
+		org = sql.NullInt64{Int64: orgID, Valid: true}
+	}
 	_, err = s.db.Exec(`
-		INSERT INTO servers (id, name, ipv4, status, provisioned, ssh_public_key, anthropic_api_key, openai_api_key, gemini_api_key, wayfinder_api_key, channels, public_key, user_id)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		INSERT INTO servers (id, name, ipv4, status, provisioned, ssh_public_key, anthropic_api_key, openai_api_key, gemini_api_key, wayfinder_api_key, channels, public_key, user_id, org_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
 	`, info.ID, info.Name, info.IPv4, info.Status, info.Provisioned,
-		opts.SSHPublicKey, opts.AnthropicAPIKey, opts.OpenAIAPIKey, opts.GeminiAPIKey, opts.WayfinderAPIKey, channelsJSON, opts.CreatorPublicKey, userID)
+		opts.SSHPublicKey, opts.AnthropicAPIKey, opts.OpenAIAPIKey, opts.GeminiAPIKey, opts.WayfinderAPIKey, channelsJSON, opts.CreatorPublicKey, userID, org)
 	return err
 }
 
-func (s *Store) GetServer(id, userID int64) (*ServerInfo, error) {
-	var info ServerInfo
-	var channelsJSON []byte
-	err := s.db.QueryRow(`
-		SELECT id, name, ipv4, status, provisioned, wallet_address, default_key_removed,
-		       (public_key != '') AS has_node_api, created_at, channels
-		FROM servers WHERE id=$1 AND user_id=$2
-	`, id, userID).Scan(&info.ID, &info.Name, &info.IPv4, &info.Status, &info.Provisioned,
-		&info.WalletAddress, &info.DefaultKeyRemoved, &info.HasNodeAPI, &info.CreatedAt, &channelsJSON)
+// ListPersonalServerIDs returns the ids of the user's servers outside any org.
+func (s *Store) ListPersonalServerIDs(userID int64) ([]int64, error) {
+	rows, err := s.db.Query(`SELECT id FROM servers WHERE user_id=$1 AND org_id IS NULL ORDER BY id`, userID)
 	if err != nil {
 		return nil, err
 	}
-	if len(channelsJSON) > 0 {
-		var ch []any
-		if json.Unmarshal(channelsJSON, &ch) == nil {
-			info.ChannelCount = len(ch)
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
 		}
+		ids = append(ids, id)
 	}
-	return &info, nil
+	return ids, rows.Err()
 }
 
-// GetServerAny retrieves a server without user scoping (for WebSocket, internal use)
-func (s *Store) GetServerAny(id int64) (*ServerInfo, error) {
+// TransferServer moves a server to another user (making it personal) or,
+// with orgID set, into an org.
+func (s *Store) TransferServer(id, userID, orgID int64) error {
+	var err error
+	if orgID != 0 {
+		_, err = s.db.Exec(`UPDATE servers SET org_id=$1 WHERE id=$2`, orgID, id)
+	} else {
+		_, err = s.db.Exec(`UPDATE servers SET user_id=$1, org_id=NULL WHERE id=$2`, userID, id)
+	}
+	return err
+}
+
+const serverColumns = `servers.id, servers.name, servers.ipv4, servers.status, servers.provisioned,
+		       servers.wallet_address, servers.default_key_removed, (servers.public_key != '') AS has_node_api,
+		       servers.created_at, servers.channels, COALESCE(servers.user_id, 0), COALESCE(servers.org_id, 0),
+		       servers.node_api_fingerprint, servers.agent_seen_at, servers.agent_machine,
+		       COALESCE(servers.agent_seen_at > now() - interval '90 seconds', false) AS agent_online`
+
+func scanServer(row interface{ Scan(...any) error }) (*ServerInfo, error) {
 	var info ServerInfo
-	err := s.db.QueryRow(`
-		SELECT id, name, ipv4, status, provisioned, wallet_address, default_key_removed, (public_key != '') AS has_node_api
-		FROM servers WHERE id=$1
-	`, id).Scan(&info.ID, &info.Name, &info.IPv4, &info.Status, &info.Provisioned, &info.WalletAddress, &info.DefaultKeyRemoved, &info.HasNodeAPI)
+	var channelsJSON []byte
+	var agentSeenAt sql.NullString
+	err := row.Scan(&info.ID, &info.Name, &info.IPv4, &info.Status, &info.Provisioned,
+		&info.WalletAddress, &info.DefaultKeyRemoved, &info.HasNodeAPI, &info.CreatedAt, &channelsJSON, &info.UserID, &info.OrgID,
+		&info.NodeAPIFingerprint, &agentSeenAt, &info.AgentMachine, &info.AgentOnline)
 	if err != nil {
 		return nil, err
 	}
+	info.AgentSeenAt = agentSeenAt.String
+	info.ChannelCount = countChannels(channelsJSON)
 	return &info, nil
 }
 
+func countChannels(channelsJSON []byte) int {
+	var ch []any
+	if len(channelsJSON) > 0 && json.Unmarshal(channelsJSON, &ch) == nil {
+		return len(ch)
+	}
+	return 0
+}
+
+func (s *Store) GetServer(id, userID int64) (*ServerInfo, error) {
+	return scanServer(s.db.QueryRow(`
+		SELECT `+serverColumns+`
+		FROM servers WHERE id=$1 AND user_id=$2
+	`, id, userID))
+}
+
+// GetServerForUser retrieves a server the user can see: a personal server
+// they own (org_id unset), or a server of an org they belong to. orgRole is
+// the user's role in the server's org, if any.
+func (s *Store) GetServerForUser(id, userID int64) (info *ServerInfo, orgRole string, err error) {
+	var role, agentSeenAt sql.NullString
+	var server ServerInfo
+	var channelsJSON []byte
+	err = s.db.QueryRow(`
+		SELECT `+serverColumns+`, m.role
+		FROM servers
+		LEFT JOIN org_members m ON m.org_id = servers.org_id AND m.user_id = $2
+		WHERE servers.id=$1 AND ((servers.org_id IS NULL AND servers.user_id=$2) OR m.user_id IS NOT NULL)
+	`, id, userID).Scan(&server.ID, &server.Name, &server.IPv4, &server.Status, &server.Provisioned,
+		&server.WalletAddress, &server.DefaultKeyRemoved, &server.HasNodeAPI, &server.CreatedAt, &channelsJSON,
+		&server.UserID, &server.OrgID, &server.NodeAPIFingerprint, &agentSeenAt, &server.AgentMachine, &server.AgentOnline, &role)
+	if err != nil {
+		return nil, "", err
+	}
+	server.AgentSeenAt = agentSeenAt.String
+	server.ChannelCount = countChannels(channelsJSON)
+	return &server, role.String, nil
+}
+
+// GetServerAny retrieves a server without user scoping (for WebSocket, admins, internal use)
+func (s *Store) GetServerAny(id int64) (*ServerInfo, error) {
+	return scanServer(s.db.QueryRow(`
+		SELECT `+serverColumns+`
+		FROM servers WHERE id=$1
+	`, id))
+}
+
+// ListServers lists the user's personal servers and those of their orgs.
 func (s *Store) ListServers(userID int64) ([]*ServerInfo, error) {
-	rows, err := s.db.Query(`
-		SELECT id, name, ipv4, status, provisioned, wallet_address, default_key_removed,
-		       (public_key != '') AS has_node_api, created_at, channels
-		FROM servers WHERE user_id=$1 ORDER BY created_at DESC
+	return s.queryServers(`
+		SELECT `+serverColumns+`
+		FROM servers
+		WHERE (org_id IS NULL AND user_id=$1)
+		   OR org_id IN (SELECT org_id FROM org_members WHERE user_id=$1)
+		ORDER BY created_at DESC
 	`, userID)
+}
+
+// ListAllServers lists every user's servers (admin use).
+func (s *Store) ListAllServers() ([]*ServerInfo, error) {
+	return s.queryServers(`
+		SELECT ` + serverColumns + `
+		FROM servers ORDER BY created_at DESC
+	`)
+}
+
+func (s *Store) queryServers(query string, args ...any) ([]*ServerInfo, error) {
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -165,19 +446,11 @@ func (s *Store) ListServers(userID int64) ([]*ServerInfo, error) {
 
 	var servers []*ServerInfo
 	for rows.Next() {
-		var info ServerInfo
-		var channelsJSON []byte
-		if err := rows.Scan(&info.ID, &info.Name, &info.IPv4, &info.Status, &info.Provisioned,
-			&info.WalletAddress, &info.DefaultKeyRemoved, &info.HasNodeAPI, &info.CreatedAt, &channelsJSON); err != nil {
+		info, err := scanServer(rows)
+		if err != nil {
 			return nil, err
 		}
-		if len(channelsJSON) > 0 {
-			var ch []any
-			if json.Unmarshal(channelsJSON, &ch) == nil {
-				info.ChannelCount = len(ch)
-			}
-		}
-		servers = append(servers, &info)
+		servers = append(servers, info)
 	}
 	return servers, rows.Err()
 }
@@ -196,6 +469,30 @@ func (s *Store) SetWalletAddress(id int64, addr string) {
 	}
 }
 
+func (s *Store) SetNodeAPIFingerprint(id int64, fingerprint string) {
+	_, err := s.db.Exec(`UPDATE servers SET node_api_fingerprint=$1 WHERE id=$2`, fingerprint, id)
+	if err != nil {
+		slog.Error("failed to set node API fingerprint", "server_id", id, "error", err)
+	}
+}
+
+// GetServerIDByNodeFingerprint finds the server whose node API presents the
+// TLS key with this fingerprint.
+func (s *Store) GetServerIDByNodeFingerprint(fingerprint string) (int64, error) {
+	var id int64
+	err := s.db.QueryRow(`SELECT id FROM servers WHERE node_api_fingerprint=$1 AND node_api_fingerprint != ''`, fingerprint).Scan(&id)
+	return id, err
+}
+
+// SetAgentSeen records that the server's agent tunnel is live right now, on
+// the creator machine named machine.
+func (s *Store) SetAgentSeen(id int64, machine string) {
+	_, err := s.db.Exec(`UPDATE servers SET agent_seen_at=now(), agent_machine=$2 WHERE id=$1`, id, machine)
+	if err != nil {
+		slog.Error("failed to set agent_seen_at", "server_id", id, "error", err)
+	}
+}
+
 func (s *Store) SetDefaultKeyRemoved(id int64, removed bool) {
 	_, err := s.db.Exec(`UPDATE servers SET default_key_removed=$1 WHERE id=$2`, removed, id)
 	if err != nil {
@@ -217,6 +514,54 @@ func (s *Store) GetPublicKey(id int64) (string, error) {
 	return key, err
 }
 
+// GetServerSecrets returns the API keys and channel tokens deployed to a
+// server, for redacting them from its logs.
+func (s *Store) GetServerSecrets(id int64) ([]string, error) {
+	var opts ProvisionOpts
+	var channelsJSON []byte
+	err := s.db.QueryRow(`
+		SELECT anthropic_api_key, openai_api_key, gemini_api_key, wayfinder_api_key, channels
+		FROM servers WHERE id=$1
+	`, id).Scan(&opts.AnthropicAPIKey, &opts.OpenAIAPIKey, &opts.GeminiAPIKey, &opts.WayfinderAPIKey, &channelsJSON)
+	if err != nil {
+		return nil, err
+	}
+	json.Unmarshal(channelsJSON, &opts.Channels)
+	return collectSecrets(opts), nil
+}
+
+// UpdateServerChannels applies fn to a server's recorded channel list under
+// a row lock, so concurrent changes don't overwrite each other. Tokens are
+// never recorded: like provisioning's (see ClearChannelTokens), they live
+// only on the node.
+func (s *Store) UpdateServerChannels(id int64, fn func([]ChannelConfig) []ChannelConfig) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var channelsJSON []byte
+	if err := tx.QueryRow(`SELECT channels FROM servers WHERE id=$1 FOR UPDATE`, id).Scan(&channelsJSON); err != nil {
+		return err
+	}
+	var channels []ChannelConfig
+	json.Unmarshal(channelsJSON, &channels)
+
+	channels = fn(channels)
+	for i := range channels {
+		channels[i].Token, channels[i].AppToken = "", ""
+	}
+	channelsJSON, err = json.Marshal(channels)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE servers SET channels=$1 WHERE id=$2`, channelsJSON, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func (s *Store) AppendLog(id int64, line string) error {
 	_, err := s.db.Exec(`INSERT INTO server_logs (server_id, line) VALUES ($1, $2)`, id, line)
 	if err != nil {
@@ -247,15 +592,173 @@ func (s *Store) GetLogsSince(serverID, afterID int64) ([]LogEntry, error) {
 	return logs, rows.Err()
 }
 
+// ListLogs returns a server's full log history (archived lines first, then live
+// rows) filtered by f. The time and text filters run in SQL; the archive is
+// only decoded when the since window reaches back into it, which it can't if
+// it was compacted before f.Since.
+func (s *Store) ListLogs(serverID int64, f LogFilter) ([]LogEntry, error) {
+	var logs []LogEntry
+
+	var since any
+	if !f.Since.IsZero() {
+		since = f.Since
+	}
+
+	var archive []byte
+	err := s.db.QueryRow(`
+		SELECT data FROM server_log_archives
+		WHERE server_id=$1 AND ($2::timestamptz IS NULL OR archived_at >= $2)
+	`, serverID, since).Scan(&archive)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if archive != nil {
+		archived, err := decodeLogArchive(archive)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range archived {
+			if f.match(entry) {
+				logs = append(logs, entry)
+			}
+		}
+	}
+
+	rows, err := s.db.Query(`
+		SELECT id, line, created_at FROM server_logs
+		WHERE server_id=$1
+		  AND ($2::timestamptz IS NULL OR created_at >= $2)
+		  AND ($3 = '' OR strpos(lower(line), lower($3)) > 0)
+		ORDER BY id
+	`, serverID, since, f.Query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry LogEntry
+		if err := rows.Scan(&entry.ID, &entry.Line, &entry.Time); err != nil {
+			return nil, err
+		}
+		entry.Level = logLevel(entry.Line)
+		entry.CreatedAt = entry.Time.UTC().Format(time.RFC3339Nano)
+		if f.match(entry) {
+			logs = append(logs, entry)
+		}
+	}
+	return logs, rows.Err()
+}
+
+// CompactServerLogs folds the log rows of finished servers whose last line is
+// older than retention into their server_log_archives blob.
+func (s *Store) CompactServerLogs(retention time.Duration) {
+	rows, err := s.db.Query(`
+		SELECT s.id FROM servers s
+		WHERE s.status IN ('ready', 'failed')
+		  AND EXISTS (SELECT 1 FROM server_logs l WHERE l.server_id = s.id)
+		  AND (SELECT max(created_at) FROM server_logs l WHERE l.server_id = s.id) < $1
+	`, time.Now().Add(-retention))
+	if err != nil {
+		slog.Error("failed to find logs to compact", "error", err)
+		return
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		n, err := s.compactServerLogs(id)
+		if err != nil {
+			slog.Error("failed to compact server logs", "server_id", id, "error", err)
+			continue
+		}
+		slog.Info("compacted server logs", "server_id", id, "lines", n)
+	}
+}
+
+func (s *Store) compactServerLogs(serverID int64) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Lock the archive row (if any) so concurrent compactions serialize
+	var entries []LogEntry
+	var archive []byte
+	err = tx.QueryRow(`SELECT data FROM server_log_archives WHERE server_id=$1 FOR UPDATE`, serverID).Scan(&archive)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	if archive != nil {
+		if entries, err = decodeLogArchive(archive); err != nil {
+			return 0, err
+		}
+	}
+
+	rows, err := tx.Query(`SELECT id, line, created_at FROM server_logs WHERE server_id=$1 ORDER BY id`, serverID)
+	if err != nil {
+		return 0, err
+	}
+	var maxID int64
+	var added int
+	for rows.Next() {
+		var entry LogEntry
+		if err := rows.Scan(&entry.ID, &entry.Line, &entry.Time); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		entries = append(entries, entry)
+		maxID = entry.ID
+		added++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if added == 0 {
+		return 0, nil
+	}
+
+	data, err := encodeLogArchive(entries)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO server_log_archives (server_id, data, line_count, archived_at)
+		VALUES ($1, $2, $3, now())
+		ON CONFLICT (server_id) DO UPDATE SET data=EXCLUDED.data, line_count=EXCLUDED.line_count, archived_at=now()
+	`, serverID, data, len(entries))
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`DELETE FROM server_logs WHERE server_id=$1 AND id<=$2`, serverID, maxID); err != nil {
+		return 0, err
+	}
+	return added, tx.Commit()
+}
+
 func (s *Store) ClearLogs(id int64) {
 	_, err := s.db.Exec(`DELETE FROM server_logs WHERE server_id=$1`, id)
 	if err != nil {
 		slog.Error("failed to clear logs", "server_id", id, "error", err)
 	}
+	_, err = s.db.Exec(`DELETE FROM server_log_archives WHERE server_id=$1`, id)
+	if err != nil {
+		slog.Error("failed to clear log archive", "server_id", id, "error", err)
+	}
 }
 
-func (s *Store) DeleteServer(id, userID int64) error {
-	result, err := s.db.Exec(`DELETE FROM servers WHERE id=$1 AND user_id=$2`, id, userID)
+// DeleteServer removes a server row. Callers check access with lookupServer first.
+func (s *Store) DeleteServer(id int64) error {
+	result, err := s.db.Exec(`DELETE FROM servers WHERE id=$1`, id)
 	if err != nil {
 		return err
 	}
@@ -270,7 +773,7 @@ func (s *Store) DeleteServer(id, userID int64) error {
 func (s *Store) ClearChannelTokens(id int64) {
 	_, err := s.db.Exec(`
 		UPDATE servers SET channels = (
-			SELECT COALESCE(jsonb_agg(ch - 'token'), '[]'::jsonb)
+			SELECT COALESCE(jsonb_agg(ch - 'token' - 'app_token'), '[]'::jsonb)
 			FROM jsonb_array_elements(channels) AS ch
 		) WHERE id=$1
 	`, id)
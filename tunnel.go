@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Agent tunnels: nodes in agent mode dial GET /agent/connect and serve node
+// API requests over the WebSocket (see nodeapi/agent.go for the protocol).
+// callNode prefers a live tunnel over dialing the node, so agent-mode nodes
+// need no inbound port. A tunnel lives on whichever creator machine the node
+// reached: that machine records itself in agent_machine and refreshes
+// agent_seen_at (what agent_online reports) on every keepalive, and the
+// others hand node calls to it with fly-replay (see replayToTunnel).
+
+const (
+	agentPingInterval = 30 * time.Second
+	agentPongTimeout  = 90 * time.Second
+	agentAuthTimeout  = 10 * time.Second
+	tunnelChunkBuffer = 64 // data messages queued per call before it is dropped
+)
+
+var errTunnelClosed = errors.New("agent tunnel closed")
+
+type tunnelMessage struct {
+	ID     uint64      `json:"id"`
+	Type   string      `json:"type"`
+	Method string      `json:"method,omitempty"`
+	Path   string      `json:"path,omitempty"`
+	Query  string      `json:"query,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	Status int         `json:"status,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+// tunnelRegistry tracks the live tunnel of each server.
+type tunnelRegistry struct {
+	mu       sync.Mutex
+	byServer map[int64]*agentTunnel
+}
+
+func newTunnelRegistry() *tunnelRegistry {
+	return &tunnelRegistry{byServer: make(map[int64]*agentTunnel)}
+}
+
+func (reg *tunnelRegistry) get(serverID int64) *agentTunnel {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return reg.byServer[serverID]
+}
+
+// register makes t the server's tunnel, closing any previous one.
+func (reg *tunnelRegistry) register(t *agentTunnel) {
+	reg.mu.Lock()
+	old := reg.byServer[t.serverID]
+	reg.byServer[t.serverID] = t
+	reg.mu.Unlock()
+	if old != nil {
+		old.conn.Close()
+	}
+}
+
+func (reg *tunnelRegistry) unregister(t *agentTunnel) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.byServer[t.serverID] == t {
+		delete(reg.byServer, t.serverID)
+	}
+}
+
+type agentTunnel struct {
+	serverID int64
+	conn     *websocket.Conn
+	wmu      sync.Mutex // serializes writes
+	closed   chan struct{}
+	metrics  func([]byte) // receives pushed metrics samples
+	alive    func()       // called on connect and each successful keepalive
+
+	mu     sync.Mutex
+	nextID uint64
+	calls  map[uint64]*tunnelCall
+}
+
+// tunnelCall is one in-flight request. The read loop queues body chunks on
+// chunks; a goroutine copies them into the response body pipe, so a slow
+// reader holds up only its own call.
+type tunnelCall struct {
+	resp     chan *http.Response
+	chunks   chan []byte
+	pw       *io.PipeWriter
+	gone     chan struct{} // closed when the caller is no longer listening
+	goneOnce sync.Once
+}
+
+func (c *tunnelCall) abandon() {
+	c.goneOnce.Do(func() { close(c.gone) })
+}
+
+func newAgentTunnel(serverID int64, conn *websocket.Conn, metrics func([]byte), alive func()) *agentTunnel {
+	return &agentTunnel{
+		serverID: serverID,
+		conn:     conn,
+		closed:   make(chan struct{}),
+		metrics:  metrics,
+		alive:    alive,
+		calls:    make(map[uint64]*tunnelCall),
+	}
+}
+
+func (t *agentTunnel) send(m *tunnelMessage) error {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	t.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return t.conn.WriteJSON(m)
+}
+
+// roundTrip sends a request through the tunnel and waits for the response
+// headers. The body streams until the node ends it or the caller closes it.
+func (t *agentTunnel) roundTrip(ctx context.Context, method, path, query string, header http.Header, body []byte) (*http.Response, error) {
+	pr, pw := io.Pipe()
+	call := &tunnelCall{
+		resp:   make(chan *http.Response, 1),
+		chunks: make(chan []byte, tunnelChunkBuffer),
+		pw:     pw,
+		gone:   make(chan struct{}),
+	}
+
+	t.mu.Lock()
+	t.nextID++
+	id := t.nextID
+	t.calls[id] = call
+	t.mu.Unlock()
+
+	go func() {
+		for {
+			select {
+			case chunk, ok := <-call.chunks:
+				if !ok {
+					pw.Close()
+					return
+				}
+				if _, err := pw.Write(chunk); err != nil {
+					return
+				}
+			case <-call.gone:
+				return
+			}
+		}
+	}()
+
+	err := t.send(&tunnelMessage{ID: id, Type: "request", Method: method, Path: path, Query: query, Header: header, Body: body})
+	if err != nil {
+		t.release(id)
+		return nil, err
+	}
+
+	select {
+	case resp := <-call.resp:
+		resp.Body = &tunnelBody{PipeReader: pr, t: t, id: id}
+		return resp, nil
+	case <-ctx.Done():
+		t.release(id)
+		return nil, ctx.Err()
+	case <-t.closed:
+		return nil, errTunnelClosed
+	case <-time.After(nodeRequestTimeout):
+		t.release(id)
+		return nil, errors.New("agent tunnel: timed out waiting for response")
+	}
+}
+
+// release forgets a call the caller has given up on and tells the node.
+func (t *agentTunnel) release(id uint64) {
+	t.mu.Lock()
+	call, ok := t.calls[id]
+	delete(t.calls, id)
+	t.mu.Unlock()
+	if ok {
+		call.abandon()
+		t.send(&tunnelMessage{ID: id, Type: "cancel"})
+	}
+}
+
+type tunnelBody struct {
+	*io.PipeReader
+	t  *agentTunnel
+	id uint64
+}
+
+func (b *tunnelBody) Close() error {
+	b.PipeReader.Close()
+	b.t.release(b.id)
+	return nil
+}
+
+// readLoop dispatches node messages until the connection fails.
+func (t *agentTunnel) readLoop() error {
+	t.conn.SetReadLimit(1 << 20)
+	t.conn.SetReadDeadline(time.Now().Add(agentPongTimeout))
+	t.conn.SetPongHandler(func(string) error {
+		return t.conn.SetReadDeadline(time.Now().Add(agentPongTimeout))
+	})
+
+	defer func() {
+		close(t.closed)
+		t.mu.Lock()
+		for id, call := range t.calls {
+			call.pw.CloseWithError(errTunnelClosed)
+			call.abandon()
+			delete(t.calls, id)
+		}
+		t.mu.Unlock()
+	}()
+
+	for {
+		var m tunnelMessage
+		if err := t.conn.ReadJSON(&m); err != nil {
+			return err
+		}
+		if m.Type == "metrics" {
+			go t.metrics(m.Body)
+			continue
+		}
+
+		t.mu.Lock()
+		call, ok := t.calls[m.ID]
+		if ok && m.Type == "end" {
+			delete(t.calls, m.ID)
+		}
+		t.mu.Unlock()
+		if !ok {
+			continue // cancelled by the caller
+		}
+
+		switch m.Type {
+		case "response":
+			select {
+			case call.resp <- &http.Response{StatusCode: m.Status, Header: m.Header}:
+			default:
+			}
+		case "data":
+			select {
+			case call.chunks <- m.Body:
+			default:
+				slog.Warn("agent tunnel: dropping slow request", "server_id", t.serverID, "id", m.ID)
+				call.pw.CloseWithError(errors.New("agent tunnel: reader too slow"))
+				t.release(m.ID)
+			}
+		case "end":
+			close(call.chunks)
+		}
+	}
+}
+
+// keepalive pings the node so both ends notice a dead connection.
+func (t *agentTunnel) keepalive() {
+	ticker := time.NewTicker(agentPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.wmu.Lock()
+			err := t.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+			t.wmu.Unlock()
+			if err != nil {
+				t.conn.Close()
+				return
+			}
+			t.alive()
+		case <-t.closed:
+			return
+		}
+	}
+}
+
+// agentHandshake authenticates a connecting agent: it sends a fresh nonce,
+// and the node must sign it, together with host (that of PUBLIC_URL, which
+// its agent URL points at), with the key of the certificate pinned for one
+// of its servers. The nonce makes every handshake single-use, so a captured
+// one can't take over the tunnel.
+func (s *Server) agentHandshake(conn *websocket.Conn, host string) (int64, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return 0, err
+	}
+	conn.SetWriteDeadline(time.Now().Add(agentAuthTimeout))
+	if err := conn.WriteJSON(&tunnelMessage{Type: "challenge", Body: nonce}); err != nil {
+		return 0, err
+	}
+
+	conn.SetReadLimit(16 << 10)
+	conn.SetReadDeadline(time.Now().Add(agentAuthTimeout))
+	var m tunnelMessage
+	if err := conn.ReadJSON(&m); err != nil {
+		return 0, err
+	}
+	if m.Type != "auth" {
+		return 0, errors.New("expected an auth message")
+	}
+
+	certDER, err1 := base64.StdEncoding.DecodeString(m.Header.Get("X-Agent-Certificate"))
+	sig, err2 := base64.StdEncoding.DecodeString(m.Header.Get("X-Agent-Signature"))
+	if err1 != nil || err2 != nil || len(certDER) == 0 || len(sig) == 0 {
+		return 0, errors.New("missing agent credentials")
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return 0, errors.New("invalid certificate")
+	}
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return 0, errors.New("unsupported key type")
+	}
+	digest := sha256.Sum256([]byte("openclaw-agent-v2\n" + host + "\n" + base64.StdEncoding.EncodeToString(nonce)))
+	if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+		return 0, errors.New("invalid signature")
+	}
+
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return s.store.GetServerIDByNodeFingerprint(base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+func (s *Server) handleAgentConnect(w http.ResponseWriter, r *http.Request) {
+	if !isHTTPS(r) {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "agent tunnels require wss://"})
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Error("agent websocket upgrade failed", "ip", clientIP(r), "error", err)
+		return
+	}
+	defer conn.Close()
+
+	// Bound to our configured host, not the client-supplied Host header, so a
+	// handshake signed for one front end can't be replayed through another
+	host, _ := s.siweOrigin()
+	serverID, err := s.agentHandshake(conn, host)
+	if err != nil {
+		slog.Warn("agent connection rejected", "ip", clientIP(r), "error", err)
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "unauthorized"), time.Now().Add(time.Second))
+		return
+	}
+
+	t := newAgentTunnel(serverID, conn, func(data []byte) { s.recordMetrics(serverID, data) },
+		func() { s.store.SetAgentSeen(serverID, s.config.MachineID) })
+	s.tunnels.register(t)
+	t.alive()
+	s.hub.Notify(serverID)
+	slog.Info("agent connected", "server_id", serverID, "ip", clientIP(r))
+
+	go t.keepalive()
+	err = t.readLoop()
+
+	s.tunnels.unregister(t)
+	s.hub.Notify(serverID)
+	slog.Info("agent disconnected", "server_id", serverID, "error", err)
+}
+
+// replayToTunnel hands the request to the creator machine holding the
+// server's agent tunnel, if that is another machine, by answering with a
+// fly-replay header for Fly's proxy to act on. It reports whether it did; the
+// caller must not have acted on the request yet. A request that was already
+// replayed is never replayed again, so a stale agent_machine can't loop.
+func (s *Server) replayToTunnel(w http.ResponseWriter, r *http.Request, info *ServerInfo) bool {
+	if s.config.MachineID == "" || s.tunnels.get(info.ID) != nil {
+		return false
+	}
+	if !info.AgentOnline || info.AgentMachine == "" || info.AgentMachine == s.config.MachineID {
+		return false
+	}
+	if r.Header.Get("Fly-Replay-Src") != "" {
+		slog.Warn("agent tunnel not found after replay", "server_id", info.ID, "machine", info.AgentMachine)
+		return false
+	}
+	w.Header().Set("Fly-Replay", "instance="+info.AgentMachine)
+	w.WriteHeader(http.StatusConflict)
+	return true
+}
+
+// agentURL is the endpoint nodes dial in agent mode, or "" when the creator
+// has no public https URL for them to reach.
+func (s *Server) agentURL() string {
+	if rest, ok := strings.CutPrefix(s.config.PublicURL, "https://"); ok {
+		return "wss://" + rest + "/agent/connect"
+	}
+	return ""
+}
@@ -1,12 +1,18 @@
 package main
 
+import (
+	"slices"
+	"time"
+)
+
 // Request/response types
 
 type ChannelConfig struct {
-	Type    string `json:"type"`              // telegram, discord, slack, whatsapp, signal, googlechat, mattermost
-	Token   string `json:"token,omitempty"`   // bot token (telegram, discord, slack)
-	Name    string `json:"name,omitempty"`    // display name for the account
-	Account string `json:"account,omitempty"` // account id (default: "default")
+	Type     string `json:"type"`                // telegram, discord, slack, whatsapp, signal, googlechat, mattermost
+	Token    string `json:"token,omitempty"`     // bot token (telegram, discord, slack)
+	Name     string `json:"name,omitempty"`      // display name for the account
+	Account  string `json:"account,omitempty"`   // account id (default: "default")
+	AppToken string `json:"app_token,omitempty"` // Slack app-level token (xapp-...) for Socket Mode
 }
 
 type CreateServerRequest struct {
@@ -18,6 +24,8 @@ type CreateServerRequest struct {
 	WayfinderAPIKey string          `json:"wayfinder_api_key,omitempty"`
 	Channels        []ChannelConfig `json:"channels,omitempty"`
 	PublicKeyPEM    string          `json:"public_key_pem,omitempty"`
+	OrgID           int64           `json:"org_id,omitempty"`      // create the server in this org
+	ServerType      string          `json:"server_type,omitempty"` // Hetzner type; defaults to SERVER_TYPE
 }
 
 type CreateServerResponse struct {
@@ -59,11 +67,21 @@ type ServerInfo struct {
 	HasNodeAPI        bool
 	CreatedAt         string
 	ChannelCount      int
+	UserID            int64 // owner (creator, for org servers); 0 for legacy servers without one
+	OrgID             int64 // owning org; 0 for personal servers
+
+	NodeAPIFingerprint string // pinned TLS key of the node API; "" for nodes predating TLS
+	AgentSeenAt        string // last time an agent tunnel was seen live; "" if never
+	AgentMachine       string // creator machine (FLY_MACHINE_ID) holding the tunnel
+	AgentOnline        bool   // agent tunnel seen within the last 90s (three keepalives)
 }
 
 type LogEntry struct {
-	ID   int64  `json:"id"`
-	Line string `json:"line"`
+	ID        int64     `json:"id,omitempty"` // 0 for lines restored from an archive
+	Line      string    `json:"line"`
+	Level     string    `json:"level,omitempty"`
+	CreatedAt string    `json:"created_at,omitempty"`
+	Time      time.Time `json:"-"`
 }
 
 type PairingRequest struct {
@@ -81,6 +99,40 @@ type PairingActionRequest struct {
 	Code    string `json:"code"`
 }
 
+// NodeMetrics is a node's GET /system/metrics response, and one sample of
+// a server's metrics history.
+type NodeMetrics struct {
+	CollectedAt   string  `json:"collected_at"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	CPUCount      int     `json:"cpu_count"`
+	CPUPercent    float64 `json:"cpu_percent"`
+	Load1         float64 `json:"load_1"`
+	Load5         float64 `json:"load_5"`
+	Load15        float64 `json:"load_15"`
+	MemTotal      int64   `json:"mem_total_bytes"`
+	MemAvailable  int64   `json:"mem_available_bytes"`
+	SwapTotal     int64   `json:"swap_total_bytes"`
+	SwapFree      int64   `json:"swap_free_bytes"`
+	DiskPath      string  `json:"disk_path,omitempty"`
+	DiskTotal     int64   `json:"disk_total_bytes"`
+	DiskFree      int64   `json:"disk_free_bytes"`
+	Processes     int     `json:"processes"`
+	DaemonProcs   int     `json:"daemon_processes"`
+	GatewayProcs  int     `json:"gateway_processes"`
+}
+
+// ServerAction is an audit log entry for a state-changing call to a node.
+type ServerAction struct {
+	ID          int64  `json:"id"`
+	ServerID    int64  `json:"server_id"`
+	UserID      int64  `json:"user_id"`
+	UserAddress string `json:"user_address"`
+	Action      string `json:"action"`
+	Target      string `json:"target"`
+	Status      int    `json:"status"` // node HTTP status, 0 if unreachable
+	CreatedAt   string `json:"created_at"`
+}
+
 // Auth types
 
 type User struct {
@@ -89,15 +141,143 @@ type User struct {
 	PublicKey    string `json:"-"`
 	Role         string `json:"role"`
 	Approved     bool   `json:"approved"`
+	Suspended    bool   `json:"suspended"`
 	SSHPublicKey string `json:"ssh_public_key"`
 	CreatedAt    string `json:"created_at"`
+
+	// Quota overrides; nil inherits the role's quota (see quota.go)
+	MaxServers      *int     `json:"max_servers,omitempty"`
+	MaxProvisioning *int     `json:"max_provisioning,omitempty"`
+	ServerTypes     []string `json:"server_types,omitempty"`
+}
+
+// Quota limits server creation. Nil fields are unlimited; an empty
+// ServerTypes allows only the configured SERVER_TYPE.
+type Quota struct {
+	MaxServers      *int     `json:"max_servers"`
+	MaxProvisioning *int     `json:"max_provisioning"`
+	ServerTypes     []string `json:"server_types"`
+}
+
+// QuotaUsage is a user's effective quota alongside current usage.
+type QuotaUsage struct {
+	Quota
+	Servers      int `json:"servers"`
+	Provisioning int `json:"provisioning"`
 }
 
 type Session struct {
-	ID        string
-	UserID    int64
-	CreatedAt string
-	ExpiresAt string
+	ID         string `json:"-"` // the cookie value; never exposed
+	PublicID   int64  `json:"id"`
+	UserID     int64  `json:"-"`
+	UserAgent  string `json:"user_agent"`
+	IP         string `json:"ip"`
+	CreatedAt  string `json:"created_at"`
+	LastSeenAt string `json:"last_seen_at"`
+	ExpiresAt  string `json:"expires_at"`
+	Replaced   bool   `json:"-"` // rotated out; valid only for a short grace period
+	Current    bool   `json:"current"`
+}
+
+// OIDCProvider is an organization's identity provider. Users signing in
+// through it join the org with a role mapped from their ID token claims.
+type OIDCProvider struct {
+	ID           int64             `json:"id"`
+	OrgID        int64             `json:"org_id"`
+	Name         string            `json:"name"`
+	Issuer       string            `json:"issuer"`
+	ClientID     string            `json:"client_id"`
+	ClientSecret string            `json:"client_secret,omitempty"` // write-only
+	Scopes       []string          `json:"scopes"`
+	RoleClaim    string            `json:"role_claim,omitempty"`   // e.g. "groups"
+	RoleMap      map[string]string `json:"role_map,omitempty"`     // claim value -> org role
+	DefaultRole  string            `json:"default_role,omitempty"` // when nothing maps; empty denies sign-in
+	CreatedAt    string            `json:"created_at"`
+}
+
+// Passkey is a WebAuthn credential registered to a user.
+type Passkey struct {
+	ID         int64  `json:"id"`
+	UserID     int64  `json:"-"`
+	Name       string `json:"name"`
+	SignCount  uint32 `json:"-"`
+	PublicKey  []byte `json:"-"` // COSE_Key
+	LastUsedAt string `json:"last_used_at,omitempty"`
+	CreatedAt  string `json:"created_at"`
+}
+
+type APIToken struct {
+	ID         int64    `json:"id"`
+	UserID     int64    `json:"-"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"` // first characters of the token, for identification
+	Scopes     []string `json:"scopes"`
+	ExpiresAt  string   `json:"expires_at,omitempty"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+	CreatedAt  string   `json:"created_at"`
+}
+
+func (t *APIToken) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+type CreateAPITokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"` // 0 = never expires
+}
+
+type CreateAPITokenResponse struct {
+	*APIToken
+	Token string `json:"token"` // shown once; only the hash is stored
+}
+
+type Organization struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	Role      string `json:"role,omitempty"` // the requesting user's role in the org
+	CreatedAt string `json:"created_at"`
+}
+
+type OrgMember struct {
+	UserID    int64  `json:"user_id"`
+	Address   string `json:"address"`
+	Role      string `json:"role"`
+	CreatedAt string `json:"created_at"`
+}
+
+// ApprovalRule auto-approves new wallets at sign-in.
+type ApprovalRule struct {
+	ID         int64  `json:"id"`
+	Type       string `json:"type"`                  // "allowlist", "erc20" or "erc721"
+	Address    string `json:"address"`               // wallet (allowlist) or token contract
+	MinBalance string `json:"min_balance,omitempty"` // decimal, raw token units; token rules only
+	Note       string `json:"note,omitempty"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// InviteCode approves the user who redeems it at sign-in. Codes created as
+// invite links (see invites.go) also carry a role, a server quota and more
+// than one use. Only the code's hash is stored.
+type InviteCode struct {
+	ID         int64  `json:"id"`
+	Code       string `json:"code,omitempty"` // only returned at creation
+	Link       string `json:"link,omitempty"` // only returned at creation
+	Role       string `json:"role,omitempty"` // empty: keep DefaultRole
+	MaxServers *int   `json:"max_servers,omitempty"`
+	MaxUses    int    `json:"max_uses"` // 0: unlimited
+	Uses       int    `json:"uses"`
+	RedeemedBy int64  `json:"redeemed_by,omitempty"` // most recent
+	RedeemedAt string `json:"redeemed_at,omitempty"`
+	ExpiresAt  string `json:"expires_at,omitempty"`
+	CreatedAt  string `json:"created_at"`
+}
+
+type CreateInviteRequest struct {
+	Role          string `json:"role"`
+	MaxServers    *int   `json:"max_servers"`
+	MaxUses       *int   `json:"max_uses"` // default 1
+	ExpiresInDays int    `json:"expires_in_days"`
 }
 
 type ChallengeRequest struct {
@@ -109,11 +289,14 @@ type ChallengeResponse struct {
 }
 
 type VerifyRequest struct {
-	Address   string `json:"address"`
-	Signature string `json:"signature"`
-	Challenge string `json:"challenge"`
+	Address    string `json:"address"`
+	Signature  string `json:"signature"`
+	Challenge  string `json:"challenge"`
+	InviteCode string `json:"invite_code,omitempty"`
+	Link       bool   `json:"link,omitempty"` // attach the wallet to the signed-in account
 }
 
 type AuthResponse struct {
-	User *User `json:"user"`
+	User  *User       `json:"user"`
+	Quota *QuotaUsage `json:"quota,omitempty"`
 }
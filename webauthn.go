@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Minimal WebAuthn relying-party support: enough of CBOR and COSE to read
+// attestation objects and verify assertions. Attestation statements are not
+// verified (we request "none" conveyance); only ES256, RS256 and EdDSA
+// credential keys are accepted.
+
+// COSE algorithm identifiers we accept, in order of preference.
+const (
+	coseAlgES256 = -7
+	coseAlgEdDSA = -8
+	coseAlgRS256 = -257
+)
+
+// Authenticator data flags
+const (
+	authFlagUserPresent  = 0x01
+	authFlagUserVerified = 0x04
+	authFlagAttested     = 0x40
+	authFlagExtensions   = 0x80
+)
+
+// b64url is lenient base64url: browsers and libraries disagree on padding.
+type b64url []byte
+
+func (b *b64url) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	out, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return fmt.Errorf("invalid base64url: %w", err)
+	}
+	*b = out
+	return nil
+}
+
+func (b b64url) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+// clientData is the parsed clientDataJSON.
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+func parseClientData(raw []byte, wantType string) (*clientData, error) {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return nil, fmt.Errorf("invalid clientDataJSON: %w", err)
+	}
+	if cd.Type != wantType {
+		return nil, fmt.Errorf("clientData type %q, want %q", cd.Type, wantType)
+	}
+	return &cd, nil
+}
+
+// authenticatorData is the parsed authData of a registration or assertion.
+type authenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte // registration only
+	PublicKey    []byte // registration only: the raw COSE_Key
+}
+
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errors.New("authenticator data too short")
+	}
+	ad := &authenticatorData{
+		RPIDHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if ad.Flags&authFlagAttested == 0 {
+		return ad, nil
+	}
+
+	// Attested credential data: aaguid(16) || idLen(2) || id || COSE_Key
+	rest := data[37:]
+	if len(rest) < 18 {
+		return nil, errors.New("attested credential data too short")
+	}
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLen {
+		return nil, errors.New("credential id truncated")
+	}
+	ad.CredentialID = rest[:idLen]
+	rest = rest[idLen:]
+
+	d := &cborDecoder{b: rest}
+	if _, err := d.value(0); err != nil {
+		return nil, fmt.Errorf("credential public key: %w", err)
+	}
+	ad.PublicKey = rest[:d.off]
+	return ad, nil
+}
+
+// check verifies the RP ID hash and user presence.
+func (ad *authenticatorData) check(rpID string) error {
+	want := sha256.Sum256([]byte(rpID))
+	if !bytes.Equal(ad.RPIDHash, want[:]) {
+		return errors.New("rp id mismatch")
+	}
+	if ad.Flags&authFlagUserPresent == 0 {
+		return errors.New("user not present")
+	}
+	return nil
+}
+
+// parseAttestationObject returns the authenticator data from a registration
+// response's attestationObject. The attestation statement is ignored.
+func parseAttestationObject(raw []byte) (*authenticatorData, error) {
+	d := &cborDecoder{b: raw}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, fmt.Errorf("attestation object: %w", err)
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, errors.New("attestation object is not a map")
+	}
+	authData, ok := m["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("attestation object missing authData")
+	}
+	ad, err := parseAuthenticatorData(authData)
+	if err != nil {
+		return nil, err
+	}
+	if ad.Flags&authFlagAttested == 0 {
+		return nil, errors.New("no attested credential data")
+	}
+	return ad, nil
+}
+
+// verifyAssertionSignature checks sig over authData || SHA-256(clientDataJSON)
+// with a stored COSE_Key.
+func verifyAssertionSignature(coseKey, authData, clientDataJSON, sig []byte) error {
+	pub, alg, err := parseCOSEKey(coseKey)
+	if err != nil {
+		return err
+	}
+	cdHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authData...), cdHash[:]...)
+
+	switch alg {
+	case coseAlgES256:
+		digest := sha256.Sum256(signed)
+		if !ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), digest[:], sig) {
+			return errors.New("invalid signature")
+		}
+	case coseAlgRS256:
+		digest := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(pub.(*rsa.PublicKey), crypto.SHA256, digest[:], sig); err != nil {
+			return errors.New("invalid signature")
+		}
+	case coseAlgEdDSA:
+		if !ed25519.Verify(pub.(ed25519.PublicKey), signed, sig) {
+			return errors.New("invalid signature")
+		}
+	}
+	return nil
+}
+
+// parseCOSEKey decodes a COSE_Key into a Go public key and its algorithm.
+func parseCOSEKey(raw []byte) (any, int64, error) {
+	d := &cborDecoder{b: raw}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, 0, fmt.Errorf("cose key: %w", err)
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, 0, errors.New("cose key is not a map")
+	}
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+	crv, _ := m[int64(-1)].(int64)
+	x, _ := m[int64(-2)].([]byte)
+
+	switch {
+	case kty == 2 && alg == coseAlgES256 && crv == 1:
+		y, _ := m[int64(-3)].([]byte)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, 0, errors.New("invalid P-256 key")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, 0, errors.New("invalid P-256 key")
+		}
+		return pub, alg, nil
+	case kty == 3 && alg == coseAlgRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, errors.New("invalid RSA key")
+		}
+		exp := 0
+		for _, b := range e {
+			exp = exp<<8 | int(b)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}, alg, nil
+	case kty == 1 && alg == coseAlgEdDSA && crv == 6:
+		if len(x) != ed25519.PublicKeySize {
+			return nil, 0, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), alg, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported key type %d / algorithm %d", kty, alg)
+}
+
+// cborDecoder decodes the definite-length subset of CBOR used by WebAuthn.
+// Integers decode to int64, byte strings to []byte, text to string, arrays
+// to []any and maps to map[any]any.
+type cborDecoder struct {
+	b   []byte
+	off int
+}
+
+const cborMaxDepth = 16
+
+func (d *cborDecoder) head() (major byte, arg uint64, err error) {
+	if d.off >= len(d.b) {
+		return 0, 0, errors.New("cbor: unexpected end of data")
+	}
+	ib := d.b[d.off]
+	d.off++
+	major, info := ib>>5, ib&0x1f
+
+	var n int
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		n = 1
+	case info == 25:
+		n = 2
+	case info == 26:
+		n = 4
+	case info == 27:
+		n = 8
+	default:
+		return 0, 0, fmt.Errorf("cbor: unsupported additional info %d", info)
+	}
+	if d.off+n > len(d.b) {
+		return 0, 0, errors.New("cbor: unexpected end of data")
+	}
+	for _, b := range d.b[d.off : d.off+n] {
+		arg = arg<<8 | uint64(b)
+	}
+	d.off += n
+	return major, arg, nil
+}
+
+func (d *cborDecoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.b)-d.off) {
+		return nil, errors.New("cbor: unexpected end of data")
+	}
+	out := d.b[d.off : d.off+int(n)]
+	d.off += int(n)
+	return out, nil
+}
+
+func (d *cborDecoder) value(depth int) (any, error) {
+	if depth > cborMaxDepth {
+		return nil, errors.New("cbor: nested too deeply")
+	}
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > 1<<63-1 {
+			return nil, errors.New("cbor: integer overflow")
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > 1<<63-1 {
+			return nil, errors.New("cbor: integer overflow")
+		}
+		return -1 - int64(arg), nil
+	case 2:
+		return d.bytes(arg)
+	case 3:
+		b, err := d.bytes(arg)
+		return string(b), err
+	case 4:
+		if arg > uint64(len(d.b)) {
+			return nil, errors.New("cbor: array too long")
+		}
+		arr := make([]any, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	case 5:
+		if arg > uint64(len(d.b)) {
+			return nil, errors.New("cbor: map too long")
+		}
+		m := make(map[any]any, arg)
+		for i := uint64(0); i < arg; i++ {
+			k, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, errors.New("cbor: unsupported map key type")
+			}
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	case 6:
+		// Tags carry no meaning for WebAuthn; return the tagged item
+		return d.value(depth + 1)
+	default:
+		switch arg {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		}
+		return nil, fmt.Errorf("cbor: unsupported simple value %d", arg)
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// cborHead encodes a CBOR item head with the shortest argument.
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, n)
+}
+
+// cborEncode encodes the types cborDecoder produces. Map keys are sorted so
+// the output is stable.
+func cborEncode(v any) []byte {
+	switch v := v.(type) {
+	case int:
+		return cborEncode(int64(v))
+	case int64:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case []any:
+		out := cborHead(4, uint64(len(v)))
+		for _, item := range v {
+			out = append(out, cborEncode(item)...)
+		}
+		return out
+	case map[any]any:
+		var keys [][]byte
+		entries := make(map[string][]byte)
+		for k, item := range v {
+			ek := cborEncode(k)
+			keys = append(keys, ek)
+			entries[string(ek)] = cborEncode(item)
+		}
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+		out := cborHead(5, uint64(len(v)))
+		for _, k := range keys {
+			out = append(append(out, k...), entries[string(k)]...)
+		}
+		return out
+	}
+	panic("cborEncode: unsupported type")
+}
+
+func TestCBORDecoder(t *testing.T) {
+	nested := func(depth int) []byte {
+		return append(bytes.Repeat([]byte{0x81}, depth), 0x00) // [[[...0]]]
+	}
+	tests := []struct {
+		name    string
+		in      []byte
+		want    any
+		wantErr string
+	}{
+		{name: "unsigned", in: []byte{0x19, 0x01, 0x00}, want: int64(256)},
+		{name: "negative", in: []byte{0x38, 0x63}, want: int64(-100)},
+		{name: "byte string", in: []byte{0x43, 1, 2, 3}, want: []byte{1, 2, 3}},
+		{name: "text", in: []byte{0x62, 'h', 'i'}, want: "hi"},
+		{name: "tag is transparent", in: []byte{0xc1, 0x05}, want: int64(5)},
+		{name: "map", in: []byte{0xa2, 0x01, 0x02, 0x61, 'k', 0x80}, want: map[any]any{int64(1): int64(2), "k": []any{}}},
+
+		{name: "empty", in: nil, wantErr: "unexpected end"},
+		{name: "truncated head", in: []byte{0x19, 0x01}, wantErr: "unexpected end"},
+		{name: "truncated byte string", in: []byte{0x45, 1, 2}, wantErr: "unexpected end"},
+		{name: "truncated map", in: []byte{0xa2, 0x01, 0x02, 0x03}, wantErr: "unexpected end"},
+		{name: "oversized byte string", in: append([]byte{0x5b}, bytes.Repeat([]byte{0xff}, 8)...), wantErr: "unexpected end"},
+		{name: "oversized array", in: append([]byte{0x9b}, bytes.Repeat([]byte{0xff}, 8)...), wantErr: "array too long"},
+		{name: "oversized map", in: []byte{0xba, 0xff, 0xff, 0xff, 0xff}, wantErr: "map too long"},
+		{name: "integer overflow", in: append([]byte{0x1b}, bytes.Repeat([]byte{0xff}, 8)...), wantErr: "integer overflow"},
+		{name: "too deeply nested", in: nested(cborMaxDepth + 1), wantErr: "nested too deeply"},
+		{name: "deeply nested tags", in: append(bytes.Repeat([]byte{0xc1}, 100), 0x00), wantErr: "nested too deeply"},
+		{name: "indefinite length", in: []byte{0x9f, 0x01, 0xff}, wantErr: "unsupported additional info"},
+		{name: "byte string map key", in: []byte{0xa1, 0x41, 0x00, 0x01}, wantErr: "unsupported map key"},
+		{name: "float", in: []byte{0xf9, 0x3c, 0x00}, wantErr: "unsupported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &cborDecoder{b: tt.in}
+			got, err := d.value(0)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v; want error %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("max depth", func(t *testing.T) {
+		d := &cborDecoder{b: nested(cborMaxDepth)}
+		if _, err := d.value(0); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// testAuthenticator holds a credential key and signs like an authenticator.
+type testAuthenticator struct {
+	alg    int64
+	cose   []byte
+	sign   func(msg []byte) []byte
+	credID []byte
+}
+
+// newTestAuthenticator makes a credential for alg. Ed25519 keys come from
+// seed, so their signatures are fixed vectors.
+func newTestAuthenticator(t *testing.T, alg int64, seed byte) *testAuthenticator {
+	t.Helper()
+	a := &testAuthenticator{alg: alg, credID: []byte(fmt.Sprintf("credential%d", -alg))}
+	switch alg {
+	case coseAlgES256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.cose = cborEncode(map[any]any{
+			int64(1): int64(2), int64(3): int64(coseAlgES256), int64(-1): int64(1),
+			int64(-2): key.X.FillBytes(make([]byte, 32)), int64(-3): key.Y.FillBytes(make([]byte, 32)),
+		})
+		a.sign = func(msg []byte) []byte {
+			digest := sha256.Sum256(msg)
+			sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return sig
+		}
+	case coseAlgEdDSA:
+		key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize))
+		a.cose = cborEncode(map[any]any{
+			int64(1): int64(1), int64(3): int64(coseAlgEdDSA), int64(-1): int64(6),
+			int64(-2): []byte(key.Public().(ed25519.PublicKey)),
+		})
+		a.sign = func(msg []byte) []byte { return ed25519.Sign(key, msg) }
+	case coseAlgRS256:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.cose = cborEncode(map[any]any{
+			int64(1): int64(3), int64(3): int64(coseAlgRS256),
+			int64(-1): key.N.Bytes(), int64(-2): big.NewInt(int64(key.E)).Bytes(),
+		})
+		a.sign = func(msg []byte) []byte {
+			digest := sha256.Sum256(msg)
+			sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return sig
+		}
+	}
+	return a
+}
+
+// authData builds authenticator data for rpID, with attested credential
+// data when attested is set.
+func (a *testAuthenticator) authData(rpID string, flags byte, signCount uint32, attested bool) []byte {
+	hash := sha256.Sum256([]byte(rpID))
+	out := append(hash[:], flags)
+	out = binary.BigEndian.AppendUint32(out, signCount)
+	if attested {
+		out = append(out, make([]byte, 16)...) // aaguid
+		out = binary.BigEndian.AppendUint16(out, uint16(len(a.credID)))
+		out = append(append(out, a.credID...), a.cose...)
+	}
+	return out
+}
+
+func TestParseAttestationObject(t *testing.T) {
+	for _, alg := range webauthnAlgs {
+		a := newTestAuthenticator(t, alg, 1)
+		t.Run(a.name(), func(t *testing.T) {
+			authData := a.authData("creator.example", authFlagUserPresent|authFlagAttested, 0, true)
+			att := cborEncode(map[any]any{"fmt": "none", "attStmt": map[any]any{}, "authData": authData})
+
+			ad, err := parseAttestationObject(att)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ad.check("creator.example"); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(ad.CredentialID, a.credID) || !bytes.Equal(ad.PublicKey, a.cose) {
+				t.Fatalf("got credential %x key %x", ad.CredentialID, ad.PublicKey)
+			}
+			if _, gotAlg, err := parseCOSEKey(ad.PublicKey); err != nil || gotAlg != alg {
+				t.Fatalf("parseCOSEKey: alg %d, %v", gotAlg, err)
+			}
+		})
+	}
+
+	a := newTestAuthenticator(t, coseAlgEdDSA, 1)
+	full := a.authData("creator.example", authFlagUserPresent|authFlagAttested, 0, true)
+	tests := []struct {
+		name     string
+		authData []byte
+		wantErr  string
+	}{
+		{name: "short", authData: full[:36], wantErr: "too short"},
+		{name: "not attested", authData: a.authData("creator.example", authFlagUserPresent, 0, false), wantErr: "no attested credential"},
+		{name: "short credential data", authData: full[:37+17], wantErr: "attested credential data too short"},
+		{name: "truncated credential id", authData: full[:37+18+len(a.credID)-1], wantErr: "credential id truncated"},
+		{name: "truncated key", authData: full[:len(full)-1], wantErr: "credential public key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			att := cborEncode(map[any]any{"fmt": "none", "attStmt": map[any]any{}, "authData": tt.authData})
+			if _, err := parseAttestationObject(att); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+	t.Run("missing authData", func(t *testing.T) {
+		if _, err := parseAttestationObject(cborEncode(map[any]any{"fmt": "none"})); err == nil {
+			t.Fatal("accepted")
+		}
+	})
+}
+
+func (a *testAuthenticator) name() string {
+	switch a.alg {
+	case coseAlgES256:
+		return "ES256"
+	case coseAlgEdDSA:
+		return "EdDSA"
+	}
+	return "RS256"
+}
+
+func TestParseCOSEKeyRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		key  map[any]any
+	}{
+		{name: "P-256 point off the curve", key: map[any]any{
+			int64(1): int64(2), int64(3): int64(coseAlgES256), int64(-1): int64(1),
+			int64(-2): bytes.Repeat([]byte{1}, 32), int64(-3): bytes.Repeat([]byte{2}, 32)}},
+		{name: "short RSA modulus", key: map[any]any{
+			int64(1): int64(3), int64(3): int64(coseAlgRS256), int64(-1): make([]byte, 128), int64(-2): []byte{1, 0, 1}}},
+		{name: "short Ed25519 key", key: map[any]any{
+			int64(1): int64(1), int64(3): int64(coseAlgEdDSA), int64(-1): int64(6), int64(-2): make([]byte, 31)}},
+		{name: "unsupported algorithm", key: map[any]any{int64(1): int64(2), int64(3): int64(-35)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := parseCOSEKey(cborEncode(tt.key)); err == nil {
+				t.Fatal("accepted")
+			}
+		})
+	}
+}
+
+func TestVerifyPasskeyAssertion(t *testing.T) {
+	const rpID = "creator.example"
+	handle := []byte("user-handle")
+	clientData := []byte(`{"type":"webauthn.get","challenge":"c","origin":"https://creator.example"}`)
+
+	for _, alg := range webauthnAlgs {
+		a := newTestAuthenticator(t, alg, 1)
+		other := newTestAuthenticator(t, alg, 2)
+		t.Run(a.name(), func(t *testing.T) {
+			// assert signs authData || SHA-256(clientData) like an authenticator
+			assert := func(signer *testAuthenticator, authData []byte) []byte {
+				cdHash := sha256.Sum256(clientData)
+				return signer.sign(append(append([]byte{}, authData...), cdHash[:]...))
+			}
+			tests := []struct {
+				name       string
+				stored     uint32
+				authData   []byte
+				clientData []byte
+				signer     *testAuthenticator
+				userHandle []byte
+				wantErr    string
+			}{
+				{name: "valid", stored: 4, authData: a.authData(rpID, authFlagUserPresent, 5, false), userHandle: handle},
+				{name: "no user handle", stored: 4, authData: a.authData(rpID, authFlagUserPresent|authFlagUserVerified, 5, false)},
+				{name: "synced passkey counts stay 0", authData: a.authData(rpID, authFlagUserPresent, 0, false)},
+				{name: "wrong rp id hash", stored: 4, authData: a.authData("evil.example", authFlagUserPresent, 5, false), wantErr: "rp id mismatch"},
+				{name: "missing UP flag", stored: 4, authData: a.authData(rpID, authFlagUserVerified, 5, false), wantErr: "user not present"},
+				{name: "sign count rollback", stored: 10, authData: a.authData(rpID, authFlagUserPresent, 5, false), wantErr: "sign count"},
+				{name: "sign count replayed", stored: 5, authData: a.authData(rpID, authFlagUserPresent, 5, false), wantErr: "sign count"},
+				{name: "sign count reset to 0", stored: 5, authData: a.authData(rpID, authFlagUserPresent, 0, false), wantErr: "sign count"},
+				{name: "user handle mismatch", stored: 4, authData: a.authData(rpID, authFlagUserPresent, 5, false), userHandle: []byte("someone-else"), wantErr: "user handle"},
+				{name: "tampered client data", stored: 4, authData: a.authData(rpID, authFlagUserPresent, 5, false), clientData: []byte(`{"type":"webauthn.get"}`), wantErr: "invalid signature"},
+				{name: "another key", stored: 4, authData: a.authData(rpID, authFlagUserPresent, 5, false), signer: other, wantErr: "invalid signature"},
+				{name: "truncated auth data", stored: 4, authData: a.authData(rpID, authFlagUserPresent, 5, false)[:30], wantErr: "too short"},
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					signer := a
+					if tt.signer != nil {
+						signer = tt.signer
+					}
+					sig := assert(signer, tt.authData)
+					cd := clientData
+					if tt.clientData != nil {
+						cd = tt.clientData
+					}
+					passkey := &Passkey{ID: 1, PublicKey: a.cose, SignCount: tt.stored}
+
+					ad, err := verifyPasskeyAssertion(passkey, handle, rpID, tt.authData, cd, sig, tt.userHandle)
+					if tt.wantErr != "" {
+						if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+							t.Fatalf("got error %v, want %q", err, tt.wantErr)
+						}
+						return
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					if want := binary.BigEndian.Uint32(tt.authData[33:37]); ad.SignCount != want {
+						t.Fatalf("sign count %d, want %d", ad.SignCount, want)
+					}
+				})
+			}
+		})
+	}
+}